@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"chin/internal/archive"
+	"strings"
+)
+
+// conflictSummary collects what unpack did with files that already existed.
+type conflictSummary struct {
+	names map[archive.ConflictPolicy][]string
+}
+
+// summaryLimit is the number of names printed for each action.
+const summaryLimit = 10
+
+func (s *conflictSummary) record(name string, action archive.ConflictPolicy, path string) {
+	if s.names == nil {
+		s.names = make(map[archive.ConflictPolicy][]string)
+	}
+	if action == archive.ConflictRename {
+		name = fmt.Sprintf("%s -> %s", name, path)
+	}
+	s.names[action] = append(s.names[action], name)
+}
+
+func (s *conflictSummary) print() {
+	for _, action := range []struct {
+		policy archive.ConflictPolicy
+		label  string
+	}{
+		{archive.ConflictOverwrite, "Overwritten"},
+		{archive.ConflictSkip, "Skipped (already existed)"},
+		{archive.ConflictRename, "Renamed"},
+	} {
+		names := s.names[action.policy]
+		if len(names) == 0 {
+			continue
+		}
+		fmt.Printf("%s: %d file(s)\n", action.label, len(names))
+		for i, name := range names {
+			if i == summaryLimit {
+				fmt.Printf("  ... and %d more\n", len(names)-summaryLimit)
+				break
+			}
+			fmt.Printf("  %s\n", name)
+		}
+	}
+}
+
+// conflictPrompt asks on the terminal what to do with each existing file,
+// until an answer is given for all of them.
+type conflictPrompt struct {
+	input *bufio.Reader
+	all   *archive.ConflictPolicy
+}
+
+func newConflictPrompt() (*conflictPrompt, error) {
+	if !stdinIsTerminal() {
+		return nil, errors.New("--on-conflict ask needs a terminal")
+	}
+	return &conflictPrompt{input: stdinReader}, nil
+}
+
+func (p *conflictPrompt) ask(entry archive.FileEntry, existing os.FileInfo) (archive.ConflictPolicy, error) {
+	if p.all != nil {
+		return *p.all, nil
+	}
+	fmt.Fprintf(os.Stderr, "\n'%s' exists (%d bytes, %s), the archive has %d bytes, %s\n",
+		entry.Name, existing.Size(), existing.ModTime().Format("2006-01-02 15:04"), entry.Size, entry.ModTime.Format("2006-01-02 15:04"))
+	for {
+		fmt.Fprint(os.Stderr, "Overwrite? [y]es, [n]o, [r]ename, [a]ll, n[o]ne, [q]uit: ")
+		line, err := p.input.ReadString('\n')
+		if err != nil {
+			return 0, err
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return archive.ConflictOverwrite, nil
+		case "n", "no":
+			return archive.ConflictSkip, nil
+		case "r", "rename":
+			return archive.ConflictRename, nil
+		case "a", "all":
+			return p.always(archive.ConflictOverwrite), nil
+		case "o", "none":
+			return p.always(archive.ConflictSkip), nil
+		case "q", "quit":
+			return archive.ConflictFail, nil
+		}
+	}
+}
+
+// always gives the same answer for the remaining files.
+func (p *conflictPrompt) always(policy archive.ConflictPolicy) archive.ConflictPolicy {
+	p.all = &policy
+	return policy
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	joinOutput   string
+	joinSign     string
+	joinPassword passwordFlags
+	joinIdentity []string
+	joinKeyfiles []string
+)
+
+var joinCmd = &cobra.Command{
+	Use:   "join [archive.chin]",
+	Short: "Join the parts of a split archive into a single file",
+	Long: `Write a split archive again as a single file. The archive is copied byte
+for byte, so no credentials are needed and its signature is kept. Independent
+volumes are copied entry by entry instead: file streams are still copied as
+stored, but the credentials are needed to rewrite the encrypted metadata.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if joinOutput == "" {
+			fmt.Println("join requires --output")
+			os.Exit(1)
+		}
+		rewriteArchives([]string{ensureChinExtension(args[0])}, rewriteOptions{
+			output:     joinOutput,
+			sign:       joinSign,
+			password:   &joinPassword,
+			identities: joinIdentity,
+			keyfiles:   joinKeyfiles,
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(joinCmd)
+	joinCmd.Flags().StringVarP(&joinOutput, "output", "o", "", "Output archive path")
+	joinCmd.Flags().StringVar(&joinSign, "sign", "", "Sign the new archive with an Ed25519 key file")
+	addPasswordFlags(joinCmd, &joinPassword, "Password for decryption (only with independent volumes)")
+	joinCmd.Flags().StringArrayVarP(&joinIdentity, "identity", "i", nil, "X25519 secret key file for archives packed with --recipient (repeatable)")
+	joinCmd.Flags().StringArrayVar(&joinKeyfiles, "keyfile", nil, "Keyfile required by the archive (repeatable)")
+}
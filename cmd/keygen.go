@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"chin/internal/crypto"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	keygenOutput string
+	keygenSign   bool
+)
+
+var keygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Generate an X25519 key pair for --recipient / --identity, or an Ed25519 signing key with --sign",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var publicText, secretText string
+		if keygenSign {
+			seed, public, err := crypto.GenerateEd25519()
+			if err != nil {
+				fmt.Printf("Error generating key: %v\n", err)
+				os.Exit(1)
+			}
+			publicText, secretText = crypto.EncodeSigningPublicKey(public), crypto.EncodeSigningSecretKey(seed)
+		} else {
+			private, public, err := crypto.GenerateX25519()
+			if err != nil {
+				fmt.Printf("Error generating key: %v\n", err)
+				os.Exit(1)
+			}
+			publicText, secretText = crypto.EncodePublicKey(public), crypto.EncodeSecretKey(private)
+		}
+
+		content := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n",
+			time.Now().Format(time.RFC3339), publicText, secretText)
+
+		if keygenOutput == "" {
+			fmt.Print(content)
+			return
+		}
+
+		// O_EXCL: never overwrite an existing identity
+		f, err := os.OpenFile(keygenOutput, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			fmt.Printf("Error writing key: %v\n", err)
+			os.Exit(1)
+		}
+		if _, err := f.WriteString(content); err != nil {
+			f.Close()
+			fmt.Printf("Error writing key: %v\n", err)
+			os.Exit(1)
+		}
+		if err := f.Close(); err != nil {
+			fmt.Printf("Error writing key: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Public key: %s\n", publicText)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(keygenCmd)
+	keygenCmd.Flags().StringVarP(&keygenOutput, "output", "o", "", "Write the secret key to this file (mode 0600) instead of stdout")
+	keygenCmd.Flags().BoolVar(&keygenSign, "sign", false, "Generate an Ed25519 signing key for 'pack --sign' / 'verify --trusted-key'")
+}
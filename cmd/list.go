@@ -1,15 +1,32 @@
 package cmd
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"chin/internal/archive"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
-var listPassword string
+var (
+	listPassword passwordFlags
+	listIdentity []string
+	listKeyfiles []string
+	listLong     bool
+	listHuman    bool
+	listTree     bool
+	listSort     string
+	listFormat   string
+)
 
 var listCmd = &cobra.Command{
 	Use:   "list [archive.chin]",
@@ -18,31 +35,330 @@ var listCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		input := ensureChinExtension(args[0])
 
-		reader, err := archive.NewReader(input, listPassword)
+		switch listFormat {
+		case "", "json", "csv", "ndjson":
+		default:
+			fmt.Printf("Unknown --format %q (use json, csv or ndjson)\n", listFormat)
+			os.Exit(1)
+		}
+		if listTree && listFormat != "" {
+			fmt.Println("--tree cannot be combined with --format")
+			os.Exit(1)
+		}
+		if listTree && listLong {
+			fmt.Println("--tree cannot be combined with -l")
+			os.Exit(1)
+		}
+
+		credentials, err := loadCredentials(input, &listPassword, listIdentity, listKeyfiles)
+		if err != nil {
+			fmt.Printf("Error reading credentials: %v\n", err)
+			os.Exit(1)
+		}
+
+		reader, err := archive.NewReaderWithCredentials(input, credentials)
+		credentials.Wipe()
 		if err != nil {
 			fmt.Printf("Error opening archive: %v\n", err)
 			os.Exit(1)
 		}
 		defer reader.Close()
 
-		files := reader.ListFiles()
-		
-		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-		fmt.Fprintln(w, "MODE\tSIZE\tNAME")
-		for _, f := range files {
-			modeStr := "FILE"
-			if f.IsDir {
-				modeStr = "DIR "
+		entries := reader.ListDetails()
+		if err := sortEntries(entries, listSort); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if listFormat != "" {
+			// Keep stdout parseable
+			if volume := reader.Volume(); volume != nil {
+				fmt.Fprintf(os.Stderr, "Note: reading part %d of %d on its own, files stored in other parts are not included\n", volume.Index, volume.Count)
+			}
+			if err := printStructured(os.Stdout, entries, listFormat); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing listing: %v\n", err)
+				os.Exit(1)
 			}
-			fmt.Fprintf(w, "%s\t%d\t%s\n", modeStr, f.Size, f.Name)
+			return
 		}
-		w.Flush()
-		
-		fmt.Printf("\nTotal: %d files\n", len(files))
+
+		printVolumeNote(reader)
+		if listTree {
+			printTree(os.Stdout, entries)
+		} else {
+			printTable(entries)
+		}
+
+		var files int
+		var size, stored int64
+		for _, entry := range entries {
+			if !entry.IsDir {
+				files++
+				size += int64(entry.Size)
+				stored += max(entry.StoredSize, 0)
+			}
+		}
+		fmt.Printf("\nTotal: %d files, %d folders, %s", files, len(entries)-files, listSize(size))
+		if listLong {
+			fmt.Printf(" (%s stored)", listSize(stored))
+		}
+		fmt.Println()
 	},
 }
 
+// sortEntries orders entries by name, or largest and newest first. Without a
+// key they keep the archive order.
+func sortEntries(entries []archive.EntryDetails, key string) error {
+	var less func(a, b archive.EntryDetails) bool
+	switch key {
+	case "":
+		return nil
+	case "name":
+		less = func(a, b archive.EntryDetails) bool { return a.Name < b.Name }
+	case "size":
+		less = func(a, b archive.EntryDetails) bool { return a.Size > b.Size }
+	case "time":
+		less = func(a, b archive.EntryDetails) bool { return a.ModTime.After(b.ModTime) }
+	default:
+		return fmt.Errorf("unknown --sort %q (use name, size or time)", key)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return less(entries[i], entries[j]) })
+	return nil
+}
+
+// listSize prints a size in bytes, or with a unit with -h.
+func listSize(n int64) string {
+	if listHuman {
+		return formatSize(n)
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// permissions returns the mode of an entry as ls prints it, such as drwxr-xr-x.
+func permissions(entry archive.EntryDetails) string {
+	mode := os.FileMode(entry.Mode)
+	if entry.IsDir {
+		mode |= os.ModeDir
+	}
+	return mode.String()
+}
+
+// flagNames returns the names of the flags set on an entry.
+func flagNames(entry archive.EntryDetails) []string {
+	names := []string{}
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{
+		{entry.Encrypted, "encrypted"},
+		{entry.Padded, "padded"},
+		{entry.Spans(), "spans-parts"},
+	} {
+		if flag.set {
+			names = append(names, flag.name)
+		}
+	}
+	return names
+}
+
+// flagLetters returns the flags of an entry for -l: e(ncrypted), p(added) and
+// s(pans parts), or - when not set.
+func flagLetters(entry archive.EntryDetails) string {
+	letters := []byte("---")
+	if entry.Encrypted {
+		letters[0] = 'e'
+	}
+	if entry.Padded {
+		letters[1] = 'p'
+	}
+	if entry.Spans() {
+		letters[2] = 's'
+	}
+	return string(letters)
+}
+
+func checksum(entry archive.EntryDetails) string {
+	if entry.IsDir {
+		return ""
+	}
+	return fmt.Sprintf("%016x", entry.Checksum)
+}
+
+func storedSize(entry archive.EntryDetails) string {
+	if entry.StoredSize < 0 {
+		return "?"
+	}
+	return listSize(entry.StoredSize)
+}
+
+func printTable(entries []archive.EntryDetails) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if listLong {
+		fmt.Fprintln(w, "PERMISSIONS\tMODIFIED\tSIZE\tSTORED\tCHECKSUM\tFLAGS\tNAME")
+	} else {
+		fmt.Fprintln(w, "MODE\tSIZE\tNAME")
+	}
+	for _, entry := range entries {
+		if listLong {
+			sum := checksum(entry)
+			if sum == "" {
+				sum = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", permissions(entry), entry.ModTime.Local().Format("2006-01-02 15:04:05"),
+				listSize(int64(entry.Size)), storedSize(entry), sum, flagLetters(entry), entry.Name)
+			continue
+		}
+		modeStr := "FILE"
+		if entry.IsDir {
+			modeStr = "DIR "
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", modeStr, listSize(int64(entry.Size)), entry.Name)
+	}
+	w.Flush()
+}
+
+// treeNode is a folder or file of the tree printed by --tree.
+type treeNode struct {
+	name     string
+	entry    *archive.EntryDetails // nil for a folder only implied by the paths below it
+	children []*treeNode
+	index    map[string]*treeNode
+}
+
+func (n *treeNode) child(name string) *treeNode {
+	if c, ok := n.index[name]; ok {
+		return c
+	}
+	c := &treeNode{name: name, index: map[string]*treeNode{}}
+	n.index[name] = c
+	n.children = append(n.children, c)
+	return c
+}
+
+// buildTree returns the root of the folders and files of entries, children in
+// the order of entries.
+func buildTree(entries []archive.EntryDetails) *treeNode {
+	root := &treeNode{index: map[string]*treeNode{}}
+	for i := range entries {
+		node := root
+		for _, part := range strings.Split(path.Clean(entries[i].Name), "/") {
+			node = node.child(part)
+		}
+		node.entry = &entries[i]
+	}
+	return root
+}
+
+// printTree prints the entries to out as a tree of folders.
+func printTree(out io.Writer, entries []archive.EntryDetails) {
+	root := buildTree(entries)
+	for i, child := range root.children {
+		printTreeNode(out, child, "", i == len(root.children)-1)
+	}
+}
+
+func printTreeNode(out io.Writer, node *treeNode, prefix string, last bool) {
+	branch, indent := "├── ", "│   "
+	if last {
+		branch, indent = "└── ", "    "
+	}
+	label := node.name
+	if node.entry == nil || node.entry.IsDir {
+		label += "/"
+	} else {
+		label += fmt.Sprintf(" (%s)", listSize(int64(node.entry.Size)))
+	}
+	fmt.Fprintf(out, "%s%s%s\n", prefix, branch, label)
+	for i, child := range node.children {
+		printTreeNode(out, child, prefix+indent, i == len(node.children)-1)
+	}
+}
+
+// listEntry is an entry in --format json, csv and ndjson.
+type listEntry struct {
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	Size        uint64    `json:"size"`
+	StoredSize  *int64    `json:"stored_size"` // null if unknown
+	Mode        string    `json:"mode"`
+	Permissions string    `json:"permissions"`
+	ModTime     time.Time `json:"mtime"`
+	Checksum    string    `json:"checksum,omitempty"`
+	Flags       []string  `json:"flags"`
+	FirstPart   int       `json:"first_part"`
+	LastPart    int       `json:"last_part"`
+}
+
+func newListEntry(entry archive.EntryDetails) listEntry {
+	e := listEntry{
+		Name:        entry.Name,
+		Type:        "file",
+		Size:        entry.Size,
+		Mode:        fmt.Sprintf("%04o", os.FileMode(entry.Mode).Perm()),
+		Permissions: permissions(entry),
+		ModTime:     entry.ModTime.UTC(),
+		Checksum:    checksum(entry),
+		Flags:       flagNames(entry),
+		FirstPart:   entry.FirstPart,
+		LastPart:    entry.LastPart,
+	}
+	if entry.IsDir {
+		e.Type = "dir"
+	}
+	if entry.StoredSize >= 0 {
+		stored := entry.StoredSize
+		e.StoredSize = &stored
+	}
+	return e
+}
+
+// printStructured writes entries to out as JSON, CSV or NDJSON, with sizes in bytes.
+func printStructured(out io.Writer, entries []archive.EntryDetails, format string) error {
+	switch format {
+	case "json":
+		list := make([]listEntry, len(entries))
+		for i, entry := range entries {
+			list[i] = newListEntry(entry)
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(list)
+	case "ndjson":
+		enc := json.NewEncoder(out)
+		for _, entry := range entries {
+			if err := enc.Encode(newListEntry(entry)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	w := csv.NewWriter(out)
+	w.Write([]string{"name", "type", "size", "stored_size", "mode", "permissions", "mtime", "checksum", "flags", "first_part", "last_part"})
+	for _, entry := range entries {
+		e := newListEntry(entry)
+		stored := ""
+		if e.StoredSize != nil {
+			stored = strconv.FormatInt(*e.StoredSize, 10)
+		}
+		w.Write([]string{e.Name, e.Type, strconv.FormatUint(e.Size, 10), stored, e.Mode, e.Permissions,
+			e.ModTime.Format(time.RFC3339), e.Checksum, strings.Join(e.Flags, " "),
+			strconv.Itoa(e.FirstPart), strconv.Itoa(e.LastPart)})
+	}
+	w.Flush()
+	return w.Error()
+}
+
 func init() {
 	rootCmd.AddCommand(listCmd)
-	listCmd.Flags().StringVarP(&listPassword, "password", "p", "", "Password for decryption")
+	addPasswordFlags(listCmd, &listPassword, "Password for decryption")
+	listCmd.Flags().StringArrayVarP(&listIdentity, "identity", "i", nil, "X25519 secret key file for archives packed with --recipient (repeatable)")
+	listCmd.Flags().StringArrayVar(&listKeyfiles, "keyfile", nil, "Keyfile required by the archive (repeatable)")
+	listCmd.Flags().BoolVarP(&listLong, "long", "l", false, "Show permissions, modification time, stored size, checksum and flags (e: encrypted, p: padded, s: spans parts)")
+	// -h is taken by --human-readable, like ls; help stays available as --help
+	listCmd.Flags().Bool("help", false, "help for list")
+	listCmd.Flags().BoolVarP(&listHuman, "human-readable", "h", false, "Print sizes with units (KB, MB, GB)")
+	listCmd.Flags().BoolVar(&listTree, "tree", false, "Show the entries as a tree of folders (not with -l)")
+	listCmd.Flags().StringVar(&listSort, "sort", "", "Sort by name, size (largest first) or time (newest first) instead of archive order")
+	listCmd.Flags().StringVar(&listFormat, "format", "", "Print json, csv or ndjson for scripts instead of a table (sizes in bytes)")
 }
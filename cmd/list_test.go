@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"chin/internal/archive"
+)
+
+func listFixture() []archive.EntryDetails {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	entry := func(name string, size uint64, age int, dir bool) archive.EntryDetails {
+		return archive.EntryDetails{
+			FileEntry:  archive.FileEntry{Name: name, Size: size, Mode: 0644, ModTime: base.Add(-time.Duration(age) * time.Hour), IsDir: dir},
+			StoredSize: int64(size),
+		}
+	}
+	return []archive.EntryDetails{
+		entry("src", 0, 3, true),
+		entry("src/b.txt", 30, 2, false),
+		entry("src/a.txt", 10, 0, false),
+		entry("docs/c,d.txt", 20, 1, false),
+	}
+}
+
+func names(entries []archive.EntryDetails) string {
+	list := make([]string, len(entries))
+	for i, entry := range entries {
+		list[i] = entry.Name
+	}
+	return strings.Join(list, " ")
+}
+
+func TestSortEntries(t *testing.T) {
+	cases := map[string]string{
+		"":     "src src/b.txt src/a.txt docs/c,d.txt",
+		"name": "docs/c,d.txt src src/a.txt src/b.txt",
+		"size": "src/b.txt docs/c,d.txt src/a.txt src",
+		"time": "src/a.txt docs/c,d.txt src/b.txt src",
+	}
+	for key, want := range cases {
+		entries := listFixture()
+		if err := sortEntries(entries, key); err != nil {
+			t.Fatal(err)
+		}
+		if got := names(entries); got != want {
+			t.Errorf("--sort %q: got %s, want %s", key, got, want)
+		}
+	}
+
+	if err := sortEntries(listFixture(), "owner"); err == nil {
+		t.Fatal("unknown sort key accepted")
+	}
+}
+
+// TestPrintTree checks folders implied by paths only and the order of children
+func TestPrintTree(t *testing.T) {
+	root := buildTree(listFixture())
+	if len(root.children) != 2 || root.children[1].name != "docs" || root.children[1].entry != nil {
+		t.Fatalf("unexpected top level: %+v", root.children)
+	}
+
+	var out bytes.Buffer
+	printTree(&out, listFixture())
+	want := "├── src/\n" +
+		"│   ├── b.txt (30)\n" +
+		"│   └── a.txt (10)\n" +
+		"└── docs/\n" +
+		"    └── c,d.txt (20)\n"
+	if out.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestPrintStructuredJSON(t *testing.T) {
+	entries := listFixture()
+	entries[0].StoredSize = -1
+
+	var out bytes.Buffer
+	if err := printStructured(&out, entries, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var list []map[string]any
+	if err := json.Unmarshal(out.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != len(entries) {
+		t.Fatalf("expected %d entries, got %d", len(entries), len(list))
+	}
+	for _, field := range []string{"name", "type", "size", "stored_size", "mode", "permissions", "mtime", "flags", "first_part", "last_part"} {
+		if _, ok := list[1][field]; !ok {
+			t.Errorf("field %s missing", field)
+		}
+	}
+	if list[0]["type"] != "dir" || list[0]["stored_size"] != nil {
+		t.Fatalf("unexpected folder: %v", list[0])
+	}
+	if _, ok := list[0]["checksum"]; ok {
+		t.Fatal("folder has a checksum")
+	}
+	if list[1]["mtime"] != "2024-05-01T10:00:00Z" || list[1]["mode"] != "0644" {
+		t.Fatalf("unexpected file: %v", list[1])
+	}
+
+	out.Reset()
+	if err := printStructured(&out, entries, "ndjson"); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(out.String(), "\n"); lines != len(entries) {
+		t.Fatalf("expected %d lines, got %d", len(entries), lines)
+	}
+}
+
+func TestPrintStructuredCSV(t *testing.T) {
+	var out bytes.Buffer
+	if err := printStructured(&out, listFixture(), "csv"); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(records[0], ","); got != "name,type,size,stored_size,mode,permissions,mtime,checksum,flags,first_part,last_part" {
+		t.Fatalf("unexpected header: %s", got)
+	}
+	if len(records) != 5 {
+		t.Fatalf("expected 5 records, got %d", len(records))
+	}
+	// The comma is quoted, so the name stays one field
+	if records[4][0] != "docs/c,d.txt" || records[4][2] != "20" {
+		t.Fatalf("unexpected record: %v", records[4])
+	}
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	mergeOutput      string
+	mergeSplit       string
+	mergeIndependent bool
+	mergeSign        string
+	mergePassword    passwordFlags
+	mergeIdentity    []string
+	mergeKeyfiles    []string
+)
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge [archive.chin...]",
+	Short: "Merge archives into a new one",
+	Long: `Write the entries of several archives into a new one, which keeps the
+encryption, passwords and recipients of the first archive. Streams stored
+with the same key are copied as they are; the others are decrypted and
+encrypted again with the key of the first archive, in memory. Directories
+found in several archives are kept once, a file found twice is an error.
+
+The credentials are tried on every archive; on a terminal, the password of an
+archive they do not open is asked for.`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if mergeOutput == "" {
+			fmt.Println("merge requires --output")
+			os.Exit(1)
+		}
+		var inputs []string
+		for _, arg := range args {
+			inputs = append(inputs, ensureChinExtension(arg))
+		}
+		rewriteArchives(inputs, rewriteOptions{
+			output:      mergeOutput,
+			split:       mergeSplit,
+			independent: mergeIndependent,
+			sign:        mergeSign,
+			password:    &mergePassword,
+			identities:  mergeIdentity,
+			keyfiles:    mergeKeyfiles,
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mergeCmd)
+	mergeCmd.Flags().StringVarP(&mergeOutput, "output", "o", "", "Output archive path")
+	mergeCmd.Flags().StringVar(&mergeSplit, "split", "", "Split the new archive (e.g. 10MB, 1GB)")
+	mergeCmd.Flags().BoolVar(&mergeIndependent, "independent-volumes", false, "Cut parts between files and give each part its own index (with --split)")
+	mergeCmd.Flags().StringVar(&mergeSign, "sign", "", "Sign the new archive with an Ed25519 key file")
+	addPasswordFlags(mergeCmd, &mergePassword, "Password for decryption")
+	mergeCmd.Flags().StringArrayVarP(&mergeIdentity, "identity", "i", nil, "X25519 secret key file for archives packed with --recipient (repeatable)")
+	mergeCmd.Flags().StringArrayVar(&mergeKeyfiles, "keyfile", nil, "Keyfile required by the archive (repeatable)")
+}
@@ -1,10 +1,16 @@
 package cmd
 
 import (
+	"crypto/ed25519"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"chin/internal/archive"
+	"chin/internal/crypto"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -14,9 +20,31 @@ import (
 )
 
 var (
-	packOutput   string
-	packPassword string
-	packSplit    string
+	packOutput         string
+	packPassword       passwordFlags
+	packAskPassword    bool
+	packSplit          string
+	packInclude        []string
+	packExclude        []string
+	packExcludeVCS     bool
+	packNewerThan      string
+	packMaxFileSize    string
+	packNoIgnore       bool
+	packReproducible   bool
+	packNormalizeModes bool
+	packSaltSeed       string
+	packJobs           int
+	packKDF            kdfFlags
+	packCipher         string
+	packRecipients     []string
+	packKeyfiles       []string
+	packSign           string
+	packHideMetadata   bool
+	packIndependent    bool
+	packJournal        bool
+	packResume         bool
+	packIdentity       []string
+	packDryRun         bool
 )
 
 func parseSize(s string) (int64, error) {
@@ -24,6 +52,10 @@ func parseSize(s string) (int64, error) {
 		return 0, nil
 	}
 	s = strings.ToUpper(s)
+	// KiB/MiB/GiB are accepted as aliases, KB/MB/GB are already binary units
+	if strings.HasSuffix(s, "IB") {
+		s = strings.TrimSuffix(s, "IB") + "B"
+	}
 	multiplier := int64(1)
 	if strings.HasSuffix(s, "KB") {
 		multiplier = 1024
@@ -43,13 +75,114 @@ func parseSize(s string) (int64, error) {
 	return val * multiplier, nil
 }
 
-func calculateTotalSize(paths []string) (int64, error) {
+// parseTime accepts a date (2006-01-02), an RFC 3339 timestamp or an age such as 36h or 7d.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err == nil {
+			return time.Now().AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+// reproducibleOptions reads SOURCE_DATE_EPOCH and the related pack flags.
+func reproducibleOptions() (archive.ReproducibleOptions, error) {
+	opts := archive.ReproducibleOptions{
+		NormalizeModes: packNormalizeModes,
+		SaltSeed:       []byte(packSaltSeed),
+	}
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		sec, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return opts, fmt.Errorf("invalid SOURCE_DATE_EPOCH: %w", err)
+		}
+		opts.SourceDate = time.Unix(sec, 0)
+	}
+	return opts, nil
+}
+
+// kdfFlags are the --kdf flags of the commands that derive keys from a password.
+type kdfFlags struct {
+	name        string
+	memory      string
+	iterations  uint32
+	parallelism uint8
+}
+
+func addKDFFlags(cmd *cobra.Command, f *kdfFlags, usage string) {
+	cmd.Flags().StringVar(&f.name, "kdf", "pbkdf2", usage+": pbkdf2 or argon2id")
+	cmd.Flags().StringVar(&f.memory, "kdf-memory", "", "Argon2id memory cost (e.g. 256MiB, default 64MiB)")
+	cmd.Flags().Uint32Var(&f.iterations, "kdf-iterations", 0, "KDF iterations / time cost (default 100000 for pbkdf2, 3 for argon2id)")
+	cmd.Flags().Uint8Var(&f.parallelism, "kdf-parallelism", 0, "Argon2id parallelism (default 4)")
+}
+
+// params builds the key derivation parameters from the flags.
+func (f *kdfFlags) params() (crypto.KDFParams, error) {
+	var params crypto.KDFParams
+	switch strings.ToLower(f.name) {
+	case "pbkdf2":
+		params = crypto.DefaultKDF()
+	case "argon2id":
+		params = crypto.DefaultArgon2id()
+	default:
+		return params, fmt.Errorf("unknown --kdf %q (use pbkdf2 or argon2id)", f.name)
+	}
+
+	if f.iterations > 0 {
+		params.Iterations = f.iterations
+	}
+	if f.memory != "" {
+		if params.Algorithm != crypto.KDFArgon2id {
+			return params, fmt.Errorf("--kdf-memory requires --kdf argon2id")
+		}
+		memory, err := parseSize(f.memory)
+		if err != nil {
+			return params, fmt.Errorf("invalid --kdf-memory: %w", err)
+		}
+		params.Memory = uint32(memory / 1024)
+	}
+	if f.parallelism > 0 {
+		params.Parallelism = f.parallelism
+	}
+
+	return params, params.Validate()
+}
+
+func buildFilter() (*archive.Filter, error) {
+	newerThan, err := parseTime(packNewerThan)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --newer-than: %w", err)
+	}
+	maxFileSize, err := parseSize(packMaxFileSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --max-file-size: %w", err)
+	}
+
+	return &archive.Filter{
+		Include:     packInclude,
+		Exclude:     packExclude,
+		ExcludeVCS:  packExcludeVCS,
+		NewerThan:   newerThan,
+		MaxFileSize: maxFileSize,
+		IgnoreFiles: !packNoIgnore,
+	}, nil
+}
+
+func calculateTotalSize(paths []string, filter *archive.Filter) (int64, error) {
 	var totalSize int64
 	for _, path := range paths {
-		err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
+		path = filepath.Clean(path)
+		err := archive.Walk(path, filepath.Base(path), filter, func(_, _ string, info os.FileInfo) error {
 			if !info.IsDir() {
 				totalSize += info.Size()
 			}
@@ -81,21 +214,188 @@ var packCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		// Calculate total size
-		totalSize, err := calculateTotalSize(args)
+		filter, err := buildFilter()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		var reproducible archive.ReproducibleOptions
+		if packReproducible {
+			reproducible, err = reproducibleOptions()
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			// Canonical input order
+			sort.Strings(args)
+		} else if packNormalizeModes || packSaltSeed != "" {
+			fmt.Println("--normalize-modes and --salt-seed require --reproducible")
+			os.Exit(1)
+		}
+
+		kdf, err := packKDF.params()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		aead, err := crypto.ParseCipher(packCipher)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		recipients, err := parseRecipients(packRecipients)
 		if err != nil {
-			fmt.Printf("Error calculating size: %v\n", err)
+			fmt.Println(err)
 			os.Exit(1)
 		}
 
-		fmt.Printf("Packing %d input(s) to '%s' (Split: %v)...\n", len(args), packOutput, packSplit)
+		keyfiles, err := readKeyfiles(packKeyfiles)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		var signingKey ed25519.PrivateKey
+		if packSign != "" {
+			signingKey, err = readSigningKey(packSign)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
 
-		writer, err := archive.NewWriter(packOutput, packPassword, splitSize)
+		password, err := packPassword.resolve()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if packAskPassword {
+			if len(password) > 0 {
+				fmt.Println("--ask-password cannot be combined with another password source")
+				os.Exit(1)
+			}
+			password, err = promptPassword("Password: ", true, false)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+
+		if packHideMetadata && len(password) == 0 && len(recipients) == 0 && len(keyfiles) == 0 {
+			fmt.Println("--hide-metadata requires encryption (--password, --recipient or --keyfile)")
+			os.Exit(1)
+		}
+
+		if packIndependent && splitSize == 0 {
+			fmt.Println("--independent-volumes requires --split")
+			os.Exit(1)
+		}
+
+		if len(packIdentity) > 0 && !packResume {
+			fmt.Println("--identity is only used with --resume")
+			os.Exit(1)
+		}
+		identities, err := readIdentities(packIdentity)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if packDryRun && (packResume || packJournal) {
+			fmt.Println("--dry-run cannot be combined with --journal or --resume")
+			os.Exit(1)
+		}
+
+		var totalSize int64
+		if !packDryRun {
+			totalSize, err = calculateTotalSize(args, filter)
+			if err != nil {
+				fmt.Printf("Error calculating size: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Packing %d input(s) to '%s' (Split: %v)...\n", len(args), packOutput, packSplit)
+		}
+
+		var writer *archive.Writer
+		if packDryRun {
+			writer, err = archive.NewDryRunWriter(password, splitSize)
+		} else if packResume {
+			writer, err = archive.ResumeWriter(packOutput, password, splitSize)
+			if errors.Is(err, fs.ErrNotExist) {
+				fmt.Printf("No interrupted pack of '%s' to resume: %v\n", packOutput, err)
+				os.Exit(1)
+			}
+		} else {
+			writer, err = archive.NewWriter(packOutput, password, splitSize)
+		}
 		if err != nil {
 			fmt.Printf("Error creating archive: %v\n", err)
 			os.Exit(1)
 		}
 		defer writer.Close()
+		if packJournal && !packResume {
+			if err := writer.SetJournal(true); err != nil {
+				fmt.Printf("Error creating archive: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		for _, identity := range identities {
+			if err := writer.AddIdentity(identity); err != nil {
+				fmt.Printf("Error creating archive: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if err := writer.SetKDF(kdf); err != nil {
+			fmt.Printf("Error creating archive: %v\n", err)
+			os.Exit(1)
+		}
+		if err := writer.SetCipher(aead); err != nil {
+			fmt.Printf("Error creating archive: %v\n", err)
+			os.Exit(1)
+		}
+		for _, recipient := range recipients {
+			if err := writer.AddRecipient(recipient); err != nil {
+				fmt.Printf("Error creating archive: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		for _, keyfile := range keyfiles {
+			if err := writer.AddKeyfile(keyfile); err != nil {
+				fmt.Printf("Error creating archive: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if signingKey != nil {
+			writer.SetSigningKey(signingKey)
+		}
+		// The writer holds its own copies from here on
+		crypto.Wipe(password)
+		crypto.WipeAll(keyfiles)
+		crypto.WipeAll(identities)
+		crypto.Wipe(signingKey)
+		if err := writer.SetHideMetadata(packHideMetadata); err != nil {
+			fmt.Printf("Error creating archive: %v\n", err)
+			os.Exit(1)
+		}
+		if packIndependent {
+			if err := writer.SetIndependentVolumes(true); err != nil {
+				fmt.Printf("Error creating archive: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		writer.Filter = filter
+		writer.Jobs = packJobs
+		if packReproducible {
+			writer.SetReproducible(reproducible)
+		}
+
+		if packDryRun {
+			printPackPlan(writer, args)
+			return
+		}
 
 		bar := progressbar.DefaultBytes(
 			totalSize,
@@ -118,24 +418,88 @@ var packCmd = &cobra.Command{
 			input = filepath.Clean(input)
 			err = writer.AddFile(input, filepath.Base(input))
 			if err != nil {
-				fmt.Printf("Error adding '%s': %v\n", input, err)
-				os.Exit(1)
+				fmt.Printf("\nError adding '%s': %v\n", input, err)
+				exitResumable(writer)
 			}
 		}
 
-		if err := writer.Finalize(packPassword); err != nil {
-			fmt.Printf("Error finalizing archive: %v\n", err)
-			os.Exit(1)
+		if err := writer.Finalize(); err != nil {
+			fmt.Printf("\nError finalizing archive: %v\n", err)
+			exitResumable(writer)
 		}
 		
 		bar.Finish()
+		if packResume {
+			fmt.Printf("\nKept %d entries from the interrupted pack", writer.Resumed())
+		}
 		fmt.Printf("\nDone in %v\n", time.Since(start))
 	},
 }
 
+// exitResumable records the entries packed so far in the journal, if any, and exits.
+func exitResumable(writer *archive.Writer) {
+	writer.Close()
+	if packJournal || packResume {
+		fmt.Println("Fix the problem and run the same command with --resume to go on from the last stored file")
+	}
+	os.Exit(1)
+}
+
+// printPackPlan walks the inputs with a dry run writer and reports what pack would store.
+func printPackPlan(writer *archive.Writer, inputs []string) {
+	for _, input := range inputs {
+		input = filepath.Clean(input)
+		if err := writer.AddFile(input, filepath.Base(input)); err != nil {
+			fmt.Printf("Error reading '%s': %v\n", input, err)
+			os.Exit(1)
+		}
+	}
+	plan, err := writer.Plan()
+	if err != nil {
+		fmt.Printf("Error planning archive: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, entry := range plan.Entries {
+		if entry.IsDir {
+			fmt.Printf("  %10s  %s/\n", "", entry.Name)
+		} else {
+			fmt.Printf("  %10s  %s\n", formatSize(int64(entry.Size)), entry.Name)
+		}
+	}
+	fmt.Printf("\nDry run: would pack %d file(s) and %d folder(s), %s, to '%s'\n", plan.Files, plan.Dirs, formatSize(plan.Size), packOutput)
+	if packSplit != "" {
+		fmt.Printf("Expected archive size: %s in %d part(s) of at most %s\n", formatSize(plan.ArchiveSize), plan.Parts, packSplit)
+	} else {
+		fmt.Printf("Expected archive size: %s\n", formatSize(plan.ArchiveSize))
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(packCmd)
 	packCmd.Flags().StringVarP(&packOutput, "output", "o", "", "Output archive path")
-	packCmd.Flags().StringVarP(&packPassword, "password", "p", "", "Password for encryption")
+	addPasswordFlags(packCmd, &packPassword, "Password for encryption")
+	packCmd.Flags().BoolVar(&packAskPassword, "ask-password", false, "Prompt for the password (no echo, asked twice)")
 	packCmd.Flags().StringVar(&packSplit, "split", "", "Split archive size (e.g. 10MB, 1GB)")
+	packCmd.Flags().StringArrayVar(&packInclude, "include", nil, "Only pack files matching this glob (supports **, repeatable)")
+	packCmd.Flags().StringArrayVar(&packExclude, "exclude", nil, "Skip files and folders matching this glob (supports **, repeatable)")
+	packCmd.Flags().BoolVar(&packExcludeVCS, "exclude-vcs", false, "Skip version control folders and files (.git, .svn, .hg, ...)")
+	packCmd.Flags().StringVar(&packNewerThan, "newer-than", "", "Only pack files modified after a date (2006-01-02) or within an age (e.g. 7d, 12h)")
+	packCmd.Flags().StringVar(&packMaxFileSize, "max-file-size", "", "Skip files larger than this size (e.g. 100MB)")
+	packCmd.Flags().BoolVar(&packNoIgnore, "no-ignore", false, "Do not read .chinignore files")
+	packCmd.Flags().BoolVar(&packReproducible, "reproducible", false, "Produce identical bytes for identical inputs (honours SOURCE_DATE_EPOCH)")
+	packCmd.Flags().BoolVar(&packNormalizeModes, "normalize-modes", false, "Store 0644/0755 instead of real permissions (with --reproducible)")
+	packCmd.Flags().StringVar(&packSaltSeed, "salt-seed", "", "Derive salts from this seed for reproducible encrypted archives (with --reproducible)")
+	packCmd.Flags().IntVarP(&packJobs, "jobs", "j", runtime.NumCPU(), "Number of files read and encrypted in parallel")
+	addKDFFlags(packCmd, &packKDF, "Key derivation function")
+	packCmd.Flags().StringVar(&packCipher, "cipher", "aes-gcm", "Encryption: aes-gcm or xchacha20 (faster on CPUs without AES instructions)")
+	packCmd.Flags().StringArrayVarP(&packRecipients, "recipient", "r", nil, "Add a key slot for an X25519 public key or a file of keys (repeatable, see 'chin keygen')")
+	packCmd.Flags().StringArrayVar(&packKeyfiles, "keyfile", nil, "Also require this file to decrypt, combined with the password (repeatable)")
+	packCmd.Flags().StringVar(&packSign, "sign", "", "Sign the archive with an Ed25519 key file (see 'chin keygen --sign')")
+	packCmd.Flags().BoolVar(&packHideMetadata, "hide-metadata", false, "Hide the file count and pad metadata and file sizes (encrypted archives only)")
+	packCmd.Flags().BoolVar(&packIndependent, "independent-volumes", false, "Cut parts between files and give each part its own index, so any part can be listed and extracted alone (with --split)")
+	packCmd.Flags().BoolVar(&packJournal, "journal", false, "Keep a journal next to the output so an interrupted pack can be resumed (syncs to disk at each checkpoint)")
+	packCmd.Flags().BoolVar(&packResume, "resume", false, "Go on with an interrupted pack of the same output, using the journal of --journal (same inputs and options)")
+	packCmd.Flags().BoolVar(&packDryRun, "dry-run", false, "List the files that would be packed, their total size and the expected number of parts, without writing anything")
+	packCmd.Flags().StringArrayVarP(&packIdentity, "identity", "i", nil, "X25519 secret key file to unlock the key slots when resuming a pack with only --recipient (repeatable)")
 }
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"chin/internal/archive"
+	"chin/internal/crypto"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	passwdPassword       passwordFlags
+	passwdIdentity       []string
+	passwdKeyfiles       []string
+	passwdList           bool
+	passwdAddPassword    string
+	passwdAddRecipients  []string
+	passwdRemoveSlots    []int
+	passwdChangePassword string
+	passwdNewKeyfiles    []string
+	passwdKDF            kdfFlags
+)
+
+// promptValue is the value of --add-password / --change-password given without "=NEW".
+const promptValue = "\x00prompt"
+
+var passwdCmd = &cobra.Command{
+	Use:   "passwd [archive.chin]",
+	Short: "List, add, remove or change the key slots of an encrypted archive",
+	Long: `Manage the key slots of an encrypted archive. Each slot wraps the random
+archive key for one password or recipient. Only the key slot area after the
+header is rewritten, so the data is never re-encrypted.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		input := ensureChinExtension(args[0])
+
+		credentials, err := loadCredentials(input, &passwdPassword, passwdIdentity, passwdKeyfiles)
+		if err != nil {
+			fmt.Printf("Error reading credentials: %v\n", err)
+			os.Exit(1)
+		}
+
+		editor, err := archive.OpenKeySlots(input, credentials)
+		credentials.Wipe()
+		if err != nil {
+			fmt.Printf("Error opening archive: %v\n", err)
+			os.Exit(1)
+		}
+		defer editor.Close()
+
+		recipients, err := parseRecipients(passwdAddRecipients)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		newKeyfiles, err := readKeyfiles(passwdNewKeyfiles)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer crypto.WipeAll(newKeyfiles)
+
+		// Passwords for new slots, in flag order
+		var newPasswords [][]byte
+		defer func() { crypto.WipeAll(newPasswords) }()
+		for _, name := range []string{"add-password", "change-password"} {
+			flag := cmd.Flags().Lookup(name)
+			if !flag.Changed {
+				continue
+			}
+			password := []byte(flag.Value.String())
+			if string(password) == promptValue {
+				prompt := "New password: "
+				if len(newKeyfiles) > 0 {
+					prompt = "New password (Enter for keyfile only): "
+				}
+				password, err = promptPassword(prompt, true, len(newKeyfiles) > 0)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+			}
+			newPasswords = append(newPasswords, password)
+		}
+
+		changed := false
+		if len(newPasswords) > 0 {
+			kdf, err := passwdKDF.params()
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			for _, password := range newPasswords {
+				if err := editor.AddPassword(password, newKeyfiles, kdf); err != nil {
+					fmt.Printf("Error adding password: %v\n", err)
+					os.Exit(1)
+				}
+				changed = true
+			}
+		}
+		for _, recipient := range recipients {
+			if err := editor.AddRecipient(recipient); err != nil {
+				fmt.Printf("Error adding recipient: %v\n", err)
+				os.Exit(1)
+			}
+			changed = true
+		}
+
+		// Remove from the highest index so earlier indexes stay valid
+		remove := append([]int(nil), passwdRemoveSlots...)
+		if cmd.Flags().Changed("change-password") {
+			remove = append(remove, editor.Unlocked())
+		}
+		for len(remove) > 0 {
+			highest := 0
+			for i := range remove {
+				if remove[i] > remove[highest] {
+					highest = i
+				}
+			}
+			if err := editor.Remove(remove[highest]); err != nil {
+				fmt.Printf("Error removing slot: %v\n", err)
+				os.Exit(1)
+			}
+			remove = append(remove[:highest], remove[highest+1:]...)
+			changed = true
+		}
+
+		if changed {
+			if err := editor.Save(); err != nil {
+				fmt.Printf("Error writing key slots: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println("Key slots updated.")
+		}
+
+		if passwdList || !changed {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "SLOT\tTYPE")
+			for i, slot := range editor.Slots() {
+				marker := ""
+				if i == editor.Unlocked() {
+					marker = " (unlocked)"
+				}
+				fmt.Fprintf(w, "%d\t%s%s\n", i, slot.Describe(), marker)
+			}
+			w.Flush()
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(passwdCmd)
+	addPasswordFlags(passwdCmd, &passwdPassword, "Current password to unlock the archive")
+	passwdCmd.Flags().StringArrayVarP(&passwdIdentity, "identity", "i", nil, "X25519 secret key file to unlock the archive (repeatable)")
+	passwdCmd.Flags().StringArrayVar(&passwdKeyfiles, "keyfile", nil, "Keyfile required by the archive (repeatable)")
+	passwdCmd.Flags().BoolVar(&passwdList, "list", false, "List the key slots (default when nothing is changed)")
+	passwdCmd.Flags().StringVar(&passwdAddPassword, "add-password", "", "Add a slot for another password (prompted if given without =NEW)")
+	passwdCmd.Flags().Lookup("add-password").NoOptDefVal = promptValue
+	passwdCmd.Flags().StringArrayVarP(&passwdAddRecipients, "add-recipient", "r", nil, "Add a slot for an X25519 public key or a file of keys (repeatable)")
+	passwdCmd.Flags().IntSliceVar(&passwdRemoveSlots, "remove-slot", nil, "Remove the slot with this index (repeatable, see --list)")
+	passwdCmd.Flags().StringVar(&passwdChangePassword, "change-password", "", "Replace the slot that was unlocked with a new password (prompted if given without =NEW)")
+	passwdCmd.Flags().Lookup("change-password").NoOptDefVal = promptValue
+	passwdCmd.Flags().StringArrayVar(&passwdNewKeyfiles, "new-keyfile", nil, "Keyfile required by the new password slot (repeatable)")
+	addKDFFlags(passwdCmd, &passwdKDF, "Key derivation function for new password slots")
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"chin/internal/crypto"
+	"runtime"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// PasswordEnv is read when no other password source is given.
+const PasswordEnv = "CHIN_PASSWORD"
+
+// stdinReader is the only buffered reader of stdin, so that a password read with
+// --password-stdin does not take input meant for a later prompt.
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// passwordFlags are the ways a command accepts a password. Only -p is visible
+// to other users of the host (shell history, ps), the others are not.
+type passwordFlags struct {
+	password string
+	file     string
+	stdin    bool
+	command  string
+}
+
+func addPasswordFlags(cmd *cobra.Command, p *passwordFlags, usage string) {
+	cmd.Flags().StringVarP(&p.password, "password", "p", "", usage+" (visible in shell history and ps, prefer the options below)")
+	cmd.Flags().StringVar(&p.file, "password-file", "", "Read the password from the first line of a file")
+	cmd.Flags().BoolVar(&p.stdin, "password-stdin", false, "Read the password from the first line of stdin")
+	cmd.Flags().StringVar(&p.command, "password-command", "", "Run a credential helper and use the first line of its output as the password")
+}
+
+// resolve returns the password from the flags or the environment, or nil if none was given.
+// Passwords read from a file, stdin or a helper never exist as a string, so the
+// caller can wipe them; -p and the environment cannot be wiped.
+func (p *passwordFlags) resolve() ([]byte, error) {
+	given := 0
+	for _, set := range []bool{p.password != "", p.file != "", p.stdin, p.command != ""} {
+		if set {
+			given++
+		}
+	}
+	if given > 1 {
+		return nil, errors.New("use only one of --password, --password-file, --password-stdin and --password-command")
+	}
+
+	switch {
+	case p.password != "":
+		return []byte(p.password), nil
+	case p.file != "":
+		data, err := os.ReadFile(p.file)
+		if err != nil {
+			return nil, fmt.Errorf("reading password file: %w", err)
+		}
+		defer crypto.Wipe(data)
+		return nonEmpty(firstLine(data), "password file")
+	case p.stdin:
+		line, err := stdinReader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			return nil, errors.New("password on stdin is too long")
+		}
+		if err != nil && len(line) == 0 {
+			return nil, fmt.Errorf("reading password from stdin: %w", err)
+		}
+		defer crypto.Wipe(line)
+		return nonEmpty(firstLine(line), "stdin")
+	case p.command != "":
+		return runPasswordCommand(p.command)
+	}
+	if env := os.Getenv(PasswordEnv); env != "" {
+		return []byte(env), nil
+	}
+	return nil, nil
+}
+
+func runPasswordCommand(command string) ([]byte, error) {
+	var c *exec.Cmd
+	if runtime.GOOS == "windows" {
+		c = exec.Command("cmd", "/C", command)
+	} else {
+		c = exec.Command("sh", "-c", command)
+	}
+	// The helper may prompt on the terminal itself
+	c.Stdin = os.Stdin
+	c.Stderr = os.Stderr
+	out, err := c.Output()
+	if err != nil {
+		return nil, fmt.Errorf("password command failed: %w", err)
+	}
+	defer crypto.Wipe(out)
+	return nonEmpty(firstLine(out), "password command output")
+}
+
+// firstLine returns a copy of the first line of data, so data can be wiped.
+func firstLine(data []byte) []byte {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[:i]
+	}
+	return crypto.CloneSecret(bytes.TrimSuffix(data, []byte("\r")))
+}
+
+func nonEmpty(password []byte, source string) ([]byte, error) {
+	if len(password) == 0 {
+		return nil, fmt.Errorf("empty password from %s", source)
+	}
+	return password, nil
+}
+
+// stdinIsTerminal reports whether a password can be prompted for.
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// promptPassword reads a password from the terminal without echo.
+// With confirm, it is asked twice and both entries must match.
+// allowEmpty accepts an empty entry, for a keyfile used without a password.
+func promptPassword(prompt string, confirm, allowEmpty bool) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !stdinIsTerminal() {
+		return nil, fmt.Errorf("no password given and stdin is not a terminal (use --password-file, --password-stdin, --password-command or %s)", PasswordEnv)
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	crypto.Protect(password)
+	if len(password) == 0 && !allowEmpty {
+		return nil, errors.New("empty password")
+	}
+
+	if confirm {
+		fmt.Fprint(os.Stderr, "Confirm password: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		defer crypto.Wipe(again)
+		if err != nil {
+			crypto.Wipe(password)
+			return nil, err
+		}
+		if !bytes.Equal(password, again) {
+			crypto.Wipe(password)
+			return nil, errors.New("passwords do not match")
+		}
+	}
+	return password, nil
+}
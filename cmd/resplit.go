@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	resplitOutput      string
+	resplitSplit       string
+	resplitIndependent bool
+	resplitSign        string
+	resplitPassword    passwordFlags
+	resplitIdentity    []string
+	resplitKeyfiles    []string
+)
+
+var resplitCmd = &cobra.Command{
+	Use:   "resplit [archive.chin]",
+	Short: "Split an archive again with another part size",
+	Long: `Write an archive again with another part size, for example for a
+different upload limit. The archive is copied byte for byte into the new
+parts, so no credentials are needed and its signature is kept. Only with
+--independent-volumes, or from independent volumes, are the entries copied
+one by one: file streams are still copied as stored, encrypted ones included,
+but the credentials are needed to rewrite the encrypted metadata. The new
+archive keeps the passwords and recipients of the old one.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if resplitOutput == "" || resplitSplit == "" {
+			fmt.Println("resplit requires --output and --split")
+			os.Exit(1)
+		}
+		rewriteArchives([]string{ensureChinExtension(args[0])}, rewriteOptions{
+			output:      resplitOutput,
+			split:       resplitSplit,
+			independent: resplitIndependent,
+			sign:        resplitSign,
+			password:    &resplitPassword,
+			identities:  resplitIdentity,
+			keyfiles:    resplitKeyfiles,
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(resplitCmd)
+	resplitCmd.Flags().StringVarP(&resplitOutput, "output", "o", "", "Output archive path")
+	resplitCmd.Flags().StringVar(&resplitSplit, "split", "", "New part size (e.g. 10MB, 1GB)")
+	resplitCmd.Flags().BoolVar(&resplitIndependent, "independent-volumes", false, "Cut parts between files and give each part its own index")
+	resplitCmd.Flags().StringVar(&resplitSign, "sign", "", "Sign the new archive with an Ed25519 key file")
+	addPasswordFlags(resplitCmd, &resplitPassword, "Password for decryption (only with independent volumes)")
+	resplitCmd.Flags().StringArrayVarP(&resplitIdentity, "identity", "i", nil, "X25519 secret key file for archives packed with --recipient (repeatable)")
+	resplitCmd.Flags().StringArrayVar(&resplitKeyfiles, "keyfile", nil, "Keyfile required by the archive (repeatable)")
+}
@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"chin/internal/archive"
+	"chin/internal/crypto"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// rewriteOptions describe the archive written by resplit, join and merge.
+type rewriteOptions struct {
+	output      string
+	split       string
+	independent bool
+	sign        string
+	password    *passwordFlags
+	identities  []string
+	keyfiles    []string
+}
+
+// rewriteArchives copies the entries of inputs into a new archive that takes
+// over the encryption of the first input. Streams are copied as stored where
+// the keys match, so the data is never unpacked. It exits on error.
+func rewriteArchives(inputs []string, opts rewriteOptions) {
+	start := time.Now()
+
+	output := ensureChinExtension(opts.output)
+	for _, input := range inputs {
+		if sameFile(input, output) {
+			fmt.Printf("The output '%s' must differ from the inputs\n", output)
+			os.Exit(1)
+		}
+	}
+
+	splitSize, err := parseSize(opts.split)
+	if err != nil {
+		fmt.Printf("Invalid split size: %v\n", err)
+		os.Exit(1)
+	}
+	if opts.independent && splitSize == 0 {
+		fmt.Println("--independent-volumes requires --split")
+		os.Exit(1)
+	}
+
+	// Moving the bytes to other parts needs no key and keeps the signature
+	if len(inputs) == 1 && !opts.independent && !hasIndependentVolumes(inputs[0]) {
+		recutArchive(inputs[0], output, splitSize, opts.sign, start)
+		return
+	}
+
+	credentials, err := loadCredentials(inputs[0], opts.password, opts.identities, opts.keyfiles)
+	if err != nil {
+		fmt.Printf("Error reading credentials: %v\n", err)
+		os.Exit(1)
+	}
+	defer credentials.Wipe()
+
+	var readers []*archive.Reader
+	for _, input := range inputs {
+		reader, err := openForRewrite(input, credentials)
+		if err != nil {
+			fmt.Printf("Error opening '%s': %v\n", input, err)
+			os.Exit(1)
+		}
+		defer reader.Close()
+		readers = append(readers, reader)
+
+		if header, err := archive.ReadHeader(input); err == nil && header.Flags&archive.FlagSigned != 0 && opts.sign == "" {
+			fmt.Printf("Note: the signature of '%s' is not kept, pass --sign to sign the new archive\n", input)
+		}
+	}
+
+	fmt.Printf("Writing %d archive(s) to '%s' (Split: %v)...\n", len(inputs), output, opts.split)
+
+	writer, err := archive.NewWriterFrom(output, readers[0], splitSize)
+	if err != nil {
+		fmt.Printf("Error creating archive: %v\n", err)
+		os.Exit(1)
+	}
+	defer writer.Close()
+	if opts.independent {
+		if err := writer.SetIndependentVolumes(true); err != nil {
+			fmt.Printf("Error creating archive: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if opts.sign != "" {
+		signingKey, err := readSigningKey(opts.sign)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		writer.SetSigningKey(signingKey)
+	}
+
+	bar := progressbar.DefaultBytes(-1, "copying")
+	writer.OnProgress = func(n int) {
+		bar.Add(n)
+	}
+	writer.OnFileStart = func(name string) {
+		if len(name) > 30 {
+			name = "..." + name[len(name)-27:]
+		}
+		bar.Describe(fmt.Sprintf("copying %s", name))
+	}
+
+	for i, reader := range readers {
+		if err := writer.CopyArchive(reader); err != nil {
+			fmt.Printf("\nError copying '%s': %v\n", inputs[i], err)
+			// Removes the unfinished output
+			writer.Close()
+			os.Exit(1)
+		}
+	}
+	if err := writer.Finalize(); err != nil {
+		fmt.Printf("\nError finalizing archive: %v\n", err)
+		writer.Close()
+		os.Exit(1)
+	}
+
+	bar.Finish()
+	fmt.Printf("\nDone in %v\n", time.Since(start))
+}
+
+// hasIndependentVolumes reports whether input is split into independent volumes.
+func hasIndependentVolumes(input string) bool {
+	volume, err := archive.ReadVolumeHeader(input)
+	return err == nil && volume.Independent()
+}
+
+// recutArchive writes input again in parts of splitSize bytes, or as a single
+// file, without credentials. It exits on error.
+func recutArchive(input, output string, splitSize int64, sign string, start time.Time) {
+	var signingKey []byte
+	if sign != "" {
+		var err error
+		if signingKey, err = readSigningKey(sign); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer crypto.Wipe(signingKey)
+	}
+
+	fmt.Printf("Writing '%s' to '%s'...\n", input, output)
+	if err := archive.Recut(input, output, splitSize, signingKey); err != nil {
+		fmt.Printf("Error writing archive: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Done in %v\n", time.Since(start))
+}
+
+// openForRewrite opens an input with the shared credentials, and asks for its
+// own password when they do not open it and someone can answer.
+func openForRewrite(input string, credentials archive.Credentials) (*archive.Reader, error) {
+	reader, err := archive.NewReaderWithCredentials(input, credentials)
+	var partsErr *archive.PartsError
+	if err == nil || errors.As(err, &partsErr) || !stdinIsTerminal() {
+		return reader, err
+	}
+	if header, herr := archive.ReadHeader(input); herr != nil || header.Flags&archive.FlagEncrypted == 0 {
+		return nil, err
+	}
+	password, perr := promptPassword(fmt.Sprintf("Password for %s: ", input), false, false)
+	if perr != nil {
+		return nil, perr
+	}
+	defer crypto.Wipe(password)
+	return archive.NewReaderWithCredentials(input, archive.Credentials{Password: password, Keyfiles: credentials.Keyfiles})
+}
+
+// sameFile reports whether two paths name the same file, or would once created.
+func sameFile(a, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	if errA == nil && errB == nil {
+		return os.SameFile(infoA, infoB)
+	}
+	absA, _ := filepath.Abs(a)
+	absB, _ := filepath.Abs(b)
+	return absA == absB
+}
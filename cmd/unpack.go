@@ -1,10 +1,12 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"chin/internal/archive"
+	"runtime"
 	"time"
 
 	"github.com/schollz/progressbar/v3"
@@ -12,15 +14,25 @@ import (
 )
 
 var (
-	unpackOutput   string
-	unpackPassword string
-	unpackWrap     bool
+	unpackOutput    string
+	unpackPassword  passwordFlags
+	unpackWrap      bool
+	unpackInclude   []string
+	unpackExclude   []string
+	unpackFilesFrom string
+	unpackJobs      int
+	unpackIdentity  []string
+	unpackKeyfiles  []string
+	unpackSkip      string
+	unpackResume    bool
+	unpackConflict  string
+	unpackDryRun    bool
 )
 
 var unpackCmd = &cobra.Command{
-	Use:   "unpack [archive.chin]",
+	Use:   "unpack [archive.chin] [paths...]",
 	Short: "Extract files from an archive",
-	Args:  cobra.ExactArgs(1),
+	Args:  cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		start := time.Now()
 		input := ensureChinExtension(args[0])
@@ -43,18 +55,83 @@ var unpackCmd = &cobra.Command{
 			}
 		}
 
-		fmt.Printf("Unpacking '%s' to '%s'...\n", input, unpackOutput)
+		switch unpackSkip {
+		case "", "verify":
+		default:
+			fmt.Printf("Unknown --skip-existing %q (use verify)\n", unpackSkip)
+			os.Exit(1)
+		}
+		skipExisting := unpackResume || unpackSkip == "verify"
+
+		conflict, err := archive.ParseConflictPolicy(unpackConflict)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		var prompt *conflictPrompt
+		if conflict == archive.ConflictAsk && !unpackDryRun {
+			if prompt, err = newConflictPrompt(); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+
+		if !unpackDryRun {
+			fmt.Printf("Unpacking '%s' to '%s'...\n", input, unpackOutput)
+		}
 
-		reader, err := archive.NewReader(input, unpackPassword)
+		credentials, err := loadCredentials(input, &unpackPassword, unpackIdentity, unpackKeyfiles)
+		if err != nil {
+			fmt.Printf("Error reading credentials: %v\n", err)
+			os.Exit(1)
+		}
+
+		reader, err := archive.NewReaderWithCredentials(input, credentials)
+		credentials.Wipe()
 		if err != nil {
 			fmt.Printf("Error opening archive: %v\n", err)
 			os.Exit(1)
 		}
 		defer reader.Close()
+		printVolumeNote(reader)
+
+		selector := &archive.Selector{
+			Paths:   args[1:],
+			Include: unpackInclude,
+			Exclude: unpackExclude,
+		}
+		if unpackFilesFrom != "" {
+			paths, err := readFileList(unpackFilesFrom)
+			if err != nil {
+				fmt.Printf("Error reading file list: %v\n", err)
+				os.Exit(1)
+			}
+			selector.Paths = append(selector.Paths, paths...)
+		}
+
+		if unmatched := reader.Unmatched(selector); len(unmatched) > 0 {
+			for _, p := range unmatched {
+				fmt.Printf("Not found in archive: %s\n", p)
+			}
+			os.Exit(1)
+		}
+
+		entries := reader.Select(selector)
+		if len(entries) == 0 {
+			fmt.Println("No matching files in archive")
+			os.Exit(1)
+		}
+
+		if unpackDryRun {
+			reader.SkipExisting = skipExisting
+			reader.Conflict = conflict
+			printExtractPlan(reader.PlanExtract(entries, unpackOutput))
+			return
+		}
 
 		// Calculate total size for progress bar
 		var totalSize int64
-		for _, file := range reader.ListFiles() {
+		for _, file := range entries {
 			totalSize += int64(file.Size)
 		}
 
@@ -63,6 +140,19 @@ var unpackCmd = &cobra.Command{
 			"unpacking",
 		)
 
+		reader.Jobs = unpackJobs
+		reader.SkipExisting = skipExisting
+		skipped := 0
+		reader.OnSkip = func(string) {
+			skipped++
+		}
+		reader.Conflict = conflict
+		if prompt != nil {
+			reader.Ask = prompt.ask
+		}
+		var summary conflictSummary
+		reader.OnConflict = summary.record
+
 		reader.OnProgress = func(n int) {
 			bar.Add(n)
 		}
@@ -75,19 +165,86 @@ var unpackCmd = &cobra.Command{
 			bar.Describe(fmt.Sprintf("unpacking %s", name))
 		}
 
-		if err := reader.ExtractAll(unpackOutput, true); err != nil {
-			fmt.Printf("Error extracting archive: %v\n", err)
+		if err := reader.ExtractEntries(entries, unpackOutput, true); err != nil {
+			fmt.Printf("\nError extracting archive: %v\n", err)
+			if errors.Is(err, archive.ErrConflict) {
+				fmt.Println("Choose what to do with existing files with --on-conflict overwrite|skip|keep-newer|rename|ask")
+			}
+			summary.print()
 			os.Exit(1)
 		}
 
 		bar.Finish()
-		fmt.Printf("\nDone in %v\n", time.Since(start))
+		fmt.Println()
+		if skipExisting {
+			fmt.Printf("Skipped %d file(s) already extracted\n", skipped)
+		}
+		summary.print()
+		fmt.Printf("Done in %v\n", time.Since(start))
 	},
 }
 
+// printExtractPlan reports where each file would be extracted and what would
+// happen to existing files. It exits with an error if unpack would stop.
+func printExtractPlan(steps []archive.ExtractStep) {
+	counts := map[string]int{}
+	for _, step := range steps {
+		action := "create"
+		switch {
+		case step.Err != nil:
+			action = "reject"
+		case !step.Exists:
+		case step.Unchanged:
+			action = "unchanged"
+		case step.Action == archive.ConflictFail:
+			action = "conflict"
+		default:
+			action = step.Action.String()
+		}
+		counts[action]++
+
+		switch action {
+		case "reject":
+			fmt.Printf("  %-10s %s (%v)\n", action, step.Entry.Name, step.Err)
+		case "rename":
+			fmt.Printf("  %-10s %s -> %s\n", action, step.Entry.Name, step.Path)
+		default:
+			fmt.Printf("  %-10s %s\n", action, step.Path)
+		}
+	}
+
+	fmt.Printf("\nDry run: %d file(s) would be created, %d overwritten, %d skipped, %d renamed",
+		counts["create"], counts["overwrite"], counts["skip"]+counts["unchanged"], counts["rename"])
+	if counts["ask"] > 0 {
+		fmt.Printf(", %d asked about", counts["ask"])
+	}
+	fmt.Println()
+	if counts["reject"] > 0 {
+		fmt.Printf("%d entries would be rejected: their path leaves the destination\n", counts["reject"])
+	}
+	if counts["conflict"] > 0 {
+		fmt.Printf("%d file(s) already exist: unpack would stop with --on-conflict fail\n", counts["conflict"])
+		fmt.Println("Choose what to do with existing files with --on-conflict overwrite|skip|keep-newer|rename|ask")
+	}
+	if counts["reject"] > 0 || counts["conflict"] > 0 {
+		os.Exit(1)
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(unpackCmd)
 	unpackCmd.Flags().StringVarP(&unpackOutput, "destination", "d", "", "Destination directory")
-	unpackCmd.Flags().StringVarP(&unpackPassword, "password", "p", "", "Password for decryption")
+	addPasswordFlags(unpackCmd, &unpackPassword, "Password for decryption")
+	unpackCmd.Flags().StringArrayVarP(&unpackIdentity, "identity", "i", nil, "X25519 secret key file for archives packed with --recipient (repeatable)")
+	unpackCmd.Flags().StringArrayVar(&unpackKeyfiles, "keyfile", nil, "Keyfile required by the archive (repeatable)")
 	unpackCmd.Flags().BoolVar(&unpackWrap, "wrap", false, "Wrap extracted files in a parent folder derived from archive name")
+	unpackCmd.Flags().StringArrayVar(&unpackInclude, "include", nil, "Only extract entries matching this glob (supports **, repeatable)")
+	unpackCmd.Flags().StringArrayVar(&unpackExclude, "exclude", nil, "Skip entries matching this glob (supports **, repeatable)")
+	unpackCmd.Flags().StringVar(&unpackFilesFrom, "files-from", "", "Read paths to extract from a file, one per line")
+	unpackCmd.Flags().StringVar(&unpackSkip, "skip-existing", "", "Leave files that already match their entry: 'verify' compares size, mtime and checksum")
+	unpackCmd.Flags().Lookup("skip-existing").NoOptDefVal = "verify"
+	unpackCmd.Flags().BoolVar(&unpackResume, "resume", false, "Continue an interrupted extraction (same as --skip-existing=verify)")
+	unpackCmd.Flags().StringVar(&unpackConflict, "on-conflict", "fail", "What to do with files that already exist: overwrite, skip, keep-newer, rename, ask or fail")
+	unpackCmd.Flags().BoolVar(&unpackDryRun, "dry-run", false, "Show where each file would be extracted and which existing files would be replaced, without writing anything")
+	unpackCmd.Flags().IntVarP(&unpackJobs, "jobs", "j", runtime.NumCPU(), "Number of files extracted in parallel")
 }
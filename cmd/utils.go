@@ -1,12 +1,209 @@
 package cmd
 
 import (
+	"bufio"
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"chin/internal/archive"
+	"chin/internal/crypto"
+	"regexp"
 	"strings"
 )
 
+// partSuffix matches the name of a later part, which can be opened on its own with --independent-volumes.
+var partSuffix = regexp.MustCompile(`(?i)\.chin\.c\d{2,}$`)
+
 func ensureChinExtension(path string) string {
-	if strings.HasSuffix(strings.ToLower(path), ".chin") {
+	if strings.HasSuffix(strings.ToLower(path), ".chin") || partSuffix.MatchString(path) {
 		return path
 	}
 	return path + ".chin"
 }
+
+// formatSize prints a byte count with a binary unit, such as 1.5 MB.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 4; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTP"[exp])
+}
+
+// readFileList reads one path per line, skipping blank lines and # comments.
+func readFileList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var paths []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	return paths, scanner.Err()
+}
+
+// parseRecipients accepts public keys directly or files containing them.
+func parseRecipients(values []string) ([][]byte, error) {
+	var keys [][]byte
+	for _, value := range values {
+		if key, err := crypto.ParsePublicKey(value); err == nil {
+			keys = append(keys, key)
+			continue
+		}
+
+		lines, err := readFileList(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: not a public key or readable file", value)
+		}
+		for _, line := range lines {
+			key, err := crypto.ParsePublicKey(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", value, err)
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// readIdentities reads X25519 secret keys from identity files written by keygen.
+func readIdentities(paths []string) ([][]byte, error) {
+	var keys [][]byte
+	for _, path := range paths {
+		lines, err := readFileList(path)
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, line := range lines {
+			if !strings.HasPrefix(line, crypto.SecretKeyPrefix) {
+				continue
+			}
+			key, err := crypto.ParseSecretKey(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			keys = append(keys, key)
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("%s: no secret key found", path)
+		}
+	}
+	return keys, nil
+}
+
+// readKeyfiles hashes keyfiles for archive.Credentials and Writer.AddKeyfile.
+func readKeyfiles(paths []string) ([][]byte, error) {
+	var digests [][]byte
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		digest, err := crypto.HashKeyfile(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		digests = append(digests, digest)
+	}
+	return digests, nil
+}
+
+// readSigningKey reads an Ed25519 secret key from a file written by "keygen --sign".
+func readSigningKey(path string) (ed25519.PrivateKey, error) {
+	lines, err := readFileList(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, crypto.SigningSecretKeyPrefix) {
+			key, err := crypto.ParseSigningSecretKey(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: no signing key found", path)
+}
+
+// parseTrustedKeys accepts signing public keys directly or files containing them.
+func parseTrustedKeys(values []string) ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	for _, value := range values {
+		if key, err := crypto.ParseSigningPublicKey(value); err == nil {
+			keys = append(keys, key)
+			continue
+		}
+
+		lines, err := readFileList(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted key %q: not a public key or readable file", value)
+		}
+		for _, line := range lines {
+			key, err := crypto.ParseSigningPublicKey(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", value, err)
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// loadCredentials collects what is needed to open an encrypted archive.
+// Without a password or identity, it prompts if the archive is encrypted.
+// The caller wipes the result once the archive is open.
+func loadCredentials(archivePath string, passwords *passwordFlags, identityFiles, keyfilePaths []string) (archive.Credentials, error) {
+	identities, err := readIdentities(identityFiles)
+	if err != nil {
+		return archive.Credentials{}, err
+	}
+	keyfiles, err := readKeyfiles(keyfilePaths)
+	if err != nil {
+		return archive.Credentials{}, err
+	}
+	password, err := passwords.resolve()
+	if err != nil {
+		return archive.Credentials{}, err
+	}
+
+	if len(password) == 0 && len(identities) == 0 {
+		header, err := archive.ReadHeader(archivePath)
+		encrypted := err == nil && header.Flags&archive.FlagEncrypted != 0
+		// A keyfile may be enough on its own, so only ask when someone can answer
+		if encrypted && (len(keyfiles) == 0 || stdinIsTerminal()) {
+			prompt := fmt.Sprintf("Password for %s: ", archivePath)
+			if len(keyfiles) > 0 {
+				prompt = fmt.Sprintf("Password for %s (Enter if none): ", archivePath)
+			}
+			password, err = promptPassword(prompt, false, len(keyfiles) > 0)
+			if err != nil {
+				return archive.Credentials{}, err
+			}
+		}
+	}
+	return archive.Credentials{Password: password, Identities: identities, Keyfiles: keyfiles}, nil
+}
+
+// printVolumeNote tells that a single independent volume is read instead of the whole archive.
+func printVolumeNote(reader *archive.Reader) {
+	if volume := reader.Volume(); volume != nil {
+		fmt.Printf("Note: reading part %d of %d on its own, files stored in other parts are not included\n", volume.Index, volume.Count)
+	}
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"chin/internal/archive"
+	"chin/internal/crypto"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	verifyTrustedKeys []string
+	verifyPassword    passwordFlags
+	verifyIdentity    []string
+	verifyKeyfiles    []string
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify [archive.chin]",
+	Short: "Check the signature and checksums of an archive",
+	Long: `Check that an archive is intact and, with --trusted-key, that it was signed
+by one of the trusted keys. The data checksum is checked without a password;
+with credentials (or for unencrypted archives) every file is also decoded and
+compared with its own checksum, and each part of a split archive is checked on
+its own against the part manifest.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		input := ensureChinExtension(args[0])
+
+		trusted, err := parseTrustedKeys(verifyTrustedKeys)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		header, err := archive.ReadHeader(input)
+		if err != nil {
+			fmt.Printf("Error opening archive: %v\n", err)
+			os.Exit(1)
+		}
+
+		// A later independent volume read alone only carries the header copy of its index
+		volume, _ := archive.ReadVolumeHeader(input)
+		alone := volume != nil && volume.Independent() && volume.Index != 0
+
+		encrypted := header.Flags&archive.FlagEncrypted != 0
+		given := verifyPassword != (passwordFlags{}) || os.Getenv(PasswordEnv) != "" || len(verifyIdentity) > 0 || len(verifyKeyfiles) > 0
+		canDecrypt := !encrypted || given
+
+		var credentials archive.Credentials
+		if canDecrypt {
+			credentials, err = loadCredentials(input, &verifyPassword, verifyIdentity, verifyKeyfiles)
+			if err != nil {
+				fmt.Printf("Error reading credentials: %v\n", err)
+				os.Exit(1)
+			}
+			defer credentials.Wipe()
+		}
+
+		// Parts first: a damaged part would otherwise only show up as a failed checksum
+		if header.Flags&archive.FlagSplit != 0 {
+			if canDecrypt {
+				verifyParts(input, credentials)
+			} else {
+				fmt.Println("Parts: not checked one by one (the part manifest is encrypted, pass a password, identity or keyfile)")
+			}
+		}
+
+		if header.Flags&archive.FlagSigned != 0 {
+			signer, err := archive.VerifySignature(input, trusted)
+			if err != nil {
+				fmt.Printf("FAILED: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Signature: OK, signed by %s\n", crypto.EncodeSigningPublicKey(signer))
+			if len(trusted) == 0 {
+				fmt.Println("Warning: the signer was not checked, pass --trusted-key to require a known key")
+			}
+		} else {
+			if len(trusted) > 0 && alone {
+				fmt.Println("FAILED: the signature is only stored in the first part, verify the whole archive to check it")
+				os.Exit(1)
+			}
+			if len(trusted) > 0 {
+				fmt.Printf("FAILED: %v\n", archive.ErrNotSigned)
+				os.Exit(1)
+			}
+			if err := archive.VerifyChecksum(input); err != nil {
+				fmt.Printf("FAILED: %v\n", err)
+				os.Exit(1)
+			}
+			if alone {
+				fmt.Println("Signature: not checked (only stored in the first part)")
+			} else {
+				fmt.Println("Signature: none")
+			}
+		}
+		fmt.Println("Data checksum: OK")
+
+		if !canDecrypt {
+			fmt.Println("Files: not decrypted (pass a password, identity or keyfile to check them)")
+			return
+		}
+
+		reader, err := archive.NewReaderWithCredentials(input, credentials)
+		if err != nil {
+			fmt.Printf("Error opening archive: %v\n", err)
+			os.Exit(1)
+		}
+		defer reader.Close()
+		printVolumeNote(reader)
+
+		if err := reader.Verify(); err != nil {
+			if errors.Is(err, archive.ErrChecksumMismatch) {
+				fmt.Println("FAILED: file checksum mismatch")
+			} else {
+				fmt.Printf("FAILED: %v\n", err)
+			}
+			os.Exit(1)
+		}
+		fmt.Printf("Files: OK (%d entries)\n", len(reader.ListFiles()))
+	},
+}
+
+// verifyParts prints the state of every part of a split archive and exits if one is damaged.
+func verifyParts(input string, credentials archive.Credentials) {
+	statuses, err := archive.VerifyParts(input, credentials)
+	if errors.Is(err, archive.ErrNoManifest) {
+		fmt.Println("Parts: no part manifest (packed by an older version)")
+		return
+	}
+	if err != nil {
+		fmt.Printf("FAILED: %v\n", err)
+		os.Exit(1)
+	}
+
+	damaged := 0
+	for _, status := range statuses {
+		if status.Problem != nil {
+			damaged++
+		}
+	}
+	if damaged == 0 {
+		fmt.Printf("Parts: OK (%d parts)\n", len(statuses))
+		return
+	}
+	fmt.Printf("Parts: %d of %d damaged\n", damaged, len(statuses))
+	for _, status := range statuses {
+		state := "OK"
+		if status.Problem != nil {
+			state = status.Problem.Error()
+		}
+		fmt.Printf("  %s: %s\n", status.Name, state)
+	}
+	os.Exit(1)
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().StringArrayVar(&verifyTrustedKeys, "trusted-key", nil, "Require a signature by this Ed25519 public key or a file of keys (repeatable)")
+	addPasswordFlags(verifyCmd, &verifyPassword, "Password to also check every file")
+	verifyCmd.Flags().StringArrayVarP(&verifyIdentity, "identity", "i", nil, "X25519 secret key file to also check every file (repeatable)")
+	verifyCmd.Flags().StringArrayVar(&verifyKeyfiles, "keyfile", nil, "Keyfile required by the archive (repeatable)")
+}
@@ -1,30 +1,39 @@
 package archive
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/ed25519"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"chin/internal/crypto"
 	"chin/internal/utils"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	Magic       = "CHIN"
-	Version     = 6 // New Version
-	MagicLength = 4
-	HeaderSize  = 72 // 4+2+2+8+8+32+16
+	Magic        = "CHIN"
+	Version      = 9 // v7: KDF descriptor in header, v8: authenticated header and streams, v9: volume headers on split parts
+	MagicLength  = 4
+	HeaderSize   = 84 // 4+2+2+8+8+32+16+12
+	HeaderSizeV6 = 72 // 4+2+2+8+8+32+16
 )
 
 const (
 	FlagEncrypted = 1 << iota
 	FlagSplit
+	FlagKeySlots // A key slot area follows the header; the archive key is random
+	FlagKeyfiles // The key derived from the header salt also needs keyfiles
+	FlagSigned   // An Ed25519 signature block follows the metadata
+	FlagHidden   // FileCount is zero and the metadata and streams are padded
 )
 
 type Header struct {
@@ -35,6 +44,108 @@ type Header struct {
 	MetadataOffset uint64
 	DataChecksum   [32]byte
 	Salt           [16]byte // Master Salt (for Metadata & Key Derivation)
+	KDF            crypto.KDFParams // v7+, v6 archives always use PBKDF2
+	Cipher         crypto.Cipher    // v7+, v6 archives always use AES-256-GCM
+
+	raw []byte // The header as read, see bytes
+}
+
+// Size returns the on-disk size of the header, which depends on its version.
+func (h *Header) Size() int {
+	if h.Version < 7 {
+		return HeaderSizeV6
+	}
+	return HeaderSize
+}
+
+// Serialize encodes the header in the v7 and later layout (Version defaults to the current one):
+// [Magic 4][Version 2][Flags 2][FileCount 8][MetadataOffset 8][DataChecksum 32][Salt 16]
+// [KDF: Algorithm 1][Parallelism 1][Cipher 1][Reserved 1][Iterations 4][Memory KiB 4]
+func (h *Header) Serialize() []byte {
+	buf := make([]byte, HeaderSize)
+	copy(buf[:MagicLength], Magic)
+	version := h.Version
+	if version == 0 {
+		version = Version
+	}
+	binary.BigEndian.PutUint16(buf[MagicLength:MagicLength+2], version)
+	binary.BigEndian.PutUint16(buf[MagicLength+2:MagicLength+4], h.Flags)
+	binary.BigEndian.PutUint64(buf[MagicLength+4:MagicLength+12], h.FileCount)
+	binary.BigEndian.PutUint64(buf[MagicLength+12:MagicLength+20], h.MetadataOffset)
+	copy(buf[MagicLength+20:MagicLength+52], h.DataChecksum[:])
+	copy(buf[MagicLength+52:MagicLength+68], h.Salt[:])
+
+	buf[HeaderSizeV6] = h.KDF.Algorithm
+	buf[HeaderSizeV6+1] = h.KDF.Parallelism
+	buf[HeaderSizeV6+2] = uint8(h.Cipher)
+	binary.BigEndian.PutUint32(buf[HeaderSizeV6+4:HeaderSizeV6+8], h.KDF.Iterations)
+	binary.BigEndian.PutUint32(buf[HeaderSizeV6+8:HeaderSizeV6+12], h.KDF.Memory)
+	return buf
+}
+
+// bytes returns the header as it was read, or serialized if it was not read.
+// Authenticating these bytes rather than a new serialization also covers the
+// reserved byte and any other bits Serialize would drop.
+func (h *Header) bytes() []byte {
+	if h.raw != nil {
+		return h.raw
+	}
+	return h.Serialize()
+}
+
+// DeserializeHeader decodes a v6 to v9 header. data may be longer than the header.
+func DeserializeHeader(data []byte) (*Header, error) {
+	if len(data) < HeaderSizeV6 {
+		return nil, ErrInvalidFormat
+	}
+
+	h := &Header{}
+	copy(h.Magic[:], data[:MagicLength])
+	if string(h.Magic[:]) != Magic {
+		return nil, ErrInvalidFormat
+	}
+
+	h.Version = binary.BigEndian.Uint16(data[MagicLength : MagicLength+2])
+	if h.Version < 6 || h.Version > Version {
+		return nil, ErrInvalidVersion
+	}
+	if len(data) < h.Size() {
+		return nil, ErrInvalidFormat
+	}
+	h.raw = bytes.Clone(data[:h.Size()])
+
+	h.Flags = binary.BigEndian.Uint16(data[MagicLength+2 : MagicLength+4])
+	h.FileCount = binary.BigEndian.Uint64(data[MagicLength+4 : MagicLength+12])
+	h.MetadataOffset = binary.BigEndian.Uint64(data[MagicLength+12 : MagicLength+20])
+	copy(h.DataChecksum[:], data[MagicLength+20:MagicLength+52])
+	copy(h.Salt[:], data[MagicLength+52:MagicLength+68])
+
+	if h.Version < 7 {
+		h.KDF = crypto.DefaultKDF()
+		h.Cipher = crypto.CipherAESGCM
+		return h, nil
+	}
+
+	h.KDF.Algorithm = data[HeaderSizeV6]
+	h.KDF.Parallelism = data[HeaderSizeV6+1]
+	h.KDF.Iterations = binary.BigEndian.Uint32(data[HeaderSizeV6+4 : HeaderSizeV6+8])
+	h.KDF.Memory = binary.BigEndian.Uint32(data[HeaderSizeV6+8 : HeaderSizeV6+12])
+	h.Cipher = crypto.Cipher(data[HeaderSizeV6+2])
+	if h.Cipher == 0 {
+		// Early v7 archives were written before the cipher id existed
+		h.Cipher = crypto.CipherAESGCM
+	}
+	if h.Flags&FlagEncrypted != 0 {
+		if h.Flags&FlagKeySlots == 0 {
+			if err := h.KDF.Validate(); err != nil {
+				return nil, err
+			}
+		}
+		if err := h.Cipher.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
 }
 
 type FileEntry struct {
@@ -54,6 +165,7 @@ type Metadata struct {
 	DataChecksum     [32]byte
 	Files            []FileEntry
 	MetadataChecksum [32]byte
+	Parts            []PartInfo // Part manifest of a split archive
 }
 
 func (m *Metadata) Serialize() ([]byte, error) {
@@ -69,24 +181,47 @@ func (m *Metadata) Serialize() ([]byte, error) {
 
 	binary.Write(buf, binary.BigEndian, uint32(len(m.Files)))
 	for _, file := range m.Files {
-		binary.Write(buf, binary.BigEndian, uint32(len(file.Name)))
-		buf.WriteString(file.Name)
-		binary.Write(buf, binary.BigEndian, file.Size)
-		binary.Write(buf, binary.BigEndian, file.Offset)
-		binary.Write(buf, binary.BigEndian, file.Checksum)
-		binary.Write(buf, binary.BigEndian, file.Mode)
-		if file.IsDir {
-			binary.Write(buf, binary.BigEndian, uint8(1))
-		} else {
-			binary.Write(buf, binary.BigEndian, uint8(0))
-		}
-		modTimeUnix := uint64(file.ModTime.Unix())
-		binary.Write(buf, binary.BigEndian, modTimeUnix)
+		serializeEntry(buf, file)
+	}
+
+	if len(m.Parts) > 0 {
+		writeSection(buf, sectionParts, serializeParts(m.Parts))
 	}
 
 	return buf.Bytes(), nil
 }
 
+// serializeEntry writes a file entry as stored in the metadata.
+func serializeEntry(buf *bytes.Buffer, file FileEntry) {
+	binary.Write(buf, binary.BigEndian, uint32(len(file.Name)))
+	buf.WriteString(file.Name)
+	binary.Write(buf, binary.BigEndian, file.Size)
+	binary.Write(buf, binary.BigEndian, file.Offset)
+	binary.Write(buf, binary.BigEndian, file.Checksum)
+	binary.Write(buf, binary.BigEndian, file.Mode)
+	if file.IsDir {
+		binary.Write(buf, binary.BigEndian, uint8(1))
+	} else {
+		binary.Write(buf, binary.BigEndian, uint8(0))
+	}
+	modTimeUnix := uint64(file.ModTime.Unix())
+	binary.Write(buf, binary.BigEndian, modTimeUnix)
+}
+
+// emptyMetadataSize is the serialized size of metadata without entries or sections.
+const emptyMetadataSize = 2 + 8 + 8 + 32 + 4
+
+// entrySize returns the serialized size of an entry named name.
+func entrySize(name string) int64 {
+	return int64(4 + len(name) + 8 + 8 + 8 + 4 + 1 + 8)
+}
+
+func writeSection(buf *bytes.Buffer, tag string, data []byte) {
+	buf.WriteString(tag)
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
+}
+
 func DeserializeMetadata(data []byte) (*Metadata, error) {
 	buf := bytes.NewReader(data)
 
@@ -122,64 +257,91 @@ func DeserializeMetadata(data []byte) (*Metadata, error) {
 
 	m.Files = make([]FileEntry, fileCount)
 	for i := uint32(0); i < fileCount; i++ {
-		var nameLen uint32
-		if err := binary.Read(buf, binary.BigEndian, &nameLen); err != nil {
-			return nil, fmt.Errorf("reading namelen for file %d: %w", i, err)
-		}
-		
-		// Safety Check: Filename length
-		if nameLen > 4096 {
-			return nil, fmt.Errorf("filename too long (%d) for file %d: corrupted metadata", nameLen, i)
+		if err := deserializeEntry(buf, &m.Files[i], i); err != nil {
+			return nil, err
 		}
+	}
 
-		nameBytes := make([]byte, nameLen)
-		if _, err := buf.Read(nameBytes); err != nil {
-			return nil, fmt.Errorf("reading name for file %d: %w", i, err)
+	// Optional sections, see writeSection
+	for buf.Len() > 0 {
+		tag := make([]byte, 4)
+		var length uint32
+		if _, err := io.ReadFull(buf, tag); err != nil {
+			return nil, fmt.Errorf("reading section tag: %w", err)
 		}
-		m.Files[i].Name = string(nameBytes)
-
-		if err := binary.Read(buf, binary.BigEndian, &m.Files[i].Size); err != nil {
-			return nil, fmt.Errorf("reading size for file %d: %w", i, err)
+		if err := binary.Read(buf, binary.BigEndian, &length); err != nil {
+			return nil, fmt.Errorf("reading section length: %w", err)
 		}
-
-		if err := binary.Read(buf, binary.BigEndian, &m.Files[i].Offset); err != nil {
-			return nil, fmt.Errorf("reading offset for file %d: %w", i, err)
+		if int64(length) > int64(buf.Len()) {
+			return nil, fmt.Errorf("section %q too long (%d): corrupted metadata", tag, length)
 		}
+		data := make([]byte, length)
+		io.ReadFull(buf, data)
 
-		if err := binary.Read(buf, binary.BigEndian, &m.Files[i].Checksum); err != nil {
-			return nil, fmt.Errorf("reading checksum for file %d: %w", i, err)
+		switch string(tag) {
+		case sectionParts:
+			parts, err := deserializeParts(data)
+			if err != nil {
+				return nil, err
+			}
+			m.Parts = parts
 		}
+	}
 
-		if err := binary.Read(buf, binary.BigEndian, &m.Files[i].Mode); err != nil {
-			return nil, fmt.Errorf("reading mode for file %d: %w", i, err)
-		}
+	return m, nil
+}
 
-		var isDir uint8
-		if err := binary.Read(buf, binary.BigEndian, &isDir); err != nil {
-			return nil, fmt.Errorf("reading isdir for file %d: %w", i, err)
-		}
-		m.Files[i].IsDir = isDir == 1
+// deserializeEntry reads file entry i as stored in the metadata.
+func deserializeEntry(buf *bytes.Reader, file *FileEntry, i uint32) error {
+	var nameLen uint32
+	if err := binary.Read(buf, binary.BigEndian, &nameLen); err != nil {
+		return fmt.Errorf("reading namelen for file %d: %w", i, err)
+	}
+	
+	// Safety Check: Filename length
+	if nameLen > 4096 {
+		return fmt.Errorf("filename too long (%d) for file %d: corrupted metadata", nameLen, i)
+	}
 
-		var modTimeUnix uint64
-		if err := binary.Read(buf, binary.BigEndian, &modTimeUnix); err != nil {
-			return nil, fmt.Errorf("reading modtime for file %d: %w", i, err)
-		}
-		m.Files[i].ModTime = time.Unix(int64(modTimeUnix), 0)
+	nameBytes := make([]byte, nameLen)
+	if _, err := buf.Read(nameBytes); err != nil {
+		return fmt.Errorf("reading name for file %d: %w", i, err)
 	}
+	file.Name = string(nameBytes)
 
-	if _, err := buf.Read(m.MetadataChecksum[:]); err != nil {
-		if err == io.EOF {
-			return m, nil
-		}
-		return nil, fmt.Errorf("reading metadatachecksum: %w", err)
+	if err := binary.Read(buf, binary.BigEndian, &file.Size); err != nil {
+		return fmt.Errorf("reading size for file %d: %w", i, err)
 	}
 
-	return m, nil
+	if err := binary.Read(buf, binary.BigEndian, &file.Offset); err != nil {
+		return fmt.Errorf("reading offset for file %d: %w", i, err)
+	}
+
+	if err := binary.Read(buf, binary.BigEndian, &file.Checksum); err != nil {
+		return fmt.Errorf("reading checksum for file %d: %w", i, err)
+	}
+
+	if err := binary.Read(buf, binary.BigEndian, &file.Mode); err != nil {
+		return fmt.Errorf("reading mode for file %d: %w", i, err)
+	}
+
+	var isDir uint8
+	if err := binary.Read(buf, binary.BigEndian, &isDir); err != nil {
+		return fmt.Errorf("reading isdir for file %d: %w", i, err)
+	}
+	file.IsDir = isDir == 1
+
+	var modTimeUnix uint64
+	if err := binary.Read(buf, binary.BigEndian, &modTimeUnix); err != nil {
+		return fmt.Errorf("reading modtime for file %d: %w", i, err)
+	}
+	file.ModTime = time.Unix(int64(modTimeUnix), 0)
+	return nil
 }
 
 var (
 	ErrInvalidFormat    = errors.New("invalid chin format")
-	ErrInvalidVersion   = errors.New("unsupported version (requires v6)")
+	ErrInvalidVersion   = errors.New("unsupported version (requires v6 to v9)")
 	ErrFileNotFound     = errors.New("file not found in archive")
 	ErrChecksumMismatch = errors.New("checksum mismatch")
 )
@@ -188,55 +350,95 @@ type Writer struct {
 	file       SplitFile
 	dataOffset uint64
 	dataHasher hash.Hash
+	parts      *partHasher // Per part checksums of a split archive
+	volumes    *volumeSet  // Entries of each independent volume
 	metadata   Metadata
-	password   string
+	password   []byte
 	salt       []byte
+	kdf        crypto.KDFParams
+	cipher     crypto.Cipher
+	masterKey  []byte
+	keyOnce    sync.Once
+	keyErr     error
+	recipients [][]byte
+	keyfiles   [][]byte
+	slotArea   []byte
+	inherited  *Header // Encryption taken over by NewWriterFrom
+	identities [][]byte // Unlock the key slots of a resumed archive
+	filename   string
+	tempName   string // Where the archive is written until Finalize
+	journaled  bool
+	journal    *journal
+	resume     *journalState // Journal of the interrupted pack, until begin
+	resumed    map[string]bool // Entries stored before the pack was resumed
+	signingKey ed25519.PrivateKey
+	hideMetadata bool
+	started    bool
+	finalized  bool
+	wiped      bool // The keys are gone, so nothing can be sealed any more
+	dryRun     bool // Nothing is read or written, see NewDryRunWriter
+	reproducible *ReproducibleOptions
+	Filter     *Filter
+	Jobs       int // Files encoded concurrently by AddFile (<= 1 means sequential)
 	OnProgress func(int)
 	OnFileStart func(string)
 }
 
-func NewWriter(filename string, password string, splitSize int64) (*Writer, error) {
+// NewWriter creates an archive. It is written under a temporary name next to
+// filename and only renamed to filename by Finalize. The password is copied, so
+// the caller may wipe its own buffer; the copy is wiped by Finalize or Close.
+func NewWriter(filename string, password []byte, splitSize int64) (*Writer, error) {
 	var file SplitFile
-	var err error
 
-	if splitSize > 0 {
-		file, err = NewSplitWriter(filename, splitSize)
-	} else {
-		file, err = os.Create(filename)
-	}
-	
+	temp, err := createTemp(filename)
 	if err != nil {
 		return nil, err
 	}
-
-	// Generate Master Salt if encrypted
-	var salt []byte
-	if password != "" {
-		salt, err = crypto.GenerateSalt()
+	if splitSize > 0 {
+		temp.Close()
+		file, err = NewSplitWriter(temp.Name(), splitSize)
 		if err != nil {
-			file.Close()
+			os.Remove(temp.Name())
 			return nil, err
 		}
 	} else {
-		salt = make([]byte, 16)
+		file = temp
+	}
+
+	w, err := newWriter(file, password)
+	if err != nil {
+		file.Close()
+		removeParts(temp.Name())
+		return nil, err
 	}
+	w.filename = filename
+	w.tempName = temp.Name()
 
 	// Write Placeholder Header
-	header := make([]byte, HeaderSize)
-	copy(header[:MagicLength], Magic)
-	binary.BigEndian.PutUint16(header[MagicLength:MagicLength+2], Version)
-	
-	flags := uint16(0)
-	if password != "" {
-		flags |= FlagEncrypted
+	header := Header{Version: Version}
+	if len(password) > 0 {
+		header.Flags |= FlagEncrypted
 	}
-	binary.BigEndian.PutUint16(header[MagicLength+2:MagicLength+4], flags)
-	copy(header[HeaderSize-16:], salt)
+	copy(header.Salt[:], w.salt)
 
-	if _, err := file.Write(header); err != nil {
-		file.Close()
+	if _, err := file.Write(header.Serialize()); err != nil {
+		w.Close()
 		return nil, err
 	}
+	return w, nil
+}
+
+// newWriter returns a writer whose data follows the header of file.
+func newWriter(file SplitFile, password []byte) (*Writer, error) {
+	// Generate Master Salt if encrypted
+	salt := make([]byte, 16)
+	if len(password) > 0 {
+		var err error
+		salt, err = crypto.GenerateSalt()
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return &Writer{
 		file:       file,
@@ -247,29 +449,183 @@ func NewWriter(filename string, password string, splitSize int64) (*Writer, erro
 			CreatedAt: time.Now(),
 			Files:     []FileEntry{},
 		},
-		password: password,
+		password: crypto.CloneSecret(password),
 		salt:     salt,
+		kdf:      crypto.DefaultKDF(),
+		cipher:   crypto.CipherAESGCM,
 	}, nil
 }
 
-func (w *Writer) AddFile(path string, nameInArchive string) error {
-	if strings.HasSuffix(strings.ToLower(path), ".chin") {
-		return nil 
+// SetCipher selects the AEAD for metadata and file streams.
+// It must be called before any file is added.
+func (w *Writer) SetCipher(c crypto.Cipher) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	w.cipher = c
+	return nil
+}
+
+// SetKDF selects how the master key is derived from the password.
+// It must be called before any file is added.
+func (w *Writer) SetKDF(params crypto.KDFParams) error {
+	if err := params.Validate(); err != nil {
+		return err
+	}
+	w.kdf = params
+	return nil
+}
+
+// AddRecipient adds a key slot for an X25519 public key, alongside the password (if any).
+// It must be called before any file is added.
+func (w *Writer) AddRecipient(publicKey []byte) error {
+	if w.started {
+		return errors.New("recipients must be added before files")
+	}
+	w.recipients = append(w.recipients, publicKey)
+	return nil
+}
+
+// AddKeyfile requires a keyfile (a digest from crypto.HashKeyfile) in addition
+// to the password. It must be called before any file is added.
+func (w *Writer) AddKeyfile(digest []byte) error {
+	if w.started {
+		return errors.New("keyfiles must be added before files")
 	}
-	if strings.Contains(strings.ToLower(path), ".chin.c") {
+	w.keyfiles = append(w.keyfiles, crypto.CloneSecret(digest))
+	return nil
+}
+
+// hasPassword reports whether a password and/or keyfiles protect the archive.
+func (w *Writer) hasPassword() bool {
+	return len(w.password) > 0 || len(w.keyfiles) > 0
+}
+
+func (w *Writer) encrypted() bool {
+	if w.inherited != nil {
+		return w.inherited.Flags&FlagEncrypted != 0
+	}
+	return w.hasPassword() || len(w.recipients) > 0
+}
+
+// useKeySlots reports whether the archive key is random and wrapped in key slots.
+// A seeded reproducible archive with only a password derives the key directly,
+// since a random key would make the output differ on every run.
+func (w *Writer) useKeySlots() bool {
+	if w.inherited != nil {
+		return w.inherited.Flags&FlagKeySlots != 0
+	}
+	if len(w.recipients) > 0 {
+		return true
+	}
+	seeded := w.reproducible != nil && len(w.reproducible.SaltSeed) > 0
+	return w.hasPassword() && !seeded
+}
+
+// key returns the master key, computed once on first use: a random archive key
+// when key slots are used, otherwise derived from the password, salt and KDF.
+func (w *Writer) key() ([]byte, error) {
+	w.keyOnce.Do(func() {
+		if w.useKeySlots() {
+			w.masterKey, w.keyErr = crypto.GenerateKey()
+		} else {
+			w.masterKey, w.keyErr = w.kdf.DeriveWithKeyfiles(w.password, w.keyfiles, w.salt)
+		}
+		crypto.Protect(w.masterKey)
+	})
+	return w.masterKey, w.keyErr
+}
+
+// begin writes the key slot area (if any) before the first file data.
+func (w *Writer) begin() error {
+	if w.started {
 		return nil
 	}
+	w.started = true
+	if w.resume != nil {
+		return w.continueJournal()
+	}
+	if w.inherited != nil && w.useKeySlots() {
+		if err := w.writeSlotArea(w.slotArea); err != nil {
+			return err
+		}
+	} else if w.useKeySlots() && w.dryRun {
+		// Wrapping the key would run the KDF for nothing
+		if err := w.writeSlotArea(make([]byte, KeySlotAreaSize)); err != nil {
+			return err
+		}
+	} else if w.useKeySlots() {
+		if err := w.writeKeySlots(); err != nil {
+			return err
+		}
+	}
+	if split, ok := w.file.(*SplitWriter); ok {
+		// Like DataChecksum, part checksums start after the header and key slot area
+		w.parts = newPartHasher(split, int64(w.dataOffset))
+	}
+	return w.startJournal()
+}
 
-	info, err := os.Stat(path)
+// writeKeySlots wraps the archive key for the password and each recipient.
+func (w *Writer) writeKeySlots() error {
+	archiveKey, err := w.key()
 	if err != nil {
 		return err
 	}
+	var slots []KeySlot
+	if w.hasPassword() {
+		slot, err := newPasswordSlot(archiveKey, w.password, w.keyfiles, w.kdf)
+		if err != nil {
+			return err
+		}
+		slots = append(slots, slot)
+	}
+	for _, recipient := range w.recipients {
+		slot, err := newX25519Slot(archiveKey, recipient)
+		if err != nil {
+			return err
+		}
+		slots = append(slots, slot)
+	}
 
-	if info.IsDir() {
-		return w.addDirectory(path, nameInArchive)
+	area, err := serializeKeySlots(slots, KeySlotAreaSize)
+	if err != nil {
+		return err
 	}
+	return w.writeSlotArea(area)
+}
+
+// writeSlotArea writes a serialized key slot area after the header.
+func (w *Writer) writeSlotArea(area []byte) error {
+	// Kept for the index of independent volumes
+	w.slotArea = area
+	// The area is not part of DataChecksum, so slots can change without touching the data
+	if _, err := w.file.Write(area); err != nil {
+		return err
+	}
+	w.dataOffset += uint64(len(area))
+	return nil
+}
 
-	return w.addSingleFile(path, nameInArchive, info)
+func (w *Writer) AddFile(path string, nameInArchive string) error {
+	if err := w.begin(); err != nil {
+		return err
+	}
+	if w.Jobs > 1 && !w.dryRun {
+		return w.addParallel(path, nameInArchive)
+	}
+	return Walk(path, nameInArchive, w.Filter, func(path, name string, info os.FileInfo) error {
+		if w.skipResumed(name, info) {
+			return nil
+		}
+		if info.IsDir() {
+			return w.addDirectory(path, name, info)
+		}
+		if w.dryRun {
+			return w.planFile(path, name, info)
+		}
+		return w.addSingleFile(path, name, info)
+	})
 }
 
 func (w *Writer) addSingleFile(path, name string, info os.FileInfo) error {
@@ -283,139 +639,197 @@ func (w *Writer) addSingleFile(path, name string, info os.FileInfo) error {
 	}
 	defer file.Close()
 
-	hasher := utils.NewXXHash64()
-
+	if err := w.placeEntry(name, w.storedSize(info.Size())); err != nil {
+		return err
+	}
 	offset := w.dataOffset
-	size := uint64(info.Size())
 
-	var totalWritten uint64 = 0
-	var checksum uint64
+	countingWriter := &utils.CountingWriter{Writer: w.file, Callback: w.OnProgress}
+	checksum, err := w.encodeFile(file, name, info.Size(), offset, io.MultiWriter(countingWriter, w.dataSink()))
+	if err != nil {
+		return err
+	}
+
+	return w.recordFile(path, name, info, offset, countingWriter.Count, checksum)
+}
+
+// dataSink receives every byte of file data written to the archive, for DataChecksum
+// and the part manifest.
+func (w *Writer) dataSink() io.Writer {
+	if w.parts == nil {
+		return w.dataHasher
+	}
+	return io.MultiWriter(w.dataHasher, w.parts)
+}
+
+// encodeFile writes the stored form of a file of size bytes (plain or encrypted
+// stream), whose stream starts at offset, to out and returns the plaintext checksum.
+func (w *Writer) encodeFile(file *os.File, name string, size int64, offset uint64, out io.Writer) (uint64, error) {
+	hasher := utils.NewXXHash64()
+
+	if w.encrypted() {
+		fileSalt, digest, err := w.fileSalt(file, name)
+		if err != nil {
+			return 0, err
+		}
 
-	if w.password != "" {
 		// Use Streaming Encryption
-		pipeReader, pipeWriter := io.Pipe()
-		
-		plainHasher := utils.NewXXHash64()
-		sourceWithHash := io.TeeReader(file, plainHasher)
-		
-		errChan := make(chan error, 1)
-		
-		go func() {
-			defer pipeWriter.Close()
-			// v6: EncryptStream handles file salt generation & writing internally
-			err := crypto.EncryptStream(sourceWithHash, pipeWriter, []byte(w.password), w.salt)
-			if err != nil {
-				pipeWriter.CloseWithError(err)
-				errChan <- err
-				return
-			}
-			errChan <- nil
-		}()
-		
-		countingWriter := &utils.CountingWriter{Writer: w.file, Callback: w.OnProgress}
-		multiWriter := io.MultiWriter(countingWriter, w.dataHasher)
-		
-		if _, err := io.Copy(multiWriter, pipeReader); err != nil {
-			return err
+		var sourceWithHash io.Reader = io.TeeReader(file, hasher)
+		contents := utils.NewBlake3()
+		if digest != nil {
+			sourceWithHash = io.TeeReader(sourceWithHash, contents)
 		}
-		
-		if err := <-errChan; err != nil {
-			return err
+		if w.hidden() {
+			sourceWithHash = paddedSource(uint64(size), sourceWithHash)
 		}
 
-		totalWritten = countingWriter.Count
-		checksum = plainHasher.Sum64()
-		
-	} else {
-		buf := make([]byte, 64*1024)
-		var copied uint64 = 0
-		
-		for {
-			n, err := file.Read(buf)
-			if err != nil && err != io.EOF {
-				return err
-			}
-			if n == 0 {
-				break
-			}
-
-			if _, err := w.file.Write(buf[:n]); err != nil {
-				return err
-			}
-			
-			if w.OnProgress != nil {
-				w.OnProgress(n)
-			}
+		masterKey, err := w.key()
+		if err != nil {
+			return 0, err
+		}
 
-			w.dataHasher.Write(buf[:n])
-			hasher.Write(buf[:n])
-			copied += uint64(n)
+		// SealStream writes the file salt (random unless reproducible) itself
+		if err := crypto.SealStream(sourceWithHash, out, w.cipher, masterKey, fileSalt, entryAAD(w.entryName(name), offset)); err != nil {
+			return 0, err
 		}
-		totalWritten = copied
-		checksum = hasher.Sum64()
+		if digest != nil && !bytes.Equal(contents.Sum(nil), digest) {
+			return 0, fmt.Errorf("%s changed while it was packed", name)
+		}
+		return hasher.Sum64(), nil
+	}
+
+	buf := make([]byte, 64*1024)
+	if _, err := io.CopyBuffer(io.MultiWriter(out, hasher), file, buf); err != nil {
+		return 0, err
 	}
+	return hasher.Sum64(), nil
+}
 
-	w.metadata.Files = append(w.metadata.Files, FileEntry{
-		Name:     name,
-		Size:     size,          // Original Size
-		Offset:   offset,        // Offset in Archive (start of stream)
-		Checksum: checksum,      // Plaintext Checksum
-		Mode:     uint32(info.Mode()),
-		ModTime:  info.ModTime(),
+// recordFile appends the metadata entry of the file at path whose stream was written at offset.
+func (w *Writer) recordFile(path, name string, info os.FileInfo, offset, written, checksum uint64) error {
+	return w.appendFile(FileEntry{
+		Name:     w.entryName(name),
+		Size:     uint64(info.Size()), // Original Size
+		Offset:   offset,              // Offset in Archive (start of stream)
+		Checksum: checksum,            // Plaintext Checksum
+		Mode:     w.entryMode(info.Mode()),
+		ModTime:  w.entryTime(info.ModTime()),
 		IsDir:    false,
-	})
+	}, written, w.journalSource(path, info))
+}
 
-	w.dataOffset += totalWritten
-	w.metadata.FileCount++
+// appendFile appends the metadata entry of a file whose stream of written bytes ends the data.
+func (w *Writer) appendFile(entry FileEntry, written uint64, source fileSource) error {
+	w.metadata.Files = append(w.metadata.Files, entry)
 
-	return nil
+	w.dataOffset += written
+	w.metadata.FileCount++
+	w.listInVolume(w.dataOffset)
+	return w.journalEntry(w.dataOffset, source)
 }
 
-func (w *Writer) addDirectory(path, name string) error {
-	info, err := os.Stat(path)
-	if err != nil {
-		return err
-	}
-
-	w.metadata.Files = append(w.metadata.Files, FileEntry{
-		Name:    name,
+func (w *Writer) addDirectory(path, name string, info os.FileInfo) error {
+	return w.appendDirectory(FileEntry{
+		Name:    w.entryName(name),
 		Size:    0,
 		Offset:  0,
-		Mode:    uint32(info.Mode()),
-		ModTime: info.ModTime(),
+		Mode:    w.entryMode(info.Mode()),
+		ModTime: w.entryTime(info.ModTime()),
 		IsDir:   true,
-	})
-
-	w.metadata.FileCount++
+	}, w.journalSource(path, info))
+}
 
-	entries, err := os.ReadDir(path)
-	if err != nil {
+// appendDirectory appends the metadata entry of a directory.
+func (w *Writer) appendDirectory(entry FileEntry, source fileSource) error {
+	if err := w.placeEntry(entry.Name, 0); err != nil {
 		return err
 	}
+	w.metadata.Files = append(w.metadata.Files, entry)
 
-	for _, entry := range entries {
-		fullPath := filepath.Join(path, entry.Name())
-		archiveName := filepath.Join(name, entry.Name())
+	w.metadata.FileCount++
+	w.listInVolume(0)
 
-		if entry.IsDir() {
-			if err := w.addDirectory(fullPath, archiveName); err != nil {
-				return err
-			}
-		} else {
-			if err := w.AddFile(fullPath, archiveName); err != nil {
-				return err
-			}
+	return w.journalEntry(0, source)
+}
+
+// Close wipes the keys and password held by the writer and closes the file.
+// It is only needed when Finalize is not called (for example after an error):
+// the unfinished archive is then removed, unless it has a journal for ResumeWriter.
+func (w *Writer) Close() error {
+	if w.journal != nil {
+		// Entries since the last checkpoint are kept for ResumeWriter, sealed
+		// while the key is still there. Finalize checkpoints before it wipes it.
+		if !w.finalized && !w.wiped {
+			w.checkpoint()
 		}
+		w.journal.file.Close()
+	}
+	w.wipe()
+	err := w.file.Close()
+	if !w.finalized && !w.journaled && !w.dryRun {
+		removeParts(w.tempName)
 	}
+	return err
+}
 
-	return nil
+// wipe zeroes the master key, password, keyfile digests and signing key.
+func (w *Writer) wipe() {
+	w.wiped = true
+	crypto.Wipe(w.masterKey)
+	crypto.Wipe(w.password)
+	crypto.WipeAll(w.keyfiles)
+	crypto.WipeAll(w.identities)
+	crypto.Wipe(w.signingKey)
 }
 
-func (w *Writer) Close() error {
-	return w.file.Close()
+// header returns the archive header without the fields known only once the
+// data is written: file count, metadata offset, data checksum and signature flag.
+func (w *Writer) header() Header {
+	header := Header{Version: Version}
+	if w.encrypted() {
+		header.Flags |= FlagEncrypted
+		header.Cipher = w.cipher
+	}
+	if w.inherited != nil {
+		header.Flags |= w.inherited.Flags & (FlagKeySlots | FlagKeyfiles)
+		if w.encrypted() && !w.useKeySlots() {
+			header.Salt = w.inherited.Salt
+			header.KDF = w.inherited.KDF
+		}
+	} else if w.useKeySlots() {
+		header.Flags |= FlagKeySlots
+	} else if w.hasPassword() {
+		copy(header.Salt[:], w.salt)
+		header.KDF = w.kdf
+		if len(w.keyfiles) > 0 {
+			header.Flags |= FlagKeyfiles
+		}
+	}
+	if _, ok := w.file.(*SplitWriter); ok {
+		header.Flags |= FlagSplit
+	}
+	if w.hidden() {
+		header.Flags |= FlagHidden
+	}
+	return header
 }
 
-func (w *Writer) Finalize(password string) error {
+func (w *Writer) Finalize() error {
+	defer w.wipe()
+	if w.dryRun {
+		return errors.New("a dry run writer is not finalized, see Plan")
+	}
+	if err := w.begin(); err != nil {
+		return err
+	}
+	// Should the metadata fail to be written, the journal still covers every entry
+	if err := w.checkpoint(); err != nil {
+		return err
+	}
+
+	if w.parts != nil {
+		w.metadata.Parts = w.parts.manifest(int64(w.dataOffset))
+	}
 	metadataBytes, err := w.metadata.Serialize()
 	if err != nil {
 		return err
@@ -426,49 +840,51 @@ func (w *Writer) Finalize(password string) error {
 	metadataChecksum := utils.Blake3(metadataBytes)
 	copy(w.metadata.MetadataChecksum[:], metadataChecksum)
 
+	metadataOffset := w.dataOffset
+
+	base := w.header()
+	header := base
+	header.MetadataOffset = metadataOffset
+	header.DataChecksum = w.metadata.DataChecksum
+	if split, ok := w.file.(*SplitWriter); ok && w.reproducible != nil {
+		split.SetArchiveID(w.archiveID(dataChecksum))
+	}
+	if w.signingKey != nil {
+		header.Flags |= FlagSigned
+	}
+	if w.hidden() {
+		metadataBytes = padMetadata(metadataBytes)
+	} else {
+		header.FileCount = w.metadata.FileCount
+	}
+
+	headerBytes := header.Serialize()
+
 	// Encrypt Metadata if needed
-	if w.password != "" {
-		// Used Master Salt for Metadata Encryption
-		encrypted, nonce, err := crypto.Encrypt(metadataBytes, []byte(w.password), w.salt)
+	if w.encrypted() {
+		// Used Master Key for Metadata Encryption
+		masterKey, err := w.key()
+		if err != nil {
+			return err
+		}
+		// The header is the associated data, so none of its fields can be altered
+		encrypted, nonce, err := crypto.EncryptWithKey(metadataBytes, w.cipher, masterKey, w.metadataNonce(metadataBytes), headerBytes)
 		if err != nil {
 			return err
 		}
-		// Combine: [Nonce 12][Ciphertext...]
+		// Combine: [Nonce 12 or 24][Ciphertext...]
 		combined := make([]byte, len(nonce)+len(encrypted))
 		copy(combined, nonce)
 		copy(combined[len(nonce):], encrypted)
 		metadataBytes = combined
 	}
 
-	metadataOffset := w.dataOffset
 	w.dataOffset += uint64(len(metadataBytes))
-	
+
 	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
 		return err
 	}
-
-	header := make([]byte, HeaderSize)
-	copy(header[:MagicLength], Magic)
-	binary.BigEndian.PutUint16(header[MagicLength:MagicLength+2], Version)
-	
-	flags := uint16(0)
-	if w.password != "" {
-		flags |= FlagEncrypted
-	}
-	if _, ok := w.file.(*SplitWriter); ok {
-		flags |= FlagSplit
-	}
-	binary.BigEndian.PutUint16(header[MagicLength+2:MagicLength+4], flags)
-
-	binary.BigEndian.PutUint64(header[MagicLength+4:MagicLength+12], w.metadata.FileCount)
-	binary.BigEndian.PutUint64(header[MagicLength+12:MagicLength+20], metadataOffset)
-	copy(header[MagicLength+20:MagicLength+52], w.metadata.DataChecksum[:])
-	
-	if w.password != "" {
-		copy(header[HeaderSize-16:], w.salt)
-	}
-
-	if _, err := w.file.Write(header); err != nil {
+	if _, err := w.file.Write(headerBytes); err != nil {
 		return err
 	}
 
@@ -480,6 +896,12 @@ func (w *Writer) Finalize(password string) error {
 		return err
 	}
 
+	if w.signingKey != nil {
+		if _, err := w.file.Write(signatureBlock(w.signingKey, headerBytes, metadataBytes)); err != nil {
+			return err
+		}
+	}
+
 	currentPos, err := w.file.Seek(0, io.SeekCurrent)
 	if err != nil {
 		return err
@@ -488,89 +910,255 @@ func (w *Writer) Finalize(password string) error {
 		return err
 	}
 
+	if w.volumes != nil {
+		err := w.file.(*SplitWriter).appendIndexes(func(i int, start, end int64) ([]byte, error) {
+			return w.volumeIndex(i, start, end, base, int64(metadataOffset))
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	if err := w.file.Sync(); err != nil {
 		return err
 	}
 
-	return w.file.Close()
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	if err := w.publish(); err != nil {
+		return err
+	}
+	w.finalized = true
+	return w.removeJournal()
 }
 
 type Reader struct {
 	file          SplitFile
 	header        Header
 	metadata      Metadata
-	password      string
+	password      []byte
+	keyfiles      [][]byte
 	salt          []byte
+	masterKey     []byte
+	slots         []KeySlot
+	slotArea      int // Size of the key slot area, 0 without key slots
+	unlockedSlot  int // Index of the slot the credentials opened
+	dataStart     uint64 // First byte after the header and key slot area
+	Jobs          int // Files extracted concurrently by ExtractEntries (<= 1 means sequential)
+	SkipExisting  bool // Leave files that already match their entry, see matchesEntry
+	Conflict      ConflictPolicy // What to do with other existing files
+	Ask           func(entry FileEntry, existing os.FileInfo) (ConflictPolicy, error) // Decides for ConflictAsk
 	OnProgress    func(int)
 	OnFileStart   func(string)
+	OnSkip        func(string) // Called for each file left by SkipExisting
+	OnConflict    func(name string, action ConflictPolicy, path string) // Overwrite, skip or rename of an existing file
+	callbackMu    sync.Mutex
+	conflictMu    sync.Mutex
+	targets       map[string]bool // Destinations of the file entries in targetsDir, see entryTargets
+	targetsDir    string
+}
+
+// Credentials unlock an encrypted archive.
+type Credentials struct {
+	Password   []byte
+	Identities [][]byte // X25519 private keys
+	Keyfiles   [][]byte // Digests from crypto.HashKeyfile
+}
+
+// Wipe zeroes the password, identities and keyfile digests. Readers keep their
+// own copies, so credentials can be wiped as soon as the archive is open.
+func (c Credentials) Wipe() {
+	crypto.Wipe(c.Password)
+	crypto.WipeAll(c.Identities)
+	crypto.WipeAll(c.Keyfiles)
 }
 
-func NewReader(filename string, password string) (*Reader, error) {
+// cloneSecrets copies each secret with crypto.CloneSecret.
+func cloneSecrets(secrets [][]byte) [][]byte {
+	var clones [][]byte
+	for _, secret := range secrets {
+		clones = append(clones, crypto.CloneSecret(secret))
+	}
+	return clones
+}
+
+func NewReader(filename string, password []byte) (*Reader, error) {
+	return NewReaderWithCredentials(filename, Credentials{Password: password})
+}
+
+// ReadHeader reads only the header of an archive, for example to find out
+// whether a password is needed before opening it.
+func ReadHeader(filename string) (*Header, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// The first part of a split archive starts with its volume header
+	if volume, err := readVolumeHeader(file); err == nil {
+		if volume.Independent() && volume.Index != 0 {
+			// Later independent volumes carry a copy in their index
+			_, header, err := readVolume(file, filename)
+			return header, err
+		}
+		if _, err := file.Seek(VolumeHeaderSize, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	headerBytes := make([]byte, HeaderSize)
+	n, err := io.ReadFull(file, headerBytes)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return DeserializeHeader(headerBytes[:n])
+}
+
+// openArchive opens all parts of an archive and reads its header. A later
+// part of an independent split archive is opened on its own, as a volumeFile.
+func openArchive(filename string) (SplitFile, *Header, error) {
 	var file SplitFile
 	
 	tempFile, err := os.Open(filename)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	if volume, err := readVolumeHeader(tempFile); err == nil && volume.Independent() && volume.Index != 0 {
+		v, header, err := readVolume(tempFile, filename)
+		if err != nil {
+			tempFile.Close()
+			return nil, nil, err
+		}
+		return v, header, nil
 	}
 	
-	headerBytes := make([]byte, HeaderSize)
-	if _, err := tempFile.Read(headerBytes); err != nil {
+	// Only the flags are needed to know whether the archive is split
+	prefix := make([]byte, MagicLength+4)
+	if _, err := io.ReadFull(tempFile, prefix); err != nil {
 		tempFile.Close()
-		return nil, err
+		return nil, nil, ErrInvalidFormat
 	}
 	
-	flags := binary.BigEndian.Uint16(headerBytes[MagicLength+2 : MagicLength+4])
-	if flags&FlagSplit != 0 {
+	flags := binary.BigEndian.Uint16(prefix[MagicLength+2 : MagicLength+4])
+	if flags&FlagSplit != 0 || string(prefix[:MagicLength]) == VolumeMagic {
 		tempFile.Close()
 		file, err = NewSplitReader(filename)
 		if err != nil {
-			return nil, err
-		}
-		if _, err := file.Seek(0, io.SeekStart); err != nil {
-			file.Close()
-			return nil, err
-		}
-		if _, err := file.Read(headerBytes); err != nil {
-			file.Close()
-			return nil, err
+			return nil, nil, err
 		}
 	} else {
 		file = tempFile
-		if _, err := file.Seek(0, io.SeekStart); err != nil {
-			file.Close()
-			return nil, err
-		}
 	}
 
-	var header Header
-	copy(header.Magic[:], headerBytes[:MagicLength])
-	header.Version = binary.BigEndian.Uint16(headerBytes[MagicLength : MagicLength+2])
-	header.Flags = binary.BigEndian.Uint16(headerBytes[MagicLength+2 : MagicLength+4])
-
-	header.FileCount = binary.BigEndian.Uint64(headerBytes[MagicLength+4 : MagicLength+12])
-	header.MetadataOffset = binary.BigEndian.Uint64(headerBytes[MagicLength+12 : MagicLength+20])
-	copy(header.DataChecksum[:], headerBytes[MagicLength+20:MagicLength+52])
-	copy(header.Salt[:], headerBytes[HeaderSize-16:])
-
-	if string(header.Magic[:]) != Magic {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
 		file.Close()
-		return nil, ErrInvalidFormat
+		return nil, nil, err
+	}
+	headerBytes := make([]byte, HeaderSize)
+	n, err := io.ReadFull(file, headerBytes)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		file.Close()
+		return nil, nil, err
 	}
 
-	if header.Version != Version {
+	header, err := DeserializeHeader(headerBytes[:n])
+	if err != nil {
 		file.Close()
-		return nil, ErrInvalidVersion
+		return nil, nil, err
+	}
+	return file, header, nil
+}
+
+// NewReaderWithCredentials opens an archive that may be encrypted for a password
+// or for X25519 recipients. A split archive with missing, truncated or
+// mismatched parts fails with a *PartsError naming them, unless it has
+// independent volumes: the given part is then read on its own (see Volume).
+func NewReaderWithCredentials(filename string, creds Credentials) (*Reader, error) {
+	r, err := openReader(filename, creds)
+	if err == nil {
+		if err = r.checkParts(); err != nil {
+			r.Close()
+		}
+	}
+	var partsErr *PartsError
+	if errors.As(err, &partsErr) {
+		if volume, verr := ReadVolumeHeader(filename); verr == nil && volume.Independent() {
+			if file, header, verr := openVolume(filename); verr == nil {
+				return newReader(file, header, creds)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// openReader opens an archive without checking its parts, so the parts that
+// are left can still be verified.
+func openReader(filename string, creds Credentials) (*Reader, error) {
+	file, header, err := openArchive(filename)
+	if err != nil {
+		return nil, err
+	}
+	return newReader(file, header, creds)
+}
+
+// newReader unlocks an opened archive and reads its metadata. It closes file on error.
+func newReader(file SplitFile, header *Header, creds Credentials) (*Reader, error) {
+	if split, ok := file.(*SplitReader); ok {
+		// Missing last parts take the metadata with them
+		if err := split.missingAfter(int64(header.MetadataOffset)); err != nil {
+			file.Close()
+			return nil, err
+		}
 	}
 
 	r := &Reader{
 		file:          file,
-		header:        header,
-		password:      password,
+		header:        *header,
+		password:      crypto.CloneSecret(creds.Password),
+		keyfiles:      cloneSecrets(creds.Keyfiles),
 		salt:          header.Salt[:],
+		dataStart:     uint64(header.Size()),
+	}
+
+	if header.Flags&FlagKeySlots != 0 {
+		slots, areaSize, err := readKeySlots(file, int64(header.Size()))
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.dataStart += uint64(areaSize)
+		r.slots = slots
+		r.slotArea = areaSize
+		r.masterKey, r.unlockedSlot, err = unlockKeySlots(slots, creds)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+	} else if header.Flags&FlagEncrypted != 0 {
+		if header.Flags&FlagKeyfiles != 0 && len(creds.Keyfiles) == 0 {
+			r.Close()
+			return nil, crypto.ErrKeyfileRequired
+		}
+		var err error
+		r.masterKey, err = header.KDF.DeriveWithKeyfiles(creds.Password, creds.Keyfiles, r.salt)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	crypto.Protect(r.masterKey)
+	if volume, ok := file.(*volumeFile); ok {
+		r.dataStart = uint64(volume.dataFrom)
 	}
 
 	if _, err := file.Seek(int64(header.MetadataOffset), 0); err != nil {
-		file.Close()
+		r.Close()
 		return nil, err
 	}
 
@@ -579,7 +1167,7 @@ func NewReader(filename string, password string) (*Reader, error) {
 	for {
 		n, err := file.Read(buf)
 		if err != nil && err != io.EOF {
-			file.Close()
+			r.Close()
 			return nil, err
 		}
 		if n == 0 {
@@ -588,31 +1176,52 @@ func NewReader(filename string, password string) (*Reader, error) {
 		metadataBytes = append(metadataBytes, buf[:n]...)
 	}
 
+	if header.Flags&FlagSigned != 0 {
+		// Signature block, checked by VerifySignature
+		if len(metadataBytes) < SignatureSize {
+			r.Close()
+			return nil, ErrInvalidFormat
+		}
+		metadataBytes = metadataBytes[:len(metadataBytes)-SignatureSize]
+	}
+
 	if len(metadataBytes) == 0 {
-		file.Close()
+		r.Close()
 		return nil, errors.New("empty metadata")
 	}
 
 	if header.Flags&FlagEncrypted != 0 {
-		// New Metadata Format: [Nonce 12][Ciphertext...]
-		if len(metadataBytes) < 12 {
-			file.Close()
+		// New Metadata Format: [Nonce 12 or 24][Ciphertext...]
+		nonceSize := header.Cipher.NonceSize()
+		if len(metadataBytes) < nonceSize {
+			r.Close()
 			return nil, errors.New("metadata too short for nonce")
 		}
-		nonce := metadataBytes[:12]
-		ciphertext := metadataBytes[12:]
+		nonce := metadataBytes[:nonceSize]
+		ciphertext := metadataBytes[nonceSize:]
 		
-		decrypted, err := crypto.Decrypt(ciphertext, nonce, []byte(r.password), r.salt)
+		var aad []byte
+		if header.Version >= 8 {
+			aad = header.bytes()
+		}
+		decrypted, err := crypto.DecryptWithKey(ciphertext, nonce, header.Cipher, r.masterKey, aad)
 		if err != nil {
-			file.Close()
+			r.Close()
 			return nil, err
 		}
 		metadataBytes = decrypted
+		if header.Flags&FlagHidden != 0 {
+			metadataBytes, err = unpadMetadata(metadataBytes)
+			if err != nil {
+				r.Close()
+				return nil, err
+			}
+		}
 	}
 
 	metadata, err := DeserializeMetadata(metadataBytes)
 	if err != nil {
-		file.Close()
+		r.Close()
 		return nil, err
 	}
 
@@ -621,29 +1230,49 @@ func NewReader(filename string, password string) (*Reader, error) {
 	return r, nil
 }
 
+// Close wipes the master key, password and keyfile digests and closes the archive.
 func (r *Reader) Close() error {
+	crypto.Wipe(r.masterKey)
+	crypto.Wipe(r.password)
+	crypto.WipeAll(r.keyfiles)
 	return r.file.Close()
 }
 
-func (r *Reader) SetPassword(password string) {
-	r.password = password
+func (r *Reader) SetPassword(password []byte) {
+	crypto.Wipe(r.password)
+	r.password = crypto.CloneSecret(password)
+	if r.header.Flags&FlagEncrypted != 0 && r.header.Flags&FlagKeySlots == 0 {
+		crypto.Wipe(r.masterKey)
+		// KDF parameters were validated when the header was read
+		r.masterKey, _ = r.header.KDF.DeriveWithKeyfiles(r.password, r.keyfiles, r.salt)
+		crypto.Protect(r.masterKey)
+	}
 }
 
 func (r *Reader) ListFiles() []FileEntry {
 	return r.metadata.Files
 }
 
-func (r *Reader) ExtractFile(entry FileEntry, outputPath string, verify bool) error {
+// entryPath returns where an entry is extracted below outputPath.
+func entryPath(entry FileEntry, outputPath string) (string, error) {
 	// Security: Zip Slip Prevention
 	destPath, err := filepath.Abs(outputPath)
 	if err != nil {
-		return err
+		return "", err
 	}
 	
 	fullPath := filepath.Join(destPath, entry.Name)
 	if !strings.HasPrefix(fullPath, destPath+string(os.PathSeparator)) && fullPath != destPath {
 		// Attempted Zip Slip
-		return fmt.Errorf("security error: illegal file path '%s'", entry.Name)
+		return "", fmt.Errorf("security error: illegal file path '%s'", entry.Name)
+	}
+	return fullPath, nil
+}
+
+func (r *Reader) ExtractFile(entry FileEntry, outputPath string, verify bool) error {
+	fullPath, err := entryPath(entry, outputPath)
+	if err != nil {
+		return err
 	}
 
 	if entry.IsDir {
@@ -657,34 +1286,51 @@ func (r *Reader) ExtractFile(entry FileEntry, outputPath string, verify bool) er
 		return err
 	}
 
-	// Ensure we can overwrite if exists (handle read-only files)
-	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
-		// Try to chmod if remove failed (might be read-only on Windows)
-		os.Chmod(fullPath, 0666)
-		if err := os.Remove(fullPath); err != nil {
+	if r.SkipExisting {
+		match, err := matchesEntry(fullPath, entry)
+		if err != nil {
 			return err
 		}
+		if match {
+			r.fileSkip(entry)
+			return nil
+		}
 	}
 
-	if r.OnFileStart != nil {
-		r.OnFileStart(entry.Name)
+	target, skip, err := r.resolveConflict(entry, fullPath, outputPath)
+	if err != nil || skip {
+		return err
 	}
+	renamed := target != fullPath
+	fullPath = target
 
-	outFile, err := os.Create(fullPath)
+	r.fileStart(entry.Name)
+
+	// The target is only replaced once the new file is complete and verified
+	outFile, err := createTemp(fullPath)
 	if err != nil {
 		return err
 	}
-	defer outFile.Close()
+	replaced := false
+	defer func() {
+		outFile.Close()
+		if !replaced {
+			removeTemp(outFile.Name())
+			if renamed {
+				// The name reserved by resolveConflict
+				os.Remove(fullPath)
+			}
+		}
+	}()
 
-	if _, err := r.file.Seek(int64(entry.Offset), io.SeekStart); err != nil {
-		return err
-	}
+	// Read through a section so concurrent extractions do not share a file offset
+	src := bufio.NewReaderSize(r.entrySection(entry), 128*1024)
 
 	// Call helper to extract data
 	if r.header.Flags&FlagEncrypted != 0 {
-		err = r.extractFileEncrypted(entry, outFile, verify)
+		err = r.extractFileEncrypted(entry, src, outFile, verify)
 	} else {
-		err = r.extractFilePlain(entry, outFile, verify)
+		err = r.extractFilePlain(entry, src, outFile, verify)
 	}
 	
 	if err != nil {
@@ -696,19 +1342,37 @@ func (r *Reader) ExtractFile(entry FileEntry, outputPath string, verify bool) er
 		return err
 	}
 
+	// The contents must be on disk before the rename makes them the target
+	if err := outFile.Sync(); err != nil {
+		return err
+	}
 	// Close explicitly before Chtimes (important on Windows)
 	if err := outFile.Close(); err != nil {
 		return err
 	}
 
-	return os.Chtimes(fullPath, entry.ModTime, entry.ModTime)
+	if err := os.Chtimes(outFile.Name(), entry.ModTime, entry.ModTime); err != nil {
+		return err
+	}
+	if err := replaceFile(outFile.Name(), fullPath); err != nil {
+		return err
+	}
+	replaced = true
+	syncDir(filepath.Dir(fullPath))
+	return nil
 }
 
-func (r *Reader) extractFilePlain(entry FileEntry, outFile *os.File, verify bool) error {
-	if _, err := r.file.Seek(int64(entry.Offset), io.SeekStart); err != nil {
-		return err
+// entrySection returns a reader over the stored stream of an entry.
+// Encrypted streams carry their own terminator, so the section ends at the metadata.
+func (r *Reader) entrySection(entry FileEntry) *io.SectionReader {
+	end := int64(r.header.MetadataOffset)
+	if r.header.Flags&FlagEncrypted == 0 {
+		end = int64(entry.Offset + entry.Size)
 	}
+	return io.NewSectionReader(r.file, int64(entry.Offset), end-int64(entry.Offset))
+}
 
+func (r *Reader) extractFilePlain(entry FileEntry, src io.Reader, outFile *os.File, verify bool) error {
 	hasher := utils.NewXXHash64()
 	tee := io.MultiWriter(outFile, hasher)
 
@@ -721,18 +1385,19 @@ func (r *Reader) extractFilePlain(entry FileEntry, outFile *os.File, verify bool
 			readSize = remaining
 		}
 
-		n, err := r.file.Read(buf[:readSize])
+		n, err := src.Read(buf[:readSize])
 		if err != nil && err != io.EOF {
 			return err
 		}
+		if n == 0 && err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
 
 		if _, err := tee.Write(buf[:n]); err != nil {
 			return err
 		}
 
-		if r.OnProgress != nil {
-			r.OnProgress(n)
-		}
+		r.progress(n)
 
 		remaining -= uint64(n)
 	}
@@ -746,11 +1411,28 @@ func (r *Reader) extractFilePlain(entry FileEntry, outFile *os.File, verify bool
 	return nil
 }
 
-func (r *Reader) extractFileEncrypted(entry FileEntry, outFile *os.File, verify bool) error {
-	if _, err := r.file.Seek(int64(entry.Offset), io.SeekStart); err != nil {
-		return err
+// decryptStream decrypts the stream of an entry. Since v8 each stream is bound to
+// the name and offset of its entry.
+func (r *Reader) decryptStream(entry FileEntry, src io.Reader, dst io.Writer) error {
+	if r.header.Version < 8 {
+		return crypto.DecryptStreamWithKey(src, dst, r.header.Cipher, r.masterKey)
+	}
+	if r.header.Flags&FlagHidden != 0 {
+		dst = &truncatingWriter{w: dst, n: entry.Size}
 	}
+	return crypto.OpenStream(src, dst, r.header.Cipher, r.masterKey, entryAAD(entry.Name, entry.Offset))
+}
 
+// entryAAD is the associated data of a file stream: [Offset 8][Name]. Together
+// with the metadata, which is authenticated with the header and holds every
+// offset and size, it ties each stream to one entry of one archive layout, so
+// streams of equal names cannot be swapped or moved within the data.
+func entryAAD(name string, offset uint64) []byte {
+	aad := binary.BigEndian.AppendUint64([]byte("chin-entry-v8\x00"), offset)
+	return append(aad, name...)
+}
+
+func (r *Reader) extractFileEncrypted(entry FileEntry, src io.Reader, outFile *os.File, verify bool) error {
 	var writer io.Writer = outFile
 	var hasher hash.Hash64
 	
@@ -762,12 +1444,11 @@ func (r *Reader) extractFileEncrypted(entry FileEntry, outFile *os.File, verify
 	if r.OnProgress != nil {
 		writer = &utils.CountingWriter{
 			Writer:   writer,
-			Callback: r.OnProgress,
+			Callback: r.progress,
 		}
 	}
 
-	err := crypto.DecryptStream(r.file, writer, []byte(r.password), r.salt)
-	if err != nil {
+	if err := r.decryptStream(entry, src, writer); err != nil {
 		return err
 	}
 	
@@ -781,7 +1462,22 @@ func (r *Reader) extractFileEncrypted(entry FileEntry, outFile *os.File, verify
 }
 
 func (r *Reader) ExtractAll(outputPath string, verify bool) error {
-	for _, entry := range r.metadata.Files {
+	return r.ExtractEntries(r.metadata.Files, outputPath, verify)
+}
+
+// ExtractEntries extracts the given entries, using r.Jobs workers for file data.
+// With ConflictFail and without SkipExisting, nothing is extracted if any of
+// the files already exists.
+func (r *Reader) ExtractEntries(entries []FileEntry, outputPath string, verify bool) error {
+	if r.Conflict == ConflictFail && !r.SkipExisting {
+		if err := checkConflicts(entries, outputPath); err != nil {
+			return err
+		}
+	}
+	if r.Jobs > 1 {
+		return r.extractParallel(entries, outputPath, verify)
+	}
+	for _, entry := range entries {
 		if err := r.ExtractFile(entry, outputPath, verify); err != nil {
 			return err
 		}
@@ -789,65 +1485,97 @@ func (r *Reader) ExtractAll(outputPath string, verify bool) error {
 	return nil
 }
 
-func (r *Reader) Verify() error {
-	dataBytes := make([]byte, r.header.MetadataOffset-uint64(HeaderSize))
-	if _, err := r.file.Seek(int64(HeaderSize), io.SeekStart); err != nil {
-		return err
+// Unmatched returns the paths of sel that name no entry of the archive, such
+// as a misspelled path or a line of a file list.
+func (r *Reader) Unmatched(sel *Selector) []string {
+	var unmatched []string
+	for _, p := range sel.Paths {
+		found := false
+		for _, entry := range r.metadata.Files {
+			if underPath(normalizeName(entry.Name), p) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unmatched = append(unmatched, p)
+		}
 	}
-	if _, err := r.file.Read(dataBytes); err != nil {
-		return err
+	return unmatched
+}
+
+// Select returns the entries matched by sel, together with the directory
+// entries of their parents so they are recreated with the stored mode.
+func (r *Reader) Select(sel *Selector) []FileEntry {
+	if sel.IsEmpty() {
+		return r.metadata.Files
 	}
 
-	dataChecksum := utils.Blake3(dataBytes)
-	for i := 0; i < 32; i++ {
-		if dataChecksum[i] != r.header.DataChecksum[i] {
-			return ErrChecksumMismatch
+	needed := make(map[string]bool)
+	for _, entry := range r.metadata.Files {
+		if !sel.Match(entry.Name) {
+			continue
+		}
+		name := normalizeName(entry.Name)
+		needed[name] = true
+		for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			needed[dir] = true
+		}
+	}
+
+	var selected []FileEntry
+	for _, entry := range r.metadata.Files {
+		if needed[normalizeName(entry.Name)] {
+			selected = append(selected, entry)
 		}
 	}
+	return selected
+}
+
+func (r *Reader) Verify() error {
+	if err := verifyDataChecksum(r.file, int64(r.dataStart), &r.header); err != nil {
+		return err
+	}
 
 	for _, entry := range r.metadata.Files {
 		if entry.IsDir {
 			continue
 		}
-
-		if _, err := r.file.Seek(int64(entry.Offset), io.SeekStart); err != nil {
+		if err := r.verifyEntry(entry); err != nil {
 			return err
 		}
+	}
 
-		hasher := utils.NewXXHash64()
-		remaining := entry.Size
-		buf := make([]byte, 64*1024)
-
-		for remaining > 0 {
-			readSize := uint64(len(buf))
-			if remaining < readSize {
-				readSize = remaining
-			}
-
-			n, err := r.file.Read(buf[:readSize])
-			if err != nil && err != io.EOF {
-				return err
-			}
+	return nil
+}
 
-			hasher.Write(buf[:n])
-			remaining -= uint64(n)
+// verifyEntry checks the stream of a file entry against its checksum.
+func (r *Reader) verifyEntry(entry FileEntry) error {
+	// Checksums are of the plaintext, so encrypted streams are decrypted first
+	hasher := utils.NewXXHash64()
+	src := r.entrySection(entry)
+	if r.header.Flags&FlagEncrypted != 0 {
+		if err := r.decryptStream(entry, bufio.NewReaderSize(src, 128*1024), hasher); err != nil {
+			return err
 		}
-
-		checksum := hasher.Sum64()
-		if checksum != entry.Checksum {
-			return ErrChecksumMismatch
+	} else {
+		if _, err := io.CopyBuffer(hasher, src, make([]byte, 64*1024)); err != nil {
+			return err
 		}
 	}
 
+	if hasher.Sum64() != entry.Checksum {
+		return ErrChecksumMismatch
+	}
 	return nil
 }
 
-func DecryptMetadata(data []byte, nonce []byte, password string, salt []byte) ([]byte, error) {
-	return crypto.Decrypt(data, nonce, []byte(password), salt)
+func DecryptMetadata(data []byte, nonce []byte, password []byte, salt []byte) ([]byte, error) {
+	return crypto.Decrypt(data, nonce, password, salt)
 }
 
-func TestEncrypt(data []byte, password string, salt []byte) ([]byte, []byte, error) {
-	return crypto.Encrypt(data, []byte(password), salt)
+func TestEncrypt(data []byte, password []byte, salt []byte) ([]byte, []byte, error) {
+	return crypto.Encrypt(data, password, salt)
 }
 
 func (r *Reader) FindFile(name string) (*FileEntry, bool) {
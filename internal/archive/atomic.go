@@ -0,0 +1,115 @@
+package archive
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Archives and extracted files are written under a temporary name next to
+// their target and renamed into place once complete, so an interrupted run
+// never leaves a half-written file under the final name or destroys the file
+// it replaces.
+
+// tempName returns an unused temporary sibling of path: path.tmp-XXXXXXXX.
+func tempName(path string) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return path + ".tmp-" + hex.EncodeToString(suffix), nil
+}
+
+// createTemp creates a temporary sibling of path with the permissions
+// os.Create would give path.
+func createTemp(path string) (*os.File, error) {
+	for {
+		name, err := tempName(path)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if !os.IsExist(err) {
+			return f, err
+		}
+	}
+}
+
+// replaceFile renames a complete file over target. Windows refuses to replace
+// a read-only file, so there a read-only regular target is made writable for
+// the rename, and given back its mode should the rename still fail.
+func replaceFile(temp, target string) error {
+	err := os.Rename(temp, target)
+	if err == nil || runtime.GOOS != "windows" {
+		return err
+	}
+	info, statErr := os.Lstat(target)
+	if statErr != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0200 != 0 {
+		return err
+	}
+	if os.Chmod(target, info.Mode().Perm()|0200) != nil {
+		return err
+	}
+	if err := os.Rename(temp, target); err != nil {
+		os.Chmod(target, info.Mode().Perm())
+		return err
+	}
+	return nil
+}
+
+// removeTemp removes an unfinished temporary file, which may already have been
+// given the read-only mode of its entry (Windows cannot remove it then).
+func removeTemp(name string) {
+	if os.Remove(name) != nil {
+		os.Chmod(name, 0600)
+		os.Remove(name)
+	}
+}
+
+// syncDir flushes the renames in dir to disk. Not every system can sync a
+// directory, so it is best effort.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// removeParts removes part 0 and the numbered parts of basePath.
+func removeParts(basePath string) {
+	os.Remove(basePath)
+	for i := 1; ; i++ {
+		if err := os.Remove(partName(basePath, i)); err != nil {
+			return
+		}
+	}
+}
+
+// publish moves the complete archive written at w.tempName to w.filename.
+func (w *Writer) publish() error {
+	return publishParts(w.file, w.tempName, w.filename)
+}
+
+// publishParts moves the complete archive written to file at temp to filename.
+// Parts of an earlier archive with the same name beyond the new ones are removed.
+func publishParts(file SplitFile, temp, filename string) error {
+	count := 1
+	if split, ok := file.(*SplitWriter); ok {
+		count = len(split.starts)
+	}
+	// Part 0 last, so the archive only appears under its name once whole
+	for i := count - 1; i >= 0; i-- {
+		if err := replaceFile(partName(temp, i), partName(filename, i)); err != nil {
+			return err
+		}
+	}
+	for i := count; ; i++ {
+		if err := os.Remove(partName(filename, i)); err != nil {
+			break
+		}
+	}
+	syncDir(filepath.Dir(filename))
+	return nil
+}
@@ -0,0 +1,200 @@
+package archive
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tempFiles returns the temporary files left in dir
+func tempFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var temps []string
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp-") {
+			temps = append(temps, entry.Name())
+		}
+	}
+	return temps
+}
+
+// TestAtomicPack checks that an archive only appears under its name once
+// finalized, and replaces an earlier archive with more parts
+func TestAtomicPack(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	os.MkdirAll(src, 0755)
+	os.WriteFile(filepath.Join(src, "a.bin"), bytes.Repeat([]byte("atomic"), 10_000), 0644)
+
+	archivePath := filepath.Join(tmpDir, "out.chin")
+	pack := func(split int64, finalize bool) {
+		w, err := NewWriter(archivePath, []byte("pw"), split)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		if err := w.AddFile(src, "src"); err != nil {
+			t.Fatal(err)
+		}
+		if !finalize {
+			return
+		}
+		if err := w.Finalize(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pack(10_000, true)
+	if _, err := os.Stat(partName(archivePath, 5)); err != nil {
+		t.Fatal("expected at least 6 parts")
+	}
+	before, _ := os.ReadFile(archivePath)
+
+	// An unfinished pack leaves the earlier archive as it was
+	pack(0, false)
+	if after, _ := os.ReadFile(archivePath); !bytes.Equal(before, after) {
+		t.Fatal("an unfinished pack changed the archive")
+	}
+	if temps := tempFiles(t, tmpDir); len(temps) > 0 {
+		t.Fatalf("temporary files left: %v", temps)
+	}
+
+	pack(40_000, true)
+	if _, err := os.Stat(partName(archivePath, 2)); err == nil {
+		t.Fatal("parts of the earlier archive were kept")
+	}
+	if temps := tempFiles(t, tmpDir); len(temps) > 0 {
+		t.Fatalf("temporary files left: %v", temps)
+	}
+	r, err := NewReader(archivePath, []byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err := r.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestAtomicExtract checks that a file failing verification does not replace the existing one
+func TestAtomicExtract(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	os.MkdirAll(src, 0755)
+	os.WriteFile(filepath.Join(src, "a.txt"), []byte("archived content"), 0644)
+
+	archivePath := filepath.Join(tmpDir, "out.chin")
+	w, err := NewWriter(archivePath, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddFile(src, "src"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Damage the stored copy of a.txt
+	r, err := NewReader(archivePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, _ := r.FindFile("src/a.txt")
+	r.Close()
+	data, _ := os.ReadFile(archivePath)
+	data[entry.Offset] ^= 1
+	os.WriteFile(archivePath, data, 0644)
+
+	out := filepath.Join(tmpDir, "out")
+	os.MkdirAll(filepath.Join(out, "src"), 0755)
+	target := filepath.Join(out, "src", "a.txt")
+	os.WriteFile(target, []byte("original"), 0444)
+
+	r, err = NewReader(archivePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.Conflict = ConflictOverwrite
+	if err := r.ExtractFile(*entry, out, true); err == nil {
+		t.Fatal("extracted a damaged file")
+	}
+	if content, _ := os.ReadFile(target); string(content) != "original" {
+		t.Fatalf("the existing file was replaced by %q", content)
+	}
+	if temps := tempFiles(t, filepath.Join(out, "src")); len(temps) > 0 {
+		t.Fatalf("temporary files left: %v", temps)
+	}
+
+	// An intact file replaces the read-only one
+	data[entry.Offset] ^= 1
+	os.WriteFile(archivePath, data, 0644)
+	if err := r.ExtractFile(*entry, out, true); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(target); string(content) != "archived content" {
+		t.Fatalf("got %q", content)
+	}
+}
+
+// TestAtomicExtractCorruptStream fails to authenticate an encrypted stream
+// after part of it was written, and leaves neither the file nor its temporary copy
+func TestAtomicExtractCorruptStream(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	os.MkdirAll(src, 0755)
+	os.WriteFile(filepath.Join(src, "a.bin"), bytes.Repeat([]byte("corrupt "), 40_000), 0444)
+	os.WriteFile(filepath.Join(src, "b.txt"), []byte("intact"), 0644)
+
+	archivePath := filepath.Join(tmpDir, "out.chin")
+	w, err := NewWriter(archivePath, []byte("pw"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddFile(src, "src"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Damage the last chunk of a.bin, so the first ones are written out
+	r, err := NewReader(archivePath, []byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := r.FindFile("src/a.bin")
+	b, _ := r.FindFile("src/b.txt")
+	r.Close()
+	end := b.Offset
+	if b.Offset < a.Offset {
+		end = uint64(r.header.MetadataOffset)
+	}
+	data, _ := os.ReadFile(archivePath)
+	data[end-100] ^= 1
+	os.WriteFile(archivePath, data, 0644)
+
+	r, err = NewReader(archivePath, []byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.Jobs = 2
+	out := filepath.Join(tmpDir, "out")
+	if err := r.ExtractAll(out, true); err == nil {
+		t.Fatal("extracted a damaged stream")
+	}
+	if _, err := os.Stat(filepath.Join(out, "src", "a.bin")); !os.IsNotExist(err) {
+		t.Fatal("the damaged file was extracted")
+	}
+	if temps := tempFiles(t, filepath.Join(out, "src")); len(temps) > 0 {
+		t.Fatalf("temporary files left: %v", temps)
+	}
+}
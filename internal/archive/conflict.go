@@ -0,0 +1,197 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ConflictPolicy decides what ExtractFile does when the target of a file
+// entry already exists. The zero value is ConflictFail, so existing files are
+// only replaced when asked for.
+type ConflictPolicy int
+
+const (
+	ConflictFail      ConflictPolicy = iota // Stop with ErrConflict
+	ConflictOverwrite                       // Replace the existing file
+	ConflictSkip                            // Keep the existing file
+	ConflictKeepNewer                       // Replace it only if the entry is newer
+	ConflictRename                          // Extract next to it as "name (1).ext"
+	ConflictAsk                             // Let Reader.Ask decide for each file
+)
+
+var conflictNames = map[ConflictPolicy]string{
+	ConflictOverwrite: "overwrite",
+	ConflictSkip:      "skip",
+	ConflictKeepNewer: "keep-newer",
+	ConflictRename:    "rename",
+	ConflictAsk:       "ask",
+	ConflictFail:      "fail",
+}
+
+// ErrConflict is returned by ConflictFail when a file would replace an existing one.
+var ErrConflict = errors.New("file already exists")
+
+func (p ConflictPolicy) String() string {
+	if name, ok := conflictNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("ConflictPolicy(%d)", int(p))
+}
+
+// ParseConflictPolicy parses the name of a policy, such as "keep-newer".
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	for policy, name := range conflictNames {
+		if strings.EqualFold(s, name) {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown conflict policy %q (use overwrite, skip, keep-newer, rename, ask or fail)", s)
+}
+
+// resolveConflict applies r.Conflict to the target path of a file entry
+// extracted to outputPath. It returns where to extract the entry, or skip when
+// the existing file is kept. A renamed target is created empty, so that
+// concurrent extractions cannot pick the same name.
+func (r *Reader) resolveConflict(entry FileEntry, path, outputPath string) (target string, skip bool, err error) {
+	r.conflictMu.Lock()
+	defer r.conflictMu.Unlock()
+
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return path, false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+
+	policy := r.Conflict
+	if policy == ConflictAsk {
+		if policy, err = r.ask(entry, info); err != nil {
+			return "", false, err
+		}
+	}
+	switch policy {
+	case ConflictOverwrite:
+	case ConflictSkip:
+		skip = true
+	case ConflictKeepNewer:
+		policy = keepNewer(entry, info)
+		skip = policy == ConflictSkip
+	case ConflictRename:
+		if path, err = r.reserveName(path, outputPath); err != nil {
+			return "", false, err
+		}
+	default:
+		return "", false, fmt.Errorf("%w: %s", ErrConflict, path)
+	}
+
+	r.conflict(entry, policy, path)
+	if skip {
+		r.progress(int(entry.Size))
+	}
+	return path, skip, nil
+}
+
+// keepNewer decides ConflictKeepNewer: overwrite the existing file only if the entry is newer.
+func keepNewer(entry FileEntry, existing os.FileInfo) ConflictPolicy {
+	// Stored times have a one second resolution
+	if entry.ModTime.After(existing.ModTime().Truncate(time.Second)) {
+		return ConflictOverwrite
+	}
+	return ConflictSkip
+}
+
+// freeName returns the first "name (n).ext" next to path that does not exist
+// and is not taken.
+func freeName(path string, taken func(string) bool) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) && !taken(candidate) {
+			return candidate
+		}
+	}
+}
+
+// reserveName creates an empty file under the first free name for path, which
+// is not the target of another entry extracted to outputPath. The caller holds
+// conflictMu.
+func (r *Reader) reserveName(path, outputPath string) (string, error) {
+	targets := r.entryTargets(outputPath)
+	for {
+		candidate := freeName(path, func(name string) bool { return targets[name] })
+		f, err := os.OpenFile(candidate, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		return candidate, f.Close()
+	}
+}
+
+// entryTargets returns the paths the file entries of the archive extract to
+// in outputPath. The caller holds conflictMu.
+func (r *Reader) entryTargets(outputPath string) map[string]bool {
+	if r.targets != nil && r.targetsDir == outputPath {
+		return r.targets
+	}
+	r.targets = make(map[string]bool)
+	r.targetsDir = outputPath
+	for _, entry := range r.metadata.Files {
+		if path, err := entryPath(entry, outputPath); err == nil && !entry.IsDir {
+			r.targets[path] = true
+		}
+	}
+	return r.targets
+}
+
+// checkConflicts returns ErrConflict if the target of any file entry exists,
+// so that ConflictFail stops before anything is written.
+func checkConflicts(entries []FileEntry, outputPath string) error {
+	var existing []string
+	for _, entry := range entries {
+		if entry.IsDir {
+			continue
+		}
+		path, err := entryPath(entry, outputPath)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Lstat(path); err == nil {
+			existing = append(existing, path)
+		}
+	}
+	switch len(existing) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("%w: %s", ErrConflict, existing[0])
+	default:
+		return fmt.Errorf("%w: %s and %d more", ErrConflict, existing[0], len(existing)-1)
+	}
+}
+
+// ask forwards to Ask; it is safe to call from several goroutines.
+func (r *Reader) ask(entry FileEntry, existing os.FileInfo) (ConflictPolicy, error) {
+	if r.Ask == nil {
+		return 0, errors.New("no way to ask about existing files")
+	}
+	r.callbackMu.Lock()
+	defer r.callbackMu.Unlock()
+	return r.Ask(entry, existing)
+}
+
+// conflict forwards to OnConflict; it is safe to call from several goroutines.
+func (r *Reader) conflict(entry FileEntry, action ConflictPolicy, path string) {
+	if r.OnConflict == nil {
+		return
+	}
+	r.callbackMu.Lock()
+	defer r.callbackMu.Unlock()
+	r.OnConflict(entry.Name, action, path)
+}
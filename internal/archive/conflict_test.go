@@ -0,0 +1,209 @@
+package archive
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestConflictPolicies extracts over existing files with each policy
+func TestConflictPolicies(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	os.MkdirAll(src, 0755)
+	os.WriteFile(filepath.Join(src, "old.txt"), []byte("archived old"), 0644)
+	os.WriteFile(filepath.Join(src, "new.txt"), []byte("archived new"), 0644)
+	os.WriteFile(filepath.Join(src, "free.txt"), []byte("archived free"), 0644)
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	os.Chtimes(filepath.Join(src, "old.txt"), day, day)
+	os.Chtimes(filepath.Join(src, "new.txt"), day.Add(48*time.Hour), day.Add(48*time.Hour))
+
+	archivePath := filepath.Join(tmpDir, "out.chin")
+	w, err := NewWriter(archivePath, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddFile(src, "src"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	// old.txt and new.txt exist on disk, modified on the day in between
+	populate := func(dir string) {
+		os.MkdirAll(filepath.Join(dir, "src"), 0755)
+		for _, name := range []string{"old.txt", "new.txt"} {
+			path := filepath.Join(dir, "src", name)
+			os.WriteFile(path, []byte("local"), 0644)
+			os.Chtimes(path, day.Add(24*time.Hour), day.Add(24*time.Hour))
+		}
+	}
+	read := func(dir, name string) string {
+		data, _ := os.ReadFile(filepath.Join(dir, "src", name))
+		return string(data)
+	}
+
+	cases := []struct {
+		policy  ConflictPolicy
+		answer  ConflictPolicy
+		old     string
+		new     string
+		renamed bool
+	}{
+		{policy: ConflictOverwrite, old: "archived old", new: "archived new"},
+		{policy: ConflictSkip, old: "local", new: "local"},
+		{policy: ConflictKeepNewer, old: "local", new: "archived new"},
+		{policy: ConflictRename, old: "local", new: "local", renamed: true},
+		{policy: ConflictAsk, answer: ConflictRename, old: "local", new: "local", renamed: true},
+	}
+	for _, tc := range cases {
+		out := filepath.Join(tmpDir, tc.policy.String())
+		populate(out)
+		r, err := NewReader(archivePath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Jobs = 2
+		r.Conflict = tc.policy
+		r.Ask = func(FileEntry, os.FileInfo) (ConflictPolicy, error) { return tc.answer, nil }
+		actions := map[ConflictPolicy]int{}
+		r.OnConflict = func(name string, action ConflictPolicy, path string) { actions[action]++ }
+		if err := r.ExtractAll(out, true); err != nil {
+			t.Fatalf("%s: %v", tc.policy, err)
+		}
+		r.Close()
+
+		if got := read(out, "old.txt"); got != tc.old {
+			t.Fatalf("%s: old.txt holds %q, want %q", tc.policy, got, tc.old)
+		}
+		if got := read(out, "new.txt"); got != tc.new {
+			t.Fatalf("%s: new.txt holds %q, want %q", tc.policy, got, tc.new)
+		}
+		if got := read(out, "free.txt"); got != "archived free" {
+			t.Fatalf("%s: free.txt holds %q", tc.policy, got)
+		}
+		if tc.renamed && (read(out, "old (1).txt") != "archived old" || actions[ConflictRename] != 2) {
+			t.Fatalf("%s: old.txt not extracted under another name (%v)", tc.policy, actions)
+		}
+		if total := actions[ConflictOverwrite] + actions[ConflictSkip] + actions[ConflictRename]; total != 2 {
+			t.Fatalf("%s: %d conflicts reported, want 2", tc.policy, total)
+		}
+	}
+
+	// Fail stops before writing anything
+	out := filepath.Join(tmpDir, "fail")
+	populate(out)
+	r, err := NewReader(archivePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.Conflict = ConflictFail
+	if err := r.ExtractAll(out, true); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "src", "free.txt")); !os.IsNotExist(err) {
+		t.Fatal("extracted files before failing")
+	}
+
+	if policy, err := ParseConflictPolicy("Keep-Newer"); err != nil || policy != ConflictKeepNewer {
+		t.Fatalf("parsed %v, %v", policy, err)
+	}
+}
+
+// TestConflictDefault leaves existing files alone with a Reader whose policy is not set
+func TestConflictDefault(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	os.MkdirAll(src, 0755)
+	os.WriteFile(filepath.Join(src, "a.txt"), []byte("archived"), 0644)
+
+	archivePath := filepath.Join(tmpDir, "out.chin")
+	w, err := NewWriter(archivePath, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddFile(src, "src"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(tmpDir, "out")
+	os.MkdirAll(filepath.Join(out, "src"), 0755)
+	os.WriteFile(filepath.Join(out, "src", "a.txt"), []byte("local"), 0644)
+
+	r, err := NewReader(archivePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.Conflict != ConflictFail {
+		t.Fatalf("default policy is %v", r.Conflict)
+	}
+	if err := r.ExtractAll(out, true); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(out, "src", "a.txt")); string(data) != "local" {
+		t.Fatalf("the existing file was replaced by %q", data)
+	}
+}
+
+// TestConflictRenameUnique never renames to the target of another entry, in
+// the plan as in a parallel extraction
+func TestConflictRenameUnique(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	os.MkdirAll(src, 0755)
+	os.WriteFile(filepath.Join(src, "x.txt"), []byte("archived x"), 0644)
+	os.WriteFile(filepath.Join(src, "x (1).txt"), []byte("archived x (1)"), 0644)
+
+	archivePath := filepath.Join(tmpDir, "out.chin")
+	w, err := NewWriter(archivePath, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddFile(src, "src"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(tmpDir, "out")
+	os.MkdirAll(filepath.Join(out, "src"), 0755)
+	os.WriteFile(filepath.Join(out, "src", "x.txt"), []byte("local"), 0644)
+
+	r, err := NewReader(archivePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.Jobs = 4
+	r.Conflict = ConflictRename
+
+	renamed := filepath.Join(out, "src", "x (2).txt")
+	paths := map[string]bool{}
+	for _, step := range r.PlanExtract(r.ListFiles(), out) {
+		if paths[step.Path] {
+			t.Fatalf("%s planned twice", step.Path)
+		}
+		paths[step.Path] = true
+	}
+	if !paths[renamed] {
+		t.Fatalf("x.txt not planned as x (2).txt: %v", paths)
+	}
+
+	if err := r.ExtractAll(out, true); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"x.txt": "local", "x (1).txt": "archived x (1)", "x (2).txt": "archived x"} {
+		if data, _ := os.ReadFile(filepath.Join(out, "src", name)); string(data) != want {
+			t.Fatalf("%s holds %q, want %q", name, data, want)
+		}
+	}
+}
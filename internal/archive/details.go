@@ -0,0 +1,59 @@
+package archive
+
+// EntryDetails adds to a file entry what the archive knows about how it is stored.
+type EntryDetails struct {
+	FileEntry
+	StoredSize int64 // Size of the stored stream (0 for a directory), -1 if unknown
+	Encrypted  bool
+	Padded     bool // The stream is padded to hide the size, see Writer.SetHideMetadata
+	FirstPart  int  // Part holding the start of the stream, 0 unless split
+	LastPart   int  // Part holding the end of the stream
+}
+
+// Spans reports whether the stream of the entry is stored across several parts.
+func (d EntryDetails) Spans() bool {
+	return d.LastPart > d.FirstPart
+}
+
+// ListDetails returns every entry with its stored size and flags. Stored sizes
+// are unknown (-1) if the streams of the archive are not in entry order.
+func (r *Reader) ListDetails() []EntryDetails {
+	ends, err := r.streamEnds()
+	details := make([]EntryDetails, len(r.metadata.Files))
+	for i, entry := range r.metadata.Files {
+		d := EntryDetails{
+			FileEntry: entry,
+			Encrypted: r.header.Flags&FlagEncrypted != 0 && !entry.IsDir,
+			Padded:    r.header.Flags&FlagHidden != 0 && !entry.IsDir,
+		}
+		switch {
+		case entry.IsDir:
+		case err != nil:
+			d.StoredSize = -1
+		default:
+			d.StoredSize = ends[i] - int64(entry.Offset)
+		}
+		if !entry.IsDir {
+			d.FirstPart, d.LastPart = r.partsOf(int64(entry.Offset), d.StoredSize)
+		}
+		details[i] = d
+	}
+	return details
+}
+
+// partsOf returns the first and last part holding size bytes at offset off.
+func (r *Reader) partsOf(off, size int64) (int, int) {
+	switch f := r.file.(type) {
+	case *volumeFile:
+		i := int(f.volume.Index)
+		return i, i
+	case *SplitReader:
+		first, _, _ := f.locate(off)
+		if size <= 0 {
+			return first, first
+		}
+		last, _, _ := f.locate(off + size - 1)
+		return first, last
+	}
+	return 0, 0
+}
@@ -0,0 +1,60 @@
+package archive
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"chin/internal/crypto"
+)
+
+// TestListDetails checks the stored sizes, flags and parts reported for each entry
+func TestListDetails(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	os.MkdirAll(src, 0755)
+	os.WriteFile(filepath.Join(src, "big.bin"), bytes.Repeat([]byte("details"), 10_000), 0644)
+	os.WriteFile(filepath.Join(src, "small.txt"), []byte("small"), 0644)
+
+	archivePath := filepath.Join(tmpDir, "out.chin")
+	w, err := NewWriter(archivePath, []byte("pw"), 30_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddFile(src, "src"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(archivePath, []byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	details := r.ListDetails()
+	if len(details) != 3 {
+		t.Fatalf("got %d entries, want 3", len(details))
+	}
+	for _, d := range details {
+		switch d.Name {
+		case "src":
+			if d.StoredSize != 0 || d.Encrypted {
+				t.Fatalf("directory: %+v", d)
+			}
+		case "src/big.bin":
+			if d.StoredSize != crypto.SealedSize(crypto.CipherAESGCM, 70_000) || !d.Encrypted || d.Padded {
+				t.Fatalf("big.bin: %+v", d)
+			}
+			if d.FirstPart != 0 || d.LastPart != 2 || !d.Spans() {
+				t.Fatalf("big.bin stored in parts %d to %d", d.FirstPart, d.LastPart)
+			}
+		case "src/small.txt":
+			if d.StoredSize != crypto.SealedSize(crypto.CipherAESGCM, 5) || d.Spans() {
+				t.Fatalf("small.txt: %+v", d)
+			}
+		}
+	}
+}
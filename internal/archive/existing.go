@@ -0,0 +1,49 @@
+package archive
+
+import (
+	"io"
+	"os"
+
+	"chin/internal/utils"
+)
+
+// matchesEntry reports whether the file at path already holds entry: same
+// size, modification time (to the second, as stored) and checksum. A missing
+// file or a directory does not match.
+func matchesEntry(path string, entry FileEntry) (bool, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if !info.Mode().IsRegular() || uint64(info.Size()) != entry.Size || info.ModTime().Unix() != entry.ModTime.Unix() {
+		return false, nil
+	}
+
+	// Size and time match, only the content can tell a file cut short by an
+	// interrupted extraction (its time is set last, so that is rare) or edited since
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	hasher := utils.NewXXHash64()
+	if _, err := io.CopyBuffer(hasher, f, make([]byte, 64*1024)); err != nil {
+		return false, err
+	}
+	return hasher.Sum64() == entry.Checksum, nil
+}
+
+// fileSkip forwards to OnSkip and counts the skipped file for OnProgress; it
+// is safe to call from several goroutines.
+func (r *Reader) fileSkip(entry FileEntry) {
+	r.callbackMu.Lock()
+	defer r.callbackMu.Unlock()
+	if r.OnSkip != nil {
+		r.OnSkip(entry.Name)
+	}
+	if r.OnProgress != nil {
+		r.OnProgress(int(entry.Size))
+	}
+}
@@ -0,0 +1,74 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestSkipExisting extracts again over a partial restore and only rewrites
+// the files that are missing or differ from their entry
+func TestSkipExisting(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	os.MkdirAll(filepath.Join(src, "sub"), 0755)
+	for name, content := range map[string]string{"a.txt": "alpha", "b.txt": "bravo", "sub/c.txt": "charlie", "d.txt": "delta"} {
+		os.WriteFile(filepath.Join(src, name), []byte(content), 0644)
+	}
+
+	archivePath := filepath.Join(tmpDir, "out.chin")
+	w, err := NewWriter(archivePath, []byte("pw"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddFile(src, "src"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, jobs := range []int{1, 4} {
+		out := filepath.Join(tmpDir, "out", string(rune('0'+jobs)))
+		r, err := NewReader(archivePath, []byte("pw"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.ExtractAll(out, true); err != nil {
+			t.Fatal(err)
+		}
+
+		// b.txt is edited without changing its size or time, c.txt is cut short and d.txt is lost
+		b := filepath.Join(out, "src", "b.txt")
+		info, _ := os.Stat(b)
+		os.WriteFile(b, []byte("BRAVO"), 0644)
+		os.Chtimes(b, info.ModTime(), info.ModTime())
+		os.WriteFile(filepath.Join(out, "src", "sub", "c.txt"), []byte("char"), 0644)
+		os.Remove(filepath.Join(out, "src", "d.txt"))
+
+		var skipped []string
+		var progress int
+		r.Jobs = jobs
+		r.SkipExisting = true
+		r.Conflict = ConflictOverwrite
+		r.OnSkip = func(name string) { skipped = append(skipped, filepath.ToSlash(name)) }
+		r.OnProgress = func(n int) { progress += n }
+		if err := r.ExtractAll(out, true); err != nil {
+			t.Fatal(err)
+		}
+		r.Close()
+
+		if len(skipped) != 1 || skipped[0] != "src/a.txt" {
+			t.Fatalf("jobs %d: skipped %v, want only src/a.txt", jobs, skipped)
+		}
+		if progress != len("alpha")+len("bravo")+len("charlie")+len("delta") {
+			t.Fatalf("jobs %d: progress %d does not count skipped files", jobs, progress)
+		}
+		for name, content := range map[string]string{"a.txt": "alpha", "b.txt": "bravo", "sub/c.txt": "charlie", "d.txt": "delta"} {
+			data, err := os.ReadFile(filepath.Join(out, "src", name))
+			if err != nil || string(data) != content {
+				t.Fatalf("jobs %d: %s holds %q", jobs, name, data)
+			}
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package archive
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IgnoreFileName is the per-directory ignore file honoured while packing.
+const IgnoreFileName = ".chinignore"
+
+// vcsNames are skipped by Filter.ExcludeVCS.
+var vcsNames = map[string]bool{
+	".git":           true,
+	".gitignore":     true,
+	".gitattributes": true,
+	".gitmodules":    true,
+	".svn":           true,
+	".hg":            true,
+	".hgignore":      true,
+	".hgtags":        true,
+	".bzr":           true,
+	".bzrignore":     true,
+	"CVS":            true,
+	"_darcs":         true,
+}
+
+// Filter decides which files are packed.
+// A nil Filter packs everything except other archives.
+type Filter struct {
+	Include     []string  // Glob patterns, files must match at least one (if any)
+	Exclude     []string  // Glob patterns, matching files and directories are skipped
+	ExcludeVCS  bool      // Skip version control directories and files
+	NewerThan   time.Time // Skip files modified before this time (zero = disabled)
+	MaxFileSize int64     // Skip files larger than this (0 = disabled)
+	IgnoreFiles bool      // Honour .chinignore files found during the walk
+}
+
+// WalkFunc is called by Walk for every entry that passes the filter.
+type WalkFunc func(path, name string, info os.FileInfo) error
+
+// Walk visits path (stored as name) and, for directories, everything below it
+// that passes the filter. Directories are reported before their contents; with
+// Include patterns, only those holding a file that passes.
+func Walk(path, name string, filter *Filter, fn WalkFunc) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if filter.skip(name, info, nil) {
+		return nil
+	}
+	return walk(path, name, info, filter, nil, fn)
+}
+
+func walk(path, name string, info os.FileInfo, filter *Filter, rules []ignoreRule, fn WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, name, info)
+	}
+	if filter != nil && len(filter.Include) > 0 {
+		// Reported just before the first entry below it
+		parent, reported := fn, false
+		fn = func(childPath, childName string, childInfo os.FileInfo) error {
+			if !reported {
+				reported = true
+				if err := parent(path, name, info); err != nil {
+					return err
+				}
+			}
+			return parent(childPath, childName, childInfo)
+		}
+	} else if err := fn(path, name, info); err != nil {
+		return err
+	}
+
+	if filter != nil && filter.IgnoreFiles {
+		local, err := readIgnoreFile(filepath.Join(path, IgnoreFileName), normalizeName(name))
+		if err != nil {
+			return err
+		}
+		if len(local) > 0 {
+			// Copy so sibling directories do not see each other's rules
+			rules = append(append([]ignoreRule{}, rules...), local...)
+		}
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	// Canonical byte order, independent of the file system
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	for _, entry := range entries {
+		fullPath := filepath.Join(path, entry.Name())
+		archiveName := filepath.Join(name, entry.Name())
+
+		info, err := os.Stat(fullPath)
+		if err != nil {
+			return err
+		}
+		if filter.skip(archiveName, info, rules) {
+			continue
+		}
+		if err := walk(fullPath, archiveName, info, filter, rules, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// skip reports whether an entry is filtered out.
+func (f *Filter) skip(name string, info os.FileInfo, rules []ignoreRule) bool {
+	lower := strings.ToLower(name)
+	// Archives, their parts, and the temporary files and journal of one being packed
+	archived := strings.HasSuffix(lower, ".chin") || strings.Contains(lower, ".chin.c") ||
+		strings.Contains(lower, ".chin.tmp-") || strings.HasSuffix(lower, ".chin.journal")
+	if !info.IsDir() && archived {
+		return true
+	}
+	if f == nil {
+		return false
+	}
+
+	slashName := normalizeName(name)
+	if f.ExcludeVCS && vcsNames[path.Base(slashName)] {
+		return true
+	}
+	if matchAny(f.Exclude, slashName) {
+		return true
+	}
+	if ignored(rules, slashName, info.IsDir()) {
+		return true
+	}
+
+	if info.IsDir() {
+		return false
+	}
+	if len(f.Include) > 0 && !matchAny(f.Include, slashName) {
+		return true
+	}
+	if f.MaxFileSize > 0 && info.Size() > f.MaxFileSize {
+		return true
+	}
+	if !f.NewerThan.IsZero() && info.ModTime().Before(f.NewerThan) {
+		return true
+	}
+	return false
+}
+
+// ignoreRule is one line of a .chinignore file, with gitignore semantics.
+type ignoreRule struct {
+	base     string // Directory (slash separated archive name) holding the ignore file
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+func readIgnoreFile(filename, base string) ([]ignoreRule, error) {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreLine(scanner.Text(), base); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+func parseIgnoreLine(line, base string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:] // Escaped leading "#" or "!"
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if strings.HasPrefix(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	} else if strings.Contains(line, "/") {
+		// A slash in the middle also anchors the pattern to the ignore file's directory
+		rule.anchored = true
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	rule.pattern = line
+	return rule, true
+}
+
+// ignored evaluates the rules in order; the last matching rule wins.
+func ignored(rules []ignoreRule, name string, isDir bool) bool {
+	result := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		rel := name
+		if rule.base != "" {
+			if !strings.HasPrefix(name, rule.base+"/") {
+				continue
+			}
+			rel = strings.TrimPrefix(name, rule.base+"/")
+		}
+
+		var match bool
+		if rule.anchored {
+			match = matchSegments(strings.Split(rule.pattern, "/"), strings.Split(rel, "/"))
+		} else {
+			match, _ = path.Match(rule.pattern, path.Base(rel))
+		}
+		if match {
+			result = !rule.negate
+		}
+	}
+	return result
+}
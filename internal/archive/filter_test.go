@@ -0,0 +1,96 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestIgnoreRules checks gitignore style semantics of .chinignore
+func TestIgnoreRules(t *testing.T) {
+	var rules []ignoreRule
+	for _, line := range []string{"# comment", "node_modules/", "*.log", "!keep.log", "/build"} {
+		if rule, ok := parseIgnoreLine(line, "src"); ok {
+			rules = append(rules, rule)
+		}
+	}
+
+	cases := []struct {
+		name  string
+		isDir bool
+		want  bool
+	}{
+		{"src/node_modules", true, true},
+		{"src/a/node_modules", true, true},
+		{"src/node_modules", false, false},
+		{"src/a/debug.log", false, true},
+		{"src/a/keep.log", false, false},
+		{"src/build", true, true},
+		{"src/a/build", true, false},
+		{"other/debug.log", false, false},
+	}
+
+	for _, c := range cases {
+		if got := ignored(rules, c.name, c.isDir); got != c.want {
+			t.Errorf("ignored(%q, dir=%v) = %v, want %v", c.name, c.isDir, got, c.want)
+		}
+	}
+}
+
+// walked returns the names Walk reports for src with filter, in order
+func walked(t *testing.T, src string, filter *Filter) []string {
+	t.Helper()
+	var names []string
+	err := Walk(src, "src", filter, func(path, name string, info os.FileInfo) error {
+		names = append(names, filepath.ToSlash(name))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+// TestWalkFilter walks a tree with each kind of filter
+func TestWalkFilter(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	files := map[string]int{"a.txt": 10, "big.bin": 5000, "docs/b.txt": 10, "docs/old.txt": 10,
+		"img/c.png": 10, "cache/d.txt": 10, "sub/cache/e.txt": 10, "out.chin": 10}
+	for name, size := range files {
+		path := filepath.Join(src, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, make([]byte, size), 0644)
+	}
+	cutoff := time.Now().Add(-time.Hour)
+	old := cutoff.Add(-24 * time.Hour)
+	os.Chtimes(filepath.Join(src, "docs", "old.txt"), old, old)
+	os.WriteFile(filepath.Join(src, "docs", IgnoreFileName), []byte("b.txt\n"), 0644)
+
+	cases := []struct {
+		name   string
+		filter *Filter
+		want   []string
+	}{
+		{"none", nil, []string{"src", "src/a.txt", "src/big.bin", "src/cache", "src/cache/d.txt",
+			"src/docs", "src/docs/.chinignore", "src/docs/b.txt", "src/docs/old.txt", "src/img", "src/img/c.png",
+			"src/sub", "src/sub/cache", "src/sub/cache/e.txt"}},
+		// Folders without a kept file are left out
+		{"include", &Filter{Include: []string{"*.png"}}, []string{"src", "src/img", "src/img/c.png"}},
+		{"include none", &Filter{Include: []string{"*.gif"}}, nil},
+		{"exclude dir", &Filter{Exclude: []string{"cache", "docs", "img"}}, []string{"src", "src/a.txt",
+			"src/big.bin", "src/sub"}},
+		{"size", &Filter{MaxFileSize: 100, Exclude: []string{"cache", "docs", "img"}}, []string{"src",
+			"src/a.txt", "src/sub"}},
+		{"mtime", &Filter{NewerThan: cutoff, Include: []string{"src/docs/*.txt"}}, []string{"src", "src/docs",
+			"src/docs/b.txt"}},
+		{"ignore file", &Filter{IgnoreFiles: true, Include: []string{"src/docs/*"}}, []string{"src", "src/docs",
+			"src/docs/.chinignore", "src/docs/old.txt"}},
+	}
+	for _, tc := range cases {
+		if got := walked(t, src, tc.filter); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: walked %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
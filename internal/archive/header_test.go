@@ -0,0 +1,148 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"chin/internal/crypto"
+)
+
+// TestHeaderRoundTrip checks the v7 layout including the KDF descriptor
+func TestHeaderRoundTrip(t *testing.T) {
+	h := Header{
+		Flags:          FlagEncrypted,
+		FileCount:      42,
+		MetadataOffset: 1234,
+		KDF:            crypto.DefaultArgon2id(),
+		Cipher:         crypto.CipherXChaCha20,
+	}
+	h.Salt[0] = 0xAB
+
+	got, err := DeserializeHeader(h.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != Version || got.FileCount != 42 || got.MetadataOffset != 1234 || got.Salt[0] != 0xAB {
+		t.Fatalf("unexpected header: %+v", got)
+	}
+	if got.KDF != h.KDF {
+		t.Fatalf("KDF mismatch: %v != %v", got.KDF, h.KDF)
+	}
+	if got.Cipher != h.Cipher {
+		t.Fatalf("cipher mismatch: %v != %v", got.Cipher, h.Cipher)
+	}
+}
+
+// TestHeaderV7WithoutCipher reads a v7 header written before the cipher id, whose byte was reserved
+func TestHeaderV7WithoutCipher(t *testing.T) {
+	h := Header{Version: 7, Flags: FlagEncrypted, KDF: crypto.DefaultArgon2id()}
+	got, err := DeserializeHeader(h.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Cipher != crypto.CipherAESGCM {
+		t.Fatalf("expected AES-256-GCM, got %v", got.Cipher)
+	}
+}
+
+// TestHeaderV6 ensures v6 archives are still read and implicitly use PBKDF2
+func TestHeaderV6(t *testing.T) {
+	data := make([]byte, HeaderSizeV6)
+	copy(data, Magic)
+	binary.BigEndian.PutUint16(data[MagicLength:], 6)
+	binary.BigEndian.PutUint16(data[MagicLength+2:], FlagEncrypted)
+
+	h, err := DeserializeHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Size() != HeaderSizeV6 || h.KDF != crypto.DefaultKDF() {
+		t.Fatalf("unexpected v6 header: %+v", h)
+	}
+}
+
+// TestHeaderRejectsHugeKDF guards against headers demanding absurd KDF costs
+func TestHeaderRejectsHugeKDF(t *testing.T) {
+	h := Header{Flags: FlagEncrypted, KDF: crypto.DefaultArgon2id()}
+	h.KDF.Memory = 1 << 31
+
+	if _, err := DeserializeHeader(h.Serialize()); err == nil {
+		t.Fatal("expected error for huge Argon2id memory, got nil")
+	}
+}
+
+// TestHeaderAuthenticated alters the unencrypted header of an encrypted archive
+func TestHeaderAuthenticated(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "a.txt")
+	if err := os.WriteFile(src, []byte("header"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath := filepath.Join(tmpDir, "out.chin")
+	w, err := NewWriter(archivePath, []byte("pw"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetKDF(crypto.KDFParams{Algorithm: crypto.KDFPBKDF2, Iterations: 1000})
+	if err := w.AddFile(src, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	original, _ := os.ReadFile(archivePath)
+	// Lowest byte of FileCount, and the reserved byte Serialize always writes as 0
+	for _, pos := range []int{MagicLength + 11, HeaderSizeV6 + 3} {
+		data := bytes.Clone(original)
+		data[pos] ^= 1
+		os.WriteFile(archivePath, data, 0644)
+
+		if _, err := NewReader(archivePath, []byte("pw")); err == nil {
+			t.Fatalf("header altered at byte %d accepted", pos)
+		}
+	}
+}
+
+// TestStreamBoundToOffset opens a file stream as if it were stored at another offset
+func TestStreamBoundToOffset(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "a.txt")
+	if err := os.WriteFile(src, []byte("offset"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath := filepath.Join(tmpDir, "out.chin")
+	w, err := NewWriter(archivePath, []byte("pw"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetKDF(crypto.KDFParams{Algorithm: crypto.KDFPBKDF2, Iterations: 1000})
+	if err := w.AddFile(src, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(archivePath, []byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	entry := r.metadata.Files[0]
+	if err := r.decryptStream(entry, r.entrySection(entry), io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	moved := entry
+	moved.Offset += 64
+	if err := r.decryptStream(moved, r.entrySection(entry), io.Discard); err == nil {
+		t.Fatal("stream opened at another offset")
+	}
+}
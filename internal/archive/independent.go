@@ -0,0 +1,439 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"chin/internal/crypto"
+	"chin/internal/utils"
+)
+
+// Independent volumes end every part of a split archive with a volume index,
+// a small archive of its own:
+// [Header][Key slot area][Metadata][Start 8][Length 8][Index size 4][Magic 4]
+// The header is a copy of the archive header whose DataChecksum and
+// MetadataOffset only cover the data of the part, and the metadata (encrypted
+// like the archive metadata) lists the entries stored entirely in the part.
+// Start and Length place the data of the part in the archive stream, so a
+// part can be listed and extracted when every other part is lost.
+const (
+	volumeIndexMagic    = "CHNI"
+	volumeIndexTailSize = 24
+)
+
+// volumeSet tracks the entries listed in the index of each volume.
+type volumeSet struct {
+	entries     [][]volumeEntry
+	sizes       []int64 // Serialized size of the entries of each volume
+	pending     int64   // Serialized size of the entry being written
+	pendingPart int
+}
+
+type volumeEntry struct {
+	file int    // Index in the archive metadata
+	end  uint64 // End of the stored stream, 0 for a directory
+}
+
+// SetIndependentVolumes cuts a split archive between entries, except inside
+// files larger than a volume, and ends every part with a volume index, so any
+// part can be listed and extracted without the others. It must be called
+// before any file is added.
+func (w *Writer) SetIndependentVolumes(independent bool) error {
+	split, ok := w.file.(*SplitWriter)
+	if !ok {
+		return errors.New("independent volumes require a split archive")
+	}
+	if w.started {
+		return errors.New("independent volumes must be set before files are added")
+	}
+	split.independent = independent
+	split.reserve = nil
+	w.volumes = nil
+	if independent {
+		w.volumes = &volumeSet{}
+		split.reserve = w.volumeReserve
+	}
+	return nil
+}
+
+// volumeReserve returns the size of the index of volume part, counting the entry being written.
+func (w *Writer) volumeReserve(part int) int64 {
+	size := int64(emptyMetadataSize)
+	if part < len(w.volumes.sizes) {
+		size += w.volumes.sizes[part]
+	}
+	if part == w.volumes.pendingPart {
+		size += w.volumes.pending
+	}
+	return w.volumeIndexSize(size)
+}
+
+// volumeIndexSize returns the size of a volume index whose metadata serializes to n bytes.
+func (w *Writer) volumeIndexSize(n int64) int64 {
+	if w.hidden() {
+		n = int64(padme(uint64(n + 4)))
+	}
+	if w.encrypted() {
+		n += int64(w.cipher.NonceSize() + w.cipher.Overhead())
+	}
+	return int64(HeaderSize+len(w.slotArea)) + n + volumeIndexTailSize
+}
+
+// storedSize returns the size of the stream written for a file of size bytes.
+func (w *Writer) storedSize(size int64) int64 {
+	if !w.encrypted() {
+		return size
+	}
+	if w.hidden() {
+		size = int64(padme(uint64(size)))
+	}
+	return crypto.SealedSize(w.cipher, size)
+}
+
+// placeEntry picks the volume of the next entry, whose stream is stored bytes
+// long: a new one if it does not fit in the current volume but fits in an
+// empty one, or if the index of the current volume has no room left for it.
+func (w *Writer) placeEntry(name string, stored int64) error {
+	if w.volumes == nil {
+		return nil
+	}
+	split := w.file.(*SplitWriter)
+	w.volumes.pending = entrySize(w.entryName(name))
+	w.volumes.pendingPart = split.partIndex
+
+	limit := split.limit(split.partIndex)
+	if split.currentSize+stored <= limit {
+		return nil
+	}
+	fits := stored <= split.maxSize-w.volumeIndexSize(emptyMetadataSize+w.volumes.pending)
+	if fits || split.currentSize >= limit {
+		if err := split.cut(); err != nil {
+			return err
+		}
+		w.volumes.pendingPart = split.partIndex
+	}
+	if split.limit(split.partIndex) < 0 {
+		return ErrVolumeTooSmall
+	}
+	return nil
+}
+
+// listInVolume lists the entry just added in the volume chosen by placeEntry.
+// Its stream ends at end.
+func (w *Writer) listInVolume(end uint64) {
+	if w.volumes == nil {
+		return
+	}
+	v := w.volumes
+	for len(v.entries) <= v.pendingPart {
+		v.entries = append(v.entries, nil)
+		v.sizes = append(v.sizes, 0)
+	}
+	v.entries[v.pendingPart] = append(v.entries[v.pendingPart], volumeEntry{file: len(w.metadata.Files) - 1, end: end})
+	v.sizes[v.pendingPart] += v.pending
+	v.pending = 0
+}
+
+// volumeFiles returns the entries listed in the index of volume i, whose stream ends at end.
+func (w *Writer) volumeFiles(i int, end int64) []FileEntry {
+	files := []FileEntry{}
+	if i < len(w.volumes.entries) {
+		for _, entry := range w.volumes.entries[i] {
+			// Files continued in the next part need it, they are left out
+			if entry.end <= uint64(end) {
+				files = append(files, w.metadata.Files[entry.file])
+			}
+		}
+	}
+	return files
+}
+
+// volumeIndex builds the index of part i, which holds [start, end) of the
+// stream. base is the archive header without counts, and the file data of the
+// archive ends at metadataOffset.
+func (w *Writer) volumeIndex(i int, start, end int64, base Header, metadataOffset int64) ([]byte, error) {
+	m := Metadata{
+		Version:   Version,
+		CreatedAt: w.metadata.CreatedAt,
+		Files:     w.volumeFiles(i, end),
+	}
+	m.FileCount = uint64(len(m.Files))
+	if i < len(w.metadata.Parts) {
+		m.DataChecksum = w.metadata.Parts[i].Checksum
+	} else {
+		copy(m.DataChecksum[:], utils.Blake3(nil))
+	}
+
+	header := base
+	dataFrom := max(start, int64(HeaderSize+len(w.slotArea)))
+	header.MetadataOffset = uint64(max(dataFrom, min(end, metadataOffset)))
+	header.DataChecksum = m.DataChecksum
+	if !w.hidden() {
+		header.FileCount = m.FileCount
+	}
+	headerBytes := header.Serialize()
+
+	metadataBytes, err := m.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	if w.hidden() {
+		metadataBytes = padMetadata(metadataBytes)
+	}
+	if w.encrypted() {
+		masterKey, err := w.key()
+		if err != nil {
+			return nil, err
+		}
+		encrypted, nonce, err := crypto.EncryptWithKey(metadataBytes, w.cipher, masterKey, w.volumeNonce(headerBytes, metadataBytes), headerBytes)
+		if err != nil {
+			return nil, err
+		}
+		metadataBytes = append(nonce, encrypted...)
+	}
+
+	index := append(headerBytes, w.slotArea...)
+	index = append(index, metadataBytes...)
+	tail := make([]byte, volumeIndexTailSize)
+	binary.BigEndian.PutUint64(tail[0:8], uint64(start))
+	binary.BigEndian.PutUint64(tail[8:16], uint64(end-start))
+	binary.BigEndian.PutUint32(tail[16:20], uint32(len(index)+volumeIndexTailSize))
+	copy(tail[20:], volumeIndexMagic)
+	return append(index, tail...), nil
+}
+
+// volumeTail is the fixed end of a volume index.
+type volumeTail struct {
+	start  int64 // Stream offset of the data of the part
+	length int64 // Bytes of stream in the part
+	size   int64 // Size of the whole index
+}
+
+// readVolumeTail reads the end of the index of a part of fileSize bytes and
+// checks that it accounts for the whole part.
+func readVolumeTail(f io.ReaderAt, fileSize int64) (volumeTail, error) {
+	buf := make([]byte, volumeIndexTailSize)
+	if fileSize < VolumeHeaderSize+volumeIndexTailSize {
+		return volumeTail{}, ErrPartTruncated
+	}
+	if _, err := f.ReadAt(buf, fileSize-volumeIndexTailSize); err != nil {
+		return volumeTail{}, err
+	}
+	if string(buf[20:]) != volumeIndexMagic {
+		// The index is written last, a part without it was cut short
+		return volumeTail{}, ErrPartTruncated
+	}
+	tail := volumeTail{
+		start:  int64(binary.BigEndian.Uint64(buf[0:8])),
+		length: int64(binary.BigEndian.Uint64(buf[8:16])),
+		size:   int64(binary.BigEndian.Uint32(buf[16:20])),
+	}
+	if tail.start < 0 || tail.length < 0 || tail.size < volumeIndexTailSize+HeaderSizeV6 || VolumeHeaderSize+tail.length+tail.size != fileSize {
+		return volumeTail{}, ErrPartCorrupted
+	}
+	return tail, nil
+}
+
+// volumeFile reads a single independent volume as an archive of its own: the
+// header and key slots of its index, its data at their place in the archive
+// stream, then the metadata of its index. Reading a byte of an earlier part
+// fails with that part missing.
+type volumeFile struct {
+	file     *os.File
+	name     string
+	volume   VolumeHeader
+	prefix   []byte // Header and key slot area
+	start    int64  // Stream offset of the first byte of the part
+	dataFrom int64  // First byte of file data in the part
+	dataTo   int64
+	metadata []byte
+	offset   int64
+}
+
+// openVolume opens one independent volume on its own.
+func openVolume(filename string) (*volumeFile, *Header, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, header, err := readVolume(f, filename)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return v, header, nil
+}
+
+func readVolume(f *os.File, filename string) (*volumeFile, *Header, error) {
+	volume, err := readVolumeHeader(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !volume.Independent() {
+		return nil, nil, fmt.Errorf("%s is not an independent volume", filename)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	tail, err := readVolumeTail(f, info.Size())
+	if err != nil {
+		return nil, nil, &PartsError{Parts: []PartStatus{{Index: int(volume.Index), Name: filename, Problem: err}}}
+	}
+
+	index := make([]byte, tail.size-volumeIndexTailSize)
+	if _, err := f.ReadAt(index, info.Size()-tail.size); err != nil {
+		return nil, nil, err
+	}
+	header, err := DeserializeHeader(index)
+	if err != nil {
+		return nil, nil, err
+	}
+	prefix := int64(header.Size())
+	if header.Flags&FlagKeySlots != 0 {
+		_, areaSize, err := readKeySlots(bytes.NewReader(index), prefix)
+		if err != nil {
+			return nil, nil, err
+		}
+		prefix += int64(areaSize)
+	}
+
+	v := &volumeFile{
+		file:     f,
+		name:     filename,
+		volume:   *volume,
+		prefix:   index[:prefix],
+		start:    tail.start,
+		dataFrom: max(tail.start, prefix),
+		dataTo:   int64(header.MetadataOffset),
+		metadata: index[prefix:],
+	}
+	if v.dataTo < v.dataFrom || v.dataTo > tail.start+tail.length {
+		return nil, nil, ErrInvalidFormat
+	}
+	return v, header, nil
+}
+
+// Volume returns the volume header when the reader only sees one independent
+// volume, read on its own; nil when it sees the whole archive.
+func (r *Reader) Volume() *VolumeHeader {
+	if v, ok := r.file.(*volumeFile); ok {
+		return &v.volume
+	}
+	return nil
+}
+
+// previous reports the part before the volume as missing.
+func (v *volumeFile) previous() error {
+	i := int(v.volume.Index) - 1
+	name := fmt.Sprintf("part %d", i)
+	if suffix := fmt.Sprintf(".c%02d", v.volume.Index); strings.HasSuffix(v.name, suffix) {
+		name = partName(strings.TrimSuffix(v.name, suffix), i)
+	}
+	return &PartsError{Parts: []PartStatus{{Index: i, Name: name, Problem: ErrPartMissing}}}
+}
+
+func (v *volumeFile) size() int64 {
+	return v.dataTo + int64(len(v.metadata))
+}
+
+func (v *volumeFile) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+	for len(p) > 0 {
+		var m int
+		switch {
+		case off < int64(len(v.prefix)):
+			m = copy(p, v.prefix[off:])
+		case off >= v.dataFrom && off < v.dataTo:
+			m, err = v.file.ReadAt(p[:min(int64(len(p)), v.dataTo-off)], VolumeHeaderSize+off-v.start)
+			if err == io.EOF {
+				err = &PartsError{Parts: []PartStatus{{Index: int(v.volume.Index), Name: v.name, Problem: ErrPartTruncated}}}
+			}
+		case off >= v.dataTo && off < v.size():
+			m = copy(p, v.metadata[off-v.dataTo:])
+		case off >= v.size():
+			return n, io.EOF
+		default:
+			return n, v.previous()
+		}
+		n += m
+		off += int64(m)
+		p = p[m:]
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (v *volumeFile) Read(p []byte) (int, error) {
+	if v.offset >= v.size() {
+		return 0, io.EOF
+	}
+	n, err := v.ReadAt(p, v.offset)
+	v.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (v *volumeFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += v.offset
+	case io.SeekEnd:
+		offset += v.size()
+	default:
+		return 0, fmt.Errorf("invalid whence")
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+	v.offset = offset
+	return offset, nil
+}
+
+func (v *volumeFile) Close() error              { return v.file.Close() }
+func (v *volumeFile) Sync() error               { return nil }
+func (v *volumeFile) Truncate(size int64) error { return nil } // Read only
+func (v *volumeFile) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("write not supported on a volume")
+}
+
+// writeVolumeSlots copies a new key slot area into the index of every part of
+// an independent split archive.
+func writeVolumeSlots(basePath string, area []byte) error {
+	parts, err := NewSplitReader(basePath)
+	if err != nil {
+		return err
+	}
+	defer parts.Close()
+
+	for i, f := range parts.parts {
+		if f == nil {
+			return &PartsError{Parts: []PartStatus{parts.missing(i)}}
+		}
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		tail, err := readVolumeTail(f, info.Size())
+		if err != nil {
+			return &PartsError{Parts: []PartStatus{parts.status(i, err)}}
+		}
+		// The area follows the header copy, which has the current layout
+		if err := writeAtFile(parts.names[i], area, info.Size()-tail.size+HeaderSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package archive
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"chin/internal/crypto"
+)
+
+// TestIndependentVolumes checks that parts break between files and that each one can be read alone
+func TestIndependentVolumes(t *testing.T) {
+	srcDir := filepath.Join(t.TempDir(), "src")
+	files := map[string]int{"a.bin": 30_000, "b.bin": 25_000, "c.bin": 10_000, "d/e.txt": 100, "f.bin": 150_000}
+	for name, size := range files {
+		path := filepath.Join(srcDir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, bytes.Repeat([]byte(name[:1]), size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const splitSize = 64 * 1024
+	for _, jobs := range []int{1, 4} {
+		archivePath := filepath.Join(t.TempDir(), "out.chin")
+		w, err := NewWriter(archivePath, []byte("pw"), splitSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Jobs = jobs
+		if err := w.SetIndependentVolumes(true); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.AddFile(srcDir, "src"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Finalize(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := NewReader(archivePath, []byte("pw"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Verify(); err != nil {
+			t.Fatalf("jobs %d: %v", jobs, err)
+		}
+		r.Close()
+		statuses, err := VerifyParts(archivePath, Credentials{Password: []byte("pw")})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Every part is read on its own: small files are each in one of them
+		var listed []string
+		for _, status := range statuses {
+			if status.Problem != nil {
+				t.Fatalf("jobs %d: %s: %v", jobs, status.Name, status.Problem)
+			}
+			if info, _ := os.Stat(status.Name); info.Size() > splitSize {
+				t.Fatalf("jobs %d: %s is larger than the split size", jobs, status.Name)
+			}
+			if status.Index == 0 {
+				continue
+			}
+			r, err := NewReader(status.Name, []byte("pw"))
+			if err != nil {
+				t.Fatalf("jobs %d: %s: %v", jobs, status.Name, err)
+			}
+			if r.Volume() == nil || int(r.Volume().Index) != status.Index {
+				t.Fatalf("jobs %d: %s not read as a single volume", jobs, status.Name)
+			}
+			if err := r.Verify(); err != nil {
+				t.Fatalf("jobs %d: %s: %v", jobs, status.Name, err)
+			}
+			for _, entry := range r.ListFiles() {
+				if !entry.IsDir {
+					listed = append(listed, filepath.ToSlash(entry.Name))
+				}
+			}
+			r.Close()
+		}
+		first, err := openPartAlone(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range first.ListFiles() {
+			if !entry.IsDir {
+				listed = append(listed, filepath.ToSlash(entry.Name))
+			}
+		}
+		first.Close()
+
+		// f.bin is larger than a volume and needs two of them
+		sort.Strings(listed)
+		want := []string{"src/a.bin", "src/b.bin", "src/c.bin", "src/d/e.txt"}
+		if len(listed) != len(want) {
+			t.Fatalf("jobs %d: volumes list %v, want %v", jobs, listed, want)
+		}
+		for i := range want {
+			if listed[i] != want[i] {
+				t.Fatalf("jobs %d: volumes list %v, want %v", jobs, listed, want)
+			}
+		}
+	}
+}
+
+// openPartAlone reads the first part when the others are lost, which falls back to its index.
+func openPartAlone(archivePath string) (*Reader, error) {
+	dir := filepath.Join(filepath.Dir(archivePath), "alone")
+	os.MkdirAll(dir, 0755)
+	data, err := os.ReadFile(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	alone := filepath.Join(dir, filepath.Base(archivePath))
+	if err := os.WriteFile(alone, data, 0644); err != nil {
+		return nil, err
+	}
+	return NewReader(alone, []byte("pw"))
+}
+
+// TestIndependentVolumeSlots checks that passwd updates the copy of the key slots in every volume
+func TestIndependentVolumeSlots(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "data")
+	for i, size := range []int{20_000, 20_000, 20_000} {
+		os.MkdirAll(src, 0755)
+		os.WriteFile(filepath.Join(src, string(rune('a'+i))), bytes.Repeat([]byte{byte(i)}, size), 0644)
+	}
+
+	archivePath := filepath.Join(tmpDir, "out.chin")
+	w, err := NewWriter(archivePath, []byte("old"), 32*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetIndependentVolumes(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddFile(src, "data"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	editor, err := OpenKeySlots(archivePath, Credentials{Password: []byte("old")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := editor.AddPassword([]byte("new"), nil, crypto.DefaultKDF()); err != nil {
+		t.Fatal(err)
+	}
+	if err := editor.Save(); err != nil {
+		t.Fatal(err)
+	}
+	editor.Close()
+
+	r, err := NewReader(partName(archivePath, 2), []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if len(r.ListFiles()) != 1 {
+		t.Fatalf("expected one file in c02, got %v", r.ListFiles())
+	}
+	if err := r.ExtractAll(filepath.Join(tmpDir, "out"), true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenKeySlots(partName(archivePath, 2), Credentials{Password: []byte("new")}); err == nil {
+		t.Fatal("changed the key slots of a single volume")
+	}
+}
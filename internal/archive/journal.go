@@ -0,0 +1,647 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"chin/internal/crypto"
+	"chin/internal/utils"
+)
+
+// The journal of a pack sits next to the archive while it is written and is
+// removed by Finalize. It records the entries whose data is known to be on
+// disk, so an interrupted pack can go on from the last of them:
+//
+//	[Magic "CHNJ" 4][Version 2][Base header 84][Split size 8][Flags 1]
+//	[CreatedAt 8][Data start 8][Name length 2][Temporary name][BLAKE3 32]
+//
+// followed by one record per checkpoint:
+//
+//	[Length 4][Data offset 8][Entries][BLAKE3 32]
+//	Entries: [Count 4][Count x ([Part 4][End 8][Size 8][ModTime 8][Path length 2][Path][Entry])]
+//
+// The base header is the archive header without counts and offsets, the
+// temporary name is that of the unfinished archive in the same directory,
+// entries are serialized as in the metadata, and a checksum covers everything
+// before it in its block. A torn record at the end is ignored. Size, ModTime
+// and Path are those of the source file, to tell whether it changed before
+// the pack is resumed. In an encrypted archive the entries of a record are
+// encrypted with the archive key like the metadata ([Nonce][Ciphertext], the
+// data offset as associated data), and padded when the metadata is hidden.
+const (
+	journalMagic   = "CHNJ"
+	journalVersion = 1
+
+	journalIndependent  = 1 << 0
+	journalReproducible = 1 << 1
+)
+
+var (
+	ErrResumeMismatch  = errors.New("options differ from the interrupted pack")
+	ErrJournalMismatch = errors.New("archive does not match its journal")
+)
+
+// A checkpoint is written once this much data or this many entries were added
+// since the last one. Variables so tests can checkpoint often.
+var (
+	journalInterval int64 = 64 << 20
+	journalBatch          = 1000
+)
+
+type journal struct {
+	file    *os.File
+	pending []journalEntry // Entries added since the last checkpoint
+	synced  uint64         // Data offset of the last checkpoint
+}
+
+type journalEntry struct {
+	entry  FileEntry
+	part   int    // Independent volume listing the entry
+	end    uint64 // End of the stored stream, 0 for a directory
+	source fileSource
+}
+
+// fileSource identifies the file an entry was packed from. Entries copied from
+// another archive have none.
+type fileSource struct {
+	path    string // Absolute
+	size    int64
+	modTime int64 // Unix nanoseconds
+}
+
+// journalSource returns the source of an entry packed from path, when journaling.
+func (w *Writer) journalSource(path string, info os.FileInfo) fileSource {
+	if w.journal == nil {
+		return fileSource{}
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return fileSource{path: path, size: info.Size(), modTime: info.ModTime().UnixNano()}
+}
+
+// changed reports whether the source file is no longer the one that was packed.
+func (s fileSource) changed() bool {
+	if s.path == "" {
+		return false
+	}
+	info, err := os.Lstat(s.path)
+	return err != nil || info.Size() != s.size || info.ModTime().UnixNano() != s.modTime
+}
+
+// journalRecord is a checkpoint whose entries are still sealed.
+type journalRecord struct {
+	dataOffset uint64
+	entries    []byte
+}
+
+// journalState is what a journal holds about an interrupted pack.
+type journalState struct {
+	header     []byte
+	splitSize  int64
+	flags      byte
+	createdAt  time.Time
+	dataStart  uint64
+	tempName   string // Base name of the unfinished archive
+	dataOffset uint64 // End of the data of the last checkpoint
+	records    []journalRecord
+	headerSize int64 // Length of the journal up to its first record
+}
+
+// JournalPath returns the journal kept next to an archive while it is packed.
+func JournalPath(filename string) string {
+	return filename + ".journal"
+}
+
+// SetJournal keeps a journal next to the archive, so ResumeWriter can go on
+// after an interruption and Close keeps the unfinished archive. Each
+// checkpoint syncs the archive to disk, so journaling is off by default. Any
+// journal left by an earlier pack is removed with its unfinished archive. It
+// must be called before any file is added.
+func (w *Writer) SetJournal(enabled bool) error {
+	if w.started {
+		return errors.New("the journal must be set before files are added")
+	}
+	if w.dryRun {
+		return errors.New("a dry run has no journal")
+	}
+	path := JournalPath(w.filename)
+	if state, err := readJournal(path); err == nil {
+		removeParts(filepath.Join(filepath.Dir(w.filename), state.tempName))
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	w.journaled = enabled
+	return nil
+}
+
+// ResumeWriter reopens an archive whose pack was interrupted, keeping the
+// entries its journal records and cutting the data after them. The password,
+// split size and the options set on the writer before the first AddFile must
+// match those of the interrupted pack, and AddFile then skips the entries
+// already stored. Key slots are unlocked with the password, keyfiles or an
+// identity given with AddIdentity.
+//
+// When the first file is added, the source files of the stored entries are
+// checked: from the first one that changed size or modification time or is
+// gone, the stored entries are dropped and packed again. The data kept is
+// read back to check every entry and to rebuild the data checksums.
+func ResumeWriter(filename string, password []byte, splitSize int64) (*Writer, error) {
+	state, err := readJournal(JournalPath(filename))
+	if err != nil {
+		return nil, err
+	}
+	if state.splitSize != splitSize {
+		return nil, fmt.Errorf("%w: split size %d, not %d", ErrResumeMismatch, state.splitSize, splitSize)
+	}
+
+	temp := filepath.Join(filepath.Dir(filename), state.tempName)
+	file, err := reopenArchive(temp, splitSize, state.dataOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := newWriter(file, password)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	w.filename = filename
+	w.tempName = temp
+	w.journaled = true
+	w.resume = state
+	return w, nil
+}
+
+// reopenArchive opens an unfinished archive to go on writing at off.
+func reopenArchive(filename string, splitSize int64, off uint64) (SplitFile, error) {
+	if splitSize > 0 {
+		return reopenSplitWriter(filename, splitSize, int64(off))
+	}
+	return reopenFile(filename, int64(off))
+}
+
+// reopenFile opens an archive to go on writing at off, cutting what follows.
+func reopenFile(filename string, off int64) (*os.File, error) {
+	f, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err == nil && info.Size() < off {
+		err = fmt.Errorf("%w: the archive ends before its journal", ErrJournalMismatch)
+	}
+	if err == nil {
+		err = f.Truncate(off)
+	}
+	if err == nil {
+		_, err = f.Seek(off, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// AddIdentity adds an X25519 private key to unlock the key slots of a resumed archive.
+func (w *Writer) AddIdentity(secret []byte) error {
+	if w.started {
+		return errors.New("identities must be added before files")
+	}
+	w.identities = append(w.identities, crypto.CloneSecret(secret))
+	return nil
+}
+
+// Resumed returns the number of entries kept from the interrupted pack, once
+// the first file is added.
+func (w *Writer) Resumed() int {
+	return len(w.resumed)
+}
+
+// journalFlags returns the options of the writer that the header does not show.
+func (w *Writer) journalFlags() byte {
+	var flags byte
+	if w.volumes != nil {
+		flags |= journalIndependent
+	}
+	if w.reproducible != nil {
+		flags |= journalReproducible
+	}
+	return flags
+}
+
+// startJournal creates the journal once the header and key slot area are written.
+func (w *Writer) startJournal() error {
+	if !w.journaled {
+		return nil
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+
+	f, err := os.Create(JournalPath(w.filename))
+	if err != nil {
+		return err
+	}
+	var splitSize int64
+	if split, ok := w.file.(*SplitWriter); ok {
+		splitSize = split.maxSize + VolumeHeaderSize
+	}
+	header := w.header()
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(journalMagic)
+	binary.Write(buf, binary.BigEndian, uint16(journalVersion))
+	buf.Write(header.Serialize())
+	binary.Write(buf, binary.BigEndian, splitSize)
+	buf.WriteByte(w.journalFlags())
+	binary.Write(buf, binary.BigEndian, w.metadata.CreatedAt.Unix())
+	binary.Write(buf, binary.BigEndian, w.dataOffset)
+	temp := filepath.Base(w.tempName)
+	binary.Write(buf, binary.BigEndian, uint16(len(temp)))
+	buf.WriteString(temp)
+	buf.Write(utils.Blake3(buf.Bytes()))
+
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	w.journal = &journal{file: f, synced: w.dataOffset}
+	return nil
+}
+
+// journalEntry records the entry just added, whose stream ends at end, and
+// writes a checkpoint when enough was added since the last one.
+func (w *Writer) journalEntry(end uint64, source fileSource) error {
+	if w.journal == nil {
+		return nil
+	}
+	entry := journalEntry{entry: w.metadata.Files[len(w.metadata.Files)-1], end: end, source: source}
+	if w.volumes != nil {
+		entry.part = w.volumes.pendingPart
+	}
+	w.journal.pending = append(w.journal.pending, entry)
+
+	if int64(w.dataOffset-w.journal.synced) >= journalInterval || len(w.journal.pending) >= journalBatch {
+		return w.checkpoint()
+	}
+	return nil
+}
+
+// checkpoint syncs the archive, then records the entries added since the last checkpoint.
+func (w *Writer) checkpoint() error {
+	if w.journal == nil || len(w.journal.pending) == 0 {
+		return nil
+	}
+	// The entries are only recorded once their data is on disk
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+
+	entries := new(bytes.Buffer)
+	binary.Write(entries, binary.BigEndian, uint32(len(w.journal.pending)))
+	for _, e := range w.journal.pending {
+		binary.Write(entries, binary.BigEndian, uint32(e.part))
+		binary.Write(entries, binary.BigEndian, e.end)
+		binary.Write(entries, binary.BigEndian, e.source.size)
+		binary.Write(entries, binary.BigEndian, e.source.modTime)
+		binary.Write(entries, binary.BigEndian, uint16(len(e.source.path)))
+		entries.WriteString(e.source.path)
+		serializeEntry(entries, e.entry)
+	}
+	sealed, err := w.sealJournal(entries.Bytes(), w.dataOffset)
+	if err != nil {
+		return err
+	}
+
+	record := new(bytes.Buffer)
+	binary.Write(record, binary.BigEndian, uint32(8+len(sealed)+32))
+	binary.Write(record, binary.BigEndian, w.dataOffset)
+	record.Write(sealed)
+	record.Write(utils.Blake3(record.Bytes()))
+
+	if _, err := w.journal.file.Write(record.Bytes()); err != nil {
+		return err
+	}
+	if err := w.journal.file.Sync(); err != nil {
+		return err
+	}
+	w.journal.pending = nil
+	w.journal.synced = w.dataOffset
+	return nil
+}
+
+// sealJournal encrypts the entries of a checkpoint at dataOffset with the
+// archive key, so the journal tells no more than the archive.
+func (w *Writer) sealJournal(entries []byte, dataOffset uint64) ([]byte, error) {
+	if !w.encrypted() {
+		return entries, nil
+	}
+	if w.hidden() {
+		entries = padMetadata(entries)
+	}
+	key, err := w.key()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, nonce, err := crypto.EncryptWithKey(entries, w.cipher, key, nil, binary.BigEndian.AppendUint64(nil, dataOffset))
+	if err != nil {
+		return nil, err
+	}
+	return append(nonce, encrypted...), nil
+}
+
+// openJournal decrypts the entries of a checkpoint sealed by sealJournal.
+func (w *Writer) openJournal(record journalRecord) ([]byte, error) {
+	if !w.encrypted() {
+		return record.entries, nil
+	}
+	nonceSize := w.cipher.NonceSize()
+	if len(record.entries) < nonceSize {
+		return nil, ErrInvalidFormat
+	}
+	key, err := w.key()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := crypto.DecryptWithKey(record.entries[nonceSize:], record.entries[:nonceSize], w.cipher, key, binary.BigEndian.AppendUint64(nil, record.dataOffset))
+	if err != nil {
+		return nil, err
+	}
+	if w.hidden() {
+		return unpadMetadata(entries)
+	}
+	return entries, nil
+}
+
+// removeJournal deletes the journal of a complete archive.
+func (w *Writer) removeJournal() error {
+	if w.journal == nil {
+		return nil
+	}
+	w.journal.file.Close()
+	w.journal = nil
+	return os.Remove(JournalPath(w.filename))
+}
+
+// readJournal reads the journal at path up to its last complete record.
+func readJournal(path string) (*journalState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	invalid := fmt.Errorf("%w: damaged journal %s", ErrInvalidFormat, path)
+
+	const fixedSize = 4 + 2 + HeaderSize + 8 + 1 + 8 + 8 + 2
+	if len(data) < fixedSize+32 || string(data[:4]) != journalMagic {
+		return nil, invalid
+	}
+	headerSize := fixedSize + int(binary.BigEndian.Uint16(data[fixedSize-2:]))
+	if len(data) < headerSize+32 {
+		return nil, invalid
+	}
+	if !bytes.Equal(utils.Blake3(data[:headerSize]), data[headerSize:headerSize+32]) {
+		return nil, invalid
+	}
+	if version := binary.BigEndian.Uint16(data[4:6]); version != journalVersion {
+		return nil, fmt.Errorf("%w: journal version %d", ErrInvalidVersion, version)
+	}
+	state := &journalState{header: data[6 : 6+HeaderSize]}
+	rest := data[6+HeaderSize : headerSize]
+	state.splitSize = int64(binary.BigEndian.Uint64(rest[0:8]))
+	state.flags = rest[8]
+	state.createdAt = time.Unix(int64(binary.BigEndian.Uint64(rest[9:17])), 0)
+	state.dataStart = binary.BigEndian.Uint64(rest[17:25])
+	// Only a temporary sibling of the archive, which the journal may remove
+	state.tempName = string(rest[27:])
+	archiveName := filepath.Base(strings.TrimSuffix(path, ".journal"))
+	if !strings.HasPrefix(state.tempName, archiveName+".tmp-") || filepath.Base(state.tempName) != state.tempName {
+		return nil, invalid
+	}
+	state.dataOffset = state.dataStart
+	state.headerSize = int64(headerSize + 32)
+
+	// Records, up to the first torn or damaged one
+	for size := state.headerSize; len(data[size:]) >= 4; {
+		tail := data[size:]
+		length := int64(binary.BigEndian.Uint32(tail))
+		if length < 8+32 || 4+length > int64(len(tail)) {
+			break
+		}
+		record := tail[:4+length]
+		if !bytes.Equal(utils.Blake3(record[:len(record)-32]), record[len(record)-32:]) {
+			break
+		}
+		offset := binary.BigEndian.Uint64(record[4:12])
+		if offset < state.dataOffset {
+			break
+		}
+		state.dataOffset = offset
+		state.records = append(state.records, journalRecord{dataOffset: offset, entries: record[12 : len(record)-32]})
+		size += 4 + length
+	}
+	return state, nil
+}
+
+// readJournalEntries returns the entries of a checkpoint, once opened.
+func readJournalEntries(data []byte) ([]journalEntry, error) {
+	buf := bytes.NewReader(data)
+	var count uint32
+	if err := binary.Read(buf, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+	var entries []journalEntry
+	for i := uint32(0); i < count; i++ {
+		var part uint32
+		var pathLen uint16
+		var e journalEntry
+		binary.Read(buf, binary.BigEndian, &part)
+		binary.Read(buf, binary.BigEndian, &e.end)
+		binary.Read(buf, binary.BigEndian, &e.source.size)
+		binary.Read(buf, binary.BigEndian, &e.source.modTime)
+		if err := binary.Read(buf, binary.BigEndian, &pathLen); err != nil {
+			return nil, err
+		}
+		path := make([]byte, pathLen)
+		if _, err := io.ReadFull(buf, path); err != nil {
+			return nil, err
+		}
+		e.source.path = string(path)
+		if err := deserializeEntry(buf, &e.entry, i); err != nil {
+			return nil, err
+		}
+		e.part = int(part)
+		entries = append(entries, e)
+	}
+	if buf.Len() > 0 {
+		return nil, ErrInvalidFormat
+	}
+	return entries, nil
+}
+
+// continueJournal takes over the state of the interrupted pack in place of
+// begin: it checks the options, keeps the stored entries up to the first whose
+// source file changed, checks each of them, replays them and rebuilds the
+// data checksums from the stored data.
+func (w *Writer) continueJournal() error {
+	state := w.resume
+	w.resume = nil
+
+	base, err := DeserializeHeader(state.header)
+	if err != nil {
+		return err
+	}
+	if w.encrypted() && !w.useKeySlots() {
+		// A key derived from the password uses the salt of the interrupted pack
+		salt := base.Salt
+		w.salt = salt[:]
+	}
+	header := w.header()
+	if !bytes.Equal(header.Serialize(), state.header) || w.journalFlags() != state.flags {
+		return ErrResumeMismatch
+	}
+
+	if w.useKeySlots() {
+		if err := w.unlockResumed(); err != nil {
+			return err
+		}
+	}
+	if w.dataOffset != state.dataStart {
+		return fmt.Errorf("%w: key slot area of %d bytes", ErrJournalMismatch, w.dataOffset-HeaderSize)
+	}
+
+	var entries []journalEntry
+	for _, record := range state.records {
+		data, err := w.openJournal(record)
+		if err != nil {
+			return err
+		}
+		recorded, err := readJournalEntries(data)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrJournalMismatch, err)
+		}
+		entries = append(entries, recorded...)
+	}
+
+	// Streams follow each other, so the entries after a changed file go too
+	dataOffset := state.dataOffset
+	for i, e := range entries {
+		if !e.source.changed() {
+			continue
+		}
+		entries = entries[:i]
+		dataOffset = state.dataStart
+		for _, kept := range entries {
+			dataOffset = max(dataOffset, kept.end)
+		}
+		w.file.Close()
+		if w.file, err = reopenArchive(w.tempName, state.splitSize, dataOffset); err != nil {
+			return err
+		}
+		break
+	}
+
+	w.metadata.CreatedAt = state.createdAt
+	w.resumed = make(map[string]bool)
+	for _, e := range entries {
+		if w.volumes != nil {
+			w.volumes.pending = entrySize(e.entry.Name)
+			w.volumes.pendingPart = e.part
+		}
+		w.metadata.Files = append(w.metadata.Files, e.entry)
+		w.metadata.FileCount++
+		w.listInVolume(e.end)
+		w.resumed[normalizeName(e.entry.Name)] = true
+	}
+
+	// Any stored file may have been damaged since it was checkpointed
+	r := &Reader{file: w.file, header: header}
+	r.header.MetadataOffset = dataOffset
+	if w.encrypted() {
+		if r.masterKey, err = w.key(); err != nil {
+			return err
+		}
+	}
+	for _, e := range entries {
+		if e.entry.IsDir {
+			continue
+		}
+		if err := r.verifyEntry(e.entry); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrJournalMismatch, e.entry.Name, err)
+		}
+	}
+
+	// DataChecksum and the part checksums cover the data from the start
+	if split, ok := w.file.(*SplitWriter); ok {
+		w.parts = newPartHasher(split, int64(w.dataOffset))
+	}
+	stored := io.NewSectionReader(w.file, int64(w.dataOffset), int64(dataOffset-w.dataOffset))
+	if _, err := io.CopyBuffer(w.dataSink(), stored, make([]byte, 256*1024)); err != nil {
+		return err
+	}
+	w.dataOffset = dataOffset
+
+	// The kept entries are written again as a single checkpoint
+	f, err := os.OpenFile(JournalPath(w.filename), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(state.headerSize); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(state.headerSize, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	w.journal = &journal{file: f, pending: entries, synced: dataOffset}
+	return w.checkpoint()
+}
+
+// unlockResumed opens the key slot area written by the interrupted pack.
+func (w *Writer) unlockResumed() error {
+	slots, size, err := readKeySlots(w.file, HeaderSize)
+	if err != nil {
+		return err
+	}
+	area := make([]byte, size)
+	if _, err := w.file.ReadAt(area, HeaderSize); err != nil {
+		return err
+	}
+	key, _, err := unlockKeySlots(slots, Credentials{Password: w.password, Keyfiles: w.keyfiles, Identities: w.identities})
+	if err != nil {
+		return err
+	}
+	w.keyOnce.Do(func() {
+		w.masterKey = key
+		crypto.Protect(w.masterKey)
+	})
+	w.slotArea = area
+	w.dataOffset += uint64(size)
+	return nil
+}
+
+// skipResumed reports whether an entry was stored before the pack was resumed.
+// Skipped files still count for OnProgress.
+func (w *Writer) skipResumed(name string, info os.FileInfo) bool {
+	if !w.resumed[normalizeName(w.entryName(name))] {
+		return false
+	}
+	if !info.IsDir() && w.OnProgress != nil {
+		w.OnProgress(int(info.Size()))
+	}
+	return true
+}
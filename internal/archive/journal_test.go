@@ -0,0 +1,300 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"chin/internal/crypto"
+)
+
+// interruptedPack packs src without the files matching skip and stops before
+// Finalize, as if the process was killed. The last entry is not checkpointed
+// and a partial stream and a torn journal record follow the data. It returns
+// the name of the unfinished archive.
+func interruptedPack(t *testing.T, out string, split int64, setup func(*Writer), skip string) string {
+	t.Helper()
+	w, err := NewWriter(out, []byte("pw"), split)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setup(w)
+	if err := w.SetJournal(true); err != nil {
+		t.Fatal(err)
+	}
+	w.Filter = &Filter{Exclude: []string{skip}}
+	if err := w.AddFile(filepath.Join(filepath.Dir(out), "src"), "src"); err != nil {
+		t.Fatal(err)
+	}
+	w.file.Write(bytes.Repeat([]byte{0xAA}, 5000))
+	w.journal.file.Write([]byte{0, 0, 1, 0, 1, 2, 3})
+	w.journal.file.Close()
+	w.file.Close()
+	return w.tempName
+}
+
+// TestResumePack interrupts packs with various options and checks that the
+// resumed archive holds every file once
+func TestResumePack(t *testing.T) {
+	defer func(batch int) { journalBatch = batch }(journalBatch)
+	journalBatch = 2
+
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	files := map[string]int{"a.bin": 30_000, "b.bin": 25_000, "c.bin": 10_000, "d/e.txt": 100, "d/f.bin": 90_000, "g.txt": 7}
+	for name, size := range files {
+		path := filepath.Join(src, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, bytes.Repeat([]byte(name[:1]), size), 0644)
+	}
+	reproducible := ReproducibleOptions{SourceDate: time.Unix(1700000000, 0), SaltSeed: []byte("seed")}
+
+	cases := []struct {
+		name  string
+		split int64
+		setup func(*Writer)
+	}{
+		{"plain", 0, func(w *Writer) {}},
+		{"hidden split", 64 * 1024, func(w *Writer) { w.SetHideMetadata(true) }},
+		{"independent", 64 * 1024, func(w *Writer) { w.SetIndependentVolumes(true); w.Jobs = 4 }},
+		{"reproducible", 0, func(w *Writer) { w.SetReproducible(reproducible) }},
+	}
+	for i, tc := range cases {
+		archivePath := filepath.Join(tmpDir, fmt.Sprintf("out%d.chin", i))
+		temp := interruptedPack(t, archivePath, tc.split, tc.setup, "g.txt")
+		if _, err := os.Stat(archivePath); !os.IsNotExist(err) {
+			t.Fatalf("%s: unfinished archive under its final name", tc.name)
+		}
+
+		w, err := ResumeWriter(archivePath, []byte("pw"), tc.split)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		tc.setup(w)
+		if err := w.AddFile(src, "src"); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if w.Resumed() == 0 {
+			t.Fatalf("%s: no entry kept", tc.name)
+		}
+		if err := w.Finalize(); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if _, err := os.Stat(JournalPath(archivePath)); !os.IsNotExist(err) {
+			t.Fatalf("%s: journal left after Finalize", tc.name)
+		}
+		if _, err := os.Stat(temp); !os.IsNotExist(err) {
+			t.Fatalf("%s: temporary archive left after Finalize", tc.name)
+		}
+
+		r, err := NewReader(archivePath, []byte("pw"))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if err := r.Verify(); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if len(r.ListFiles()) != len(files)+2 {
+			t.Fatalf("%s: expected %d entries, got %d", tc.name, len(files)+2, len(r.ListFiles()))
+		}
+		r.Close()
+		if tc.split > 0 {
+			statuses, err := VerifyParts(archivePath, Credentials{Password: []byte("pw")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, status := range statuses {
+				if status.Problem != nil {
+					t.Fatalf("%s: %s: %v", tc.name, status.Name, status.Problem)
+				}
+			}
+		}
+
+		if tc.name == "reproducible" {
+			// Resuming does not change a single byte
+			fresh := filepath.Join(tmpDir, "fresh.chin")
+			w, _ := NewWriter(fresh, []byte("pw"), 0)
+			w.SetReproducible(reproducible)
+			if err := w.AddFile(src, "src"); err != nil {
+				t.Fatal(err)
+			}
+			w.Finalize()
+			a, _ := os.ReadFile(archivePath)
+			b, _ := os.ReadFile(fresh)
+			if !bytes.Equal(a, b) {
+				t.Fatal("resumed reproducible archive differs from a fresh one")
+			}
+		}
+	}
+}
+
+// TestResumeMismatch refuses to resume with other options or a damaged archive
+func TestResumeMismatch(t *testing.T) {
+	defer func(batch int) { journalBatch = batch }(journalBatch)
+	journalBatch = 1
+
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	os.MkdirAll(src, 0755)
+	os.WriteFile(filepath.Join(src, "a.bin"), bytes.Repeat([]byte("a"), 20_000), 0644)
+	os.WriteFile(filepath.Join(src, "b.bin"), bytes.Repeat([]byte("b"), 20_000), 0644)
+	archivePath := filepath.Join(tmpDir, "out.chin")
+	resume := func(password string, split int64, setup func(*Writer)) error {
+		w, err := ResumeWriter(archivePath, []byte(password), split)
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		setup(w)
+		return w.AddFile(src, "src")
+	}
+	none := func(w *Writer) {}
+
+	temp := interruptedPack(t, archivePath, 0, none, "b.bin")
+	if err := resume("pw", 32*1024, none); !errors.Is(err, ErrResumeMismatch) {
+		t.Fatalf("other split size: %v", err)
+	}
+	if err := resume("pw", 0, func(w *Writer) { w.SetCipher(crypto.CipherXChaCha20) }); !errors.Is(err, ErrResumeMismatch) {
+		t.Fatalf("other cipher: %v", err)
+	}
+	if err := resume("wrong", 0, none); err == nil {
+		t.Fatal("resumed with a wrong password")
+	}
+
+	// The last checkpointed file is checked before going on
+	data, _ := os.ReadFile(temp)
+	data[len(data)-100] ^= 1
+	os.WriteFile(temp, data, 0644)
+	if err := resume("pw", 0, none); !errors.Is(err, ErrJournalMismatch) {
+		t.Fatalf("damaged entry: %v", err)
+	}
+	os.Truncate(temp, 1000)
+	if err := resume("pw", 0, none); !errors.Is(err, ErrJournalMismatch) {
+		t.Fatalf("truncated archive: %v", err)
+	}
+
+	// Packing again without --resume starts over
+	w, err := NewWriter(archivePath, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetJournal(true); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if _, err := os.Stat(temp); !os.IsNotExist(err) {
+		t.Fatal("the unfinished archive of the earlier pack was kept")
+	}
+}
+
+// TestResumeChanged packs again the files changed since the interruption, and
+// keeps the journal of an encrypted archive free of names
+func TestResumeChanged(t *testing.T) {
+	defer func(batch int) { journalBatch = batch }(journalBatch)
+	journalBatch = 1
+
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	os.MkdirAll(src, 0755)
+	for _, name := range []string{"a.bin", "b.bin", "c.bin"} {
+		os.WriteFile(filepath.Join(src, name), bytes.Repeat([]byte(name[:1]), 20_000), 0644)
+	}
+	archivePath := filepath.Join(tmpDir, "out.chin")
+	interruptedPack(t, archivePath, 0, func(w *Writer) {}, "c.bin")
+
+	journal, _ := os.ReadFile(JournalPath(archivePath))
+	if bytes.Contains(journal, []byte("a.bin")) || bytes.Contains(journal, []byte(src)) {
+		t.Fatal("journal of an encrypted archive holds plaintext names")
+	}
+
+	// Same size, other contents and time
+	changed := bytes.Repeat([]byte("z"), 20_000)
+	os.WriteFile(filepath.Join(src, "a.bin"), changed, 0644)
+	os.Chtimes(filepath.Join(src, "a.bin"), time.Unix(1700000000, 0), time.Unix(1700000000, 0))
+
+	w, err := ResumeWriter(archivePath, []byte("pw"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddFile(src, "src"); err != nil {
+		t.Fatal(err)
+	}
+	// Only the directory comes before a.bin
+	if w.Resumed() != 1 {
+		t.Fatalf("expected 1 entry kept, got %d", w.Resumed())
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(archivePath, []byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err := r.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(tmpDir, "out")
+	if err := r.ExtractAll(out, true); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(out, "src", "a.bin")); !bytes.Equal(data, changed) {
+		t.Fatal("changed file was not packed again")
+	}
+}
+
+// TestResumeAfterClose closes an encrypted pack whose entries are all pending:
+// Close checkpoints them before it wipes the key, so they can be resumed
+func TestResumeAfterClose(t *testing.T) {
+	defer func(batch int) { journalBatch = batch }(journalBatch)
+	journalBatch = 100
+
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	os.MkdirAll(src, 0755)
+	os.WriteFile(filepath.Join(src, "a.bin"), bytes.Repeat([]byte("a"), 20_000), 0644)
+	os.WriteFile(filepath.Join(src, "b.bin"), bytes.Repeat([]byte("b"), 20_000), 0644)
+
+	archivePath := filepath.Join(tmpDir, "out.chin")
+	w, err := NewWriter(archivePath, []byte("pw"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetJournal(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddFile(src, "src"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = ResumeWriter(archivePath, []byte("pw"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddFile(src, "src"); err != nil {
+		t.Fatal(err)
+	}
+	if w.Resumed() != 3 {
+		t.Fatalf("expected 3 entries kept, got %d", w.Resumed())
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(archivePath, []byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err := r.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
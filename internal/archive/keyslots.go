@@ -0,0 +1,407 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"chin/internal/crypto"
+)
+
+// Key slot types. Each slot holds the archive key wrapped for one credential.
+const (
+	SlotX25519   uint8 = 1 // [Ephemeral public key 32][Wrapped archive key 48]
+	SlotPassword uint8 = 2 // [KDF descriptor 12][Salt 16][Wrapped archive key 48]
+)
+
+// Key slot flags.
+const (
+	SlotFlagKeyfiles uint8 = 1 // The password slot also needs keyfiles
+)
+
+const kdfDescriptorSize = 12
+
+const (
+	// KeySlotAreaSize is the minimum size reserved for key slots after the header,
+	// leaving room to add slots later without moving the data.
+	KeySlotAreaSize = 1024
+	// maxKeySlotArea bounds what a reader accepts from a crafted archive.
+	maxKeySlotArea = 1024 * 1024
+)
+
+var ErrKeySlotAreaFull = errors.New("key slot area is full")
+
+// KeySlot is one entry of the key slot area.
+type KeySlot struct {
+	Type  uint8
+	Flags uint8
+	Data  []byte
+}
+
+// serializeKeySlots encodes the key slot area, padded with zeros to at least minSize:
+// [AreaSize 4][SlotCount 2] then per slot [Type 1][Flags 1][Length 2][Data]
+func serializeKeySlots(slots []KeySlot, minSize int) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, uint32(0)) // Patched below
+	binary.Write(buf, binary.BigEndian, uint16(len(slots)))
+	for _, slot := range slots {
+		buf.WriteByte(slot.Type)
+		buf.WriteByte(slot.Flags)
+		binary.Write(buf, binary.BigEndian, uint16(len(slot.Data)))
+		buf.Write(slot.Data)
+	}
+
+	area := buf.Bytes()
+	if len(area) < minSize {
+		area = append(area, make([]byte, minSize-len(area))...)
+	}
+	if len(area) > maxKeySlotArea {
+		return nil, ErrKeySlotAreaFull
+	}
+	binary.BigEndian.PutUint32(area[:4], uint32(len(area)))
+	return area, nil
+}
+
+// readKeySlots reads the key slot area that starts at offset.
+func readKeySlots(r io.ReaderAt, offset int64) ([]KeySlot, int, error) {
+	sizeBytes := make([]byte, 4)
+	if _, err := r.ReadAt(sizeBytes, offset); err != nil {
+		return nil, 0, fmt.Errorf("reading key slots: %w", err)
+	}
+	size := binary.BigEndian.Uint32(sizeBytes)
+	if size < 6 || size > maxKeySlotArea {
+		return nil, 0, fmt.Errorf("invalid key slot area size (%d): corrupted header", size)
+	}
+
+	area := make([]byte, size)
+	if _, err := r.ReadAt(area, offset); err != nil {
+		return nil, 0, fmt.Errorf("reading key slots: %w", err)
+	}
+
+	count := binary.BigEndian.Uint16(area[4:6])
+	slots := make([]KeySlot, 0, count)
+	pos := 6
+	for i := 0; i < int(count); i++ {
+		if pos+4 > len(area) {
+			return nil, 0, fmt.Errorf("key slot %d truncated: corrupted header", i)
+		}
+		slotType, flags := area[pos], area[pos+1]
+		length := int(binary.BigEndian.Uint16(area[pos+2 : pos+4]))
+		pos += 4
+		if pos+length > len(area) {
+			return nil, 0, fmt.Errorf("key slot %d truncated: corrupted header", i)
+		}
+		slots = append(slots, KeySlot{Type: slotType, Flags: flags, Data: area[pos : pos+length]})
+		pos += length
+	}
+
+	return slots, int(size), nil
+}
+
+// newX25519Slot wraps the archive key for a recipient.
+func newX25519Slot(archiveKey, recipient []byte) (KeySlot, error) {
+	ephemeral, wrapped, err := crypto.WrapKeyX25519(archiveKey, recipient)
+	if err != nil {
+		return KeySlot{}, err
+	}
+	return KeySlot{Type: SlotX25519, Data: append(ephemeral, wrapped...)}, nil
+}
+
+// newPasswordSlot wraps the archive key with a key derived from the password and keyfiles.
+func newPasswordSlot(archiveKey []byte, password []byte, keyfiles [][]byte, kdf crypto.KDFParams) (KeySlot, error) {
+	salt, err := crypto.GenerateSalt()
+	if err != nil {
+		return KeySlot{}, err
+	}
+	wrapKey, err := kdf.DeriveWithKeyfiles(password, keyfiles, salt)
+	if err != nil {
+		return KeySlot{}, err
+	}
+	defer crypto.Wipe(wrapKey)
+	wrapped, err := crypto.WrapKey(wrapKey, archiveKey)
+	if err != nil {
+		return KeySlot{}, err
+	}
+
+	data := encodeKDF(kdf)
+	data = append(data, salt...)
+	data = append(data, wrapped...)
+	slot := KeySlot{Type: SlotPassword, Data: data}
+	if len(keyfiles) > 0 {
+		slot.Flags |= SlotFlagKeyfiles
+	}
+	return slot, nil
+}
+
+// encodeKDF encodes a KDF descriptor for a key slot:
+// [Algorithm 1][Parallelism 1][Reserved 2][Iterations 4][Memory KiB 4]
+func encodeKDF(p crypto.KDFParams) []byte {
+	buf := make([]byte, kdfDescriptorSize)
+	buf[0] = p.Algorithm
+	buf[1] = p.Parallelism
+	binary.BigEndian.PutUint32(buf[4:8], p.Iterations)
+	binary.BigEndian.PutUint32(buf[8:12], p.Memory)
+	return buf
+}
+
+func decodeKDF(buf []byte) crypto.KDFParams {
+	return crypto.KDFParams{
+		Algorithm:   buf[0],
+		Parallelism: buf[1],
+		Iterations:  binary.BigEndian.Uint32(buf[4:8]),
+		Memory:      binary.BigEndian.Uint32(buf[8:12]),
+	}
+}
+
+// Describe returns a short human readable description of the slot.
+func (s KeySlot) Describe() string {
+	switch s.Type {
+	case SlotX25519:
+		return "recipient (x25519)"
+	case SlotPassword:
+		if len(s.Data) < kdfDescriptorSize {
+			return "password (corrupted)"
+		}
+		kind := "password"
+		if s.Flags&SlotFlagKeyfiles != 0 {
+			kind = "password + keyfile"
+		}
+		return kind + ", " + decodeKDF(s.Data).String()
+	default:
+		return fmt.Sprintf("unknown (type %d)", s.Type)
+	}
+}
+
+// unlockKeySlots returns the archive key and the index of the first slot the credentials open.
+func unlockKeySlots(slots []KeySlot, creds Credentials) ([]byte, int, error) {
+	keyfileMissing := false
+	for i, slot := range slots {
+		switch slot.Type {
+		case SlotX25519:
+			if len(slot.Data) != 32+crypto.WrappedKeySize {
+				continue
+			}
+			for _, identity := range creds.Identities {
+				key, err := crypto.UnwrapKeyX25519(identity, slot.Data[:32], slot.Data[32:])
+				if err == nil {
+					return key, i, nil
+				}
+			}
+
+		case SlotPassword:
+			if len(slot.Data) != kdfDescriptorSize+crypto.SaltSize+crypto.WrappedKeySize {
+				continue
+			}
+			needsKeyfiles := slot.Flags&SlotFlagKeyfiles != 0
+			if needsKeyfiles && len(creds.Keyfiles) == 0 {
+				keyfileMissing = true
+				continue
+			}
+			if len(creds.Password) == 0 && !needsKeyfiles {
+				continue
+			}
+			kdf := decodeKDF(slot.Data)
+			salt := slot.Data[kdfDescriptorSize : kdfDescriptorSize+crypto.SaltSize]
+			// Validate before deriving, a crafted slot must not demand unbounded memory
+			if err := kdf.Validate(); err != nil {
+				return nil, -1, err
+			}
+			var keyfiles [][]byte
+			if needsKeyfiles {
+				keyfiles = creds.Keyfiles
+			}
+			wrapKey, err := kdf.DeriveWithKeyfiles(creds.Password, keyfiles, salt)
+			if err != nil {
+				return nil, -1, err
+			}
+			key, err := crypto.UnwrapKey(wrapKey, slot.Data[kdfDescriptorSize+crypto.SaltSize:])
+			crypto.Wipe(wrapKey)
+			if err == nil {
+				return key, i, nil
+			}
+		}
+	}
+
+	if keyfileMissing && len(creds.Identities) == 0 {
+		return nil, -1, crypto.ErrKeyfileRequired
+	}
+	if len(creds.Password) > 0 || len(creds.Keyfiles) > 0 {
+		return nil, -1, crypto.ErrInvalidPassword
+	}
+	return nil, -1, crypto.ErrNoMatchingKey
+}
+
+// ErrNoKeySlots is returned when editing credentials of an archive whose key is
+// derived directly from the password (or that is not encrypted at all).
+var ErrNoKeySlots = errors.New("archive has no key slots (created before v7 key slots or not encrypted); repack it to change credentials")
+
+// KeySlotEditor changes the key slots of an archive in place. Only the key slot
+// area is rewritten: the data, metadata and DataChecksum stay untouched.
+type KeySlotEditor struct {
+	filename    string
+	split       bool
+	independent bool // Every part keeps a copy of the area in its volume index
+	areaOffset  int64
+	capacity    int
+	slots       []KeySlot
+	unlocked    int
+	archiveKey  []byte
+}
+
+// OpenKeySlots unlocks an archive with the given credentials for editing.
+func OpenKeySlots(filename string, creds Credentials) (*KeySlotEditor, error) {
+	r, err := NewReaderWithCredentials(filename, creds)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	if r.header.Flags&FlagKeySlots == 0 {
+		return nil, ErrNoKeySlots
+	}
+	if volume := r.Volume(); volume != nil {
+		return nil, fmt.Errorf("only part %d of the archive could be read, all parts are needed to change its key slots", volume.Index)
+	}
+	split, _ := r.file.(*SplitReader)
+
+	return &KeySlotEditor{
+		filename:    filename,
+		split:       r.header.Flags&FlagSplit != 0,
+		independent: split != nil && split.independent,
+		areaOffset:  int64(r.header.Size()),
+		capacity:    r.slotArea,
+		slots:       append([]KeySlot(nil), r.slots...),
+		unlocked:    r.unlockedSlot,
+		// The reader wipes its key when closed
+		archiveKey: crypto.CloneSecret(r.masterKey),
+	}, nil
+}
+
+// Close wipes the archive key. The editor cannot be used afterwards.
+func (e *KeySlotEditor) Close() {
+	crypto.Wipe(e.archiveKey)
+}
+
+// Slots returns the current key slots.
+func (e *KeySlotEditor) Slots() []KeySlot {
+	return e.slots
+}
+
+// Unlocked returns the index of the slot that the credentials opened, or -1 if it was removed.
+func (e *KeySlotEditor) Unlocked() int {
+	return e.unlocked
+}
+
+// AddPassword adds a password slot. With keyfiles (digests from crypto.HashKeyfile),
+// the slot needs the password and all of the keyfiles; the password may then be empty.
+func (e *KeySlotEditor) AddPassword(password []byte, keyfiles [][]byte, kdf crypto.KDFParams) error {
+	if len(password) == 0 && len(keyfiles) == 0 {
+		return errors.New("password must not be empty")
+	}
+	if err := kdf.Validate(); err != nil {
+		return err
+	}
+	slot, err := newPasswordSlot(e.archiveKey, password, keyfiles, kdf)
+	if err != nil {
+		return err
+	}
+	e.slots = append(e.slots, slot)
+	return nil
+}
+
+// AddRecipient adds a slot for an X25519 public key.
+func (e *KeySlotEditor) AddRecipient(publicKey []byte) error {
+	slot, err := newX25519Slot(e.archiveKey, publicKey)
+	if err != nil {
+		return err
+	}
+	e.slots = append(e.slots, slot)
+	return nil
+}
+
+// Remove deletes slot i. The last slot cannot be removed, it would make the archive unreadable.
+func (e *KeySlotEditor) Remove(i int) error {
+	if i < 0 || i >= len(e.slots) {
+		return fmt.Errorf("no key slot %d (archive has %d)", i, len(e.slots))
+	}
+	if len(e.slots) == 1 {
+		return errors.New("cannot remove the last key slot")
+	}
+	e.slots = append(e.slots[:i], e.slots[i+1:]...)
+	switch {
+	case i == e.unlocked:
+		e.unlocked = -1
+	case i < e.unlocked:
+		e.unlocked--
+	}
+	return nil
+}
+
+// Save rewrites the key slot area. The area keeps its size, so the slots must
+// fit in the space reserved when the archive was packed.
+func (e *KeySlotEditor) Save() error {
+	area, err := serializeKeySlots(e.slots, e.capacity)
+	if err != nil {
+		return err
+	}
+	if len(area) > e.capacity {
+		return fmt.Errorf("%w: %d bytes needed, %d reserved", ErrKeySlotAreaFull, len(area), e.capacity)
+	}
+	if err := writeAtParts(e.filename, e.split, area, e.areaOffset); err != nil {
+		return err
+	}
+	if e.independent {
+		return writeVolumeSlots(e.filename, area)
+	}
+	return nil
+}
+
+// writeAtParts writes p at a virtual offset of a (possibly split) archive and syncs it.
+func writeAtParts(basePath string, split bool, p []byte, off int64) error {
+	if !split {
+		return writeAtFile(basePath, p, off)
+	}
+
+	// Only used to map the offset to parts, which are written through their own handles
+	parts, err := NewSplitReader(basePath)
+	if err != nil {
+		return err
+	}
+	defer parts.Close()
+
+	for len(p) > 0 {
+		if off >= parts.totalSize {
+			return io.ErrShortWrite
+		}
+		i, fileOffset, n := parts.locate(off)
+		if parts.parts[i] == nil {
+			return &PartsError{Parts: []PartStatus{parts.missing(i)}}
+		}
+		n = min(n, int64(len(p)))
+		if err := writeAtFile(parts.names[i], p[:n], fileOffset); err != nil {
+			return err
+		}
+		p = p[n:]
+		off += n
+	}
+	return nil
+}
+
+func writeAtFile(name string, p []byte, off int64) error {
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(p, off); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,165 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"chin/internal/crypto"
+)
+
+// TestRecipientArchive packs for one X25519 recipient and opens it with the right and wrong identity
+func TestRecipientArchive(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "secret.txt")
+	if err := os.WriteFile(src, []byte("ops only"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	private, public, err := crypto.GenerateX25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, _ := crypto.GenerateX25519()
+
+	archivePath := filepath.Join(tmpDir, "out.chin")
+	w, err := NewWriter(archivePath, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddRecipient(public); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddFile(src, "secret.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewReaderWithCredentials(archivePath, Credentials{Identities: [][]byte{other}}); !errors.Is(err, crypto.ErrNoMatchingKey) {
+		t.Fatalf("expected ErrNoMatchingKey, got %v", err)
+	}
+
+	r, err := NewReaderWithCredentials(archivePath, Credentials{Identities: [][]byte{other, private}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if err := r.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(tmpDir, "dest")
+	if err := r.ExtractAll(dest, true); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(filepath.Join(dest, "secret.txt"))
+	if string(got) != "ops only" {
+		t.Fatalf("unexpected content %q", got)
+	}
+}
+
+// TestChangePassword rotates the password of a split archive and checks that only the key slot area changed
+func TestChangePassword(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "data.bin")
+	if err := os.WriteFile(src, bytes.Repeat([]byte("0123456789"), 500), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath := filepath.Join(tmpDir, "out.chin")
+	w, err := NewWriter(archivePath, []byte("old"), 1500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetKDF(crypto.KDFParams{Algorithm: crypto.KDFPBKDF2, Iterations: 1000})
+	if err := w.AddFile(src, "data.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(archivePath + ".c01")
+
+	editor, err := OpenKeySlots(archivePath, Credentials{Password: []byte("old")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := editor.AddPassword([]byte("new"), nil, crypto.KDFParams{Algorithm: crypto.KDFPBKDF2, Iterations: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	if err := editor.Remove(editor.Unlocked()); err != nil {
+		t.Fatal(err)
+	}
+	if err := editor.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	after, _ := os.ReadFile(archivePath + ".c01")
+	if !bytes.Equal(before, after) {
+		t.Fatal("data region changed")
+	}
+	if _, err := NewReader(archivePath, []byte("old")); !errors.Is(err, crypto.ErrInvalidPassword) {
+		t.Fatalf("expected ErrInvalidPassword for the old password, got %v", err)
+	}
+
+	r, err := NewReader(archivePath, []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err := r.ExtractAll(filepath.Join(tmpDir, "dest"), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := editor.Remove(0); err == nil {
+		t.Fatal("removing the last slot should fail")
+	}
+}
+
+// TestKeyfileArchive requires a password and a keyfile, in both key slot and direct derivation mode
+func TestKeyfileArchive(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "secret.txt")
+	if err := os.WriteFile(src, []byte("usb stick"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keyfile, _ := crypto.HashKeyfile(bytes.NewReader([]byte("keyfile content")))
+	other, _ := crypto.HashKeyfile(bytes.NewReader([]byte("another file")))
+
+	for _, seeded := range []bool{false, true} {
+		archivePath := filepath.Join(tmpDir, "out.chin")
+		w, err := NewWriter(archivePath, []byte("pw"), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.SetKDF(crypto.KDFParams{Algorithm: crypto.KDFPBKDF2, Iterations: 1000})
+		if seeded {
+			w.SetReproducible(ReproducibleOptions{SaltSeed: []byte("seed")})
+		}
+		if err := w.AddKeyfile(keyfile); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.AddFile(src, "secret.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Finalize(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := NewReader(archivePath, []byte("pw")); !errors.Is(err, crypto.ErrKeyfileRequired) {
+			t.Fatalf("seeded=%v: expected ErrKeyfileRequired, got %v", seeded, err)
+		}
+		if _, err := NewReaderWithCredentials(archivePath, Credentials{Password: []byte("pw"), Keyfiles: [][]byte{other}}); err == nil {
+			t.Fatalf("seeded=%v: wrong keyfile accepted", seeded)
+		}
+		r, err := NewReaderWithCredentials(archivePath, Credentials{Password: []byte("pw"), Keyfiles: [][]byte{keyfile}})
+		if err != nil {
+			t.Fatalf("seeded=%v: %v", seeded, err)
+		}
+		r.Close()
+	}
+}
@@ -0,0 +1,305 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"chin/internal/utils"
+)
+
+// PartInfo describes one part of a split archive in the part manifest.
+// Size counts the bytes of the part before the metadata, which is the whole
+// part except for the last one. Checksum is the BLAKE3 of the data bytes
+// among them: the header and key slot area are left out, since they are
+// rewritten when the archive is finalized or its credentials change.
+type PartInfo struct {
+	Size     uint64
+	Checksum [32]byte
+}
+
+var (
+	ErrPartMissing   = errors.New("part is missing")
+	ErrPartTruncated = errors.New("part is truncated")
+	ErrPartSize      = errors.New("part has the wrong size")
+	ErrPartCorrupted = errors.New("part is corrupted")
+	ErrPartExtra     = errors.New("part does not belong to the archive")
+	ErrPartForeign   = errors.New("file belongs to another archive")
+	ErrNoManifest    = errors.New("archive has no part manifest")
+)
+
+// PartStatus is the state of one part of a split archive. Problem is nil for a good part.
+type PartStatus struct {
+	Index   int
+	Name    string
+	Problem error
+}
+
+// PartsError lists the damaged parts of a split archive.
+type PartsError struct {
+	Parts []PartStatus
+}
+
+func (e *PartsError) Error() string {
+	problems := make([]string, len(e.Parts))
+	for i, part := range e.Parts {
+		problems[i] = fmt.Sprintf("%s: %v", part.Name, part.Problem)
+	}
+	return "damaged split archive: " + strings.Join(problems, "; ")
+}
+
+// Unwrap lets errors.Is match the problem of each part.
+func (e *PartsError) Unwrap() []error {
+	errs := make([]error, len(e.Parts))
+	for i, part := range e.Parts {
+		errs[i] = part.Problem
+	}
+	return errs
+}
+
+// partHasher hashes the data of a split archive per part, as it is written.
+// It sees the data after the split writer, so the parts holding it exist.
+type partHasher struct {
+	split *SplitWriter
+	pos   int64 // Virtual offset of the next byte
+	sums  []hash.Hash
+}
+
+func newPartHasher(split *SplitWriter, start int64) *partHasher {
+	return &partHasher{split: split, pos: start}
+}
+
+func (h *partHasher) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		part, end := h.split.partAt(h.pos)
+		for len(h.sums) <= part {
+			h.sums = append(h.sums, utils.NewBlake3())
+		}
+		m := min(int64(len(p)), end-h.pos)
+		h.sums[part].Write(p[:m])
+		h.pos += m
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// manifest returns the part manifest of an archive whose metadata starts at
+// metadataOffset, the first byte after the hashed data. It covers the parts
+// up to the one where the metadata starts.
+func (h *partHasher) manifest(metadataOffset int64) []PartInfo {
+	last, _ := h.split.partAt(metadataOffset)
+	starts := h.split.starts
+	parts := make([]PartInfo, last+1)
+	for i := range parts {
+		start, end := metadataOffset, metadataOffset
+		if i < len(starts) {
+			start = starts[i]
+		}
+		if i+1 < len(starts) {
+			end = min(starts[i+1], metadataOffset)
+		}
+		parts[i].Size = uint64(end - start)
+		sum := utils.NewBlake3()
+		if i < len(h.sums) {
+			sum = h.sums[i]
+		}
+		copy(parts[i].Checksum[:], sum.Sum(nil))
+	}
+	return parts
+}
+
+// Metadata sections follow the file entries: [Tag 4][Length 4][Data].
+// Readers skip tags they do not know.
+const sectionParts = "PART"
+
+// serializeParts encodes the part manifest: per part [Size 8][Checksum 32].
+func serializeParts(parts []PartInfo) []byte {
+	buf := new(bytes.Buffer)
+	for _, part := range parts {
+		binary.Write(buf, binary.BigEndian, part.Size)
+		buf.Write(part.Checksum[:])
+	}
+	return buf.Bytes()
+}
+
+func deserializeParts(data []byte) ([]PartInfo, error) {
+	if len(data)%40 != 0 {
+		return nil, fmt.Errorf("invalid part manifest size %d", len(data))
+	}
+	parts := make([]PartInfo, len(data)/40)
+	for i := range parts {
+		entry := data[i*40:]
+		parts[i].Size = binary.BigEndian.Uint64(entry[:8])
+		copy(parts[i].Checksum[:], entry[8:40])
+	}
+	return parts, nil
+}
+
+// checkParts compares the parts found on disk with the manifest, by size only.
+func (r *Reader) checkParts() error {
+	split, ok := r.file.(*SplitReader)
+	if !ok {
+		return nil
+	}
+
+	problems := split.problems()
+	if manifest := r.metadata.Parts; len(manifest) > 0 && r.manifestFits(split) {
+		problems = nil
+		for i := range max(len(manifest), len(split.parts)) {
+			if status := r.partSizeStatus(split, i); status.Problem != nil {
+				problems = append(problems, status)
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return &PartsError{Parts: problems}
+	}
+	return nil
+}
+
+// partSizeStatus checks part i against its manifest entry. Parts after the
+// manifest only hold the end of the metadata; before v9 the count of parts is
+// not known, and they may as well be stray files.
+func (r *Reader) partSizeStatus(split *SplitReader, i int) PartStatus {
+	manifest := r.metadata.Parts
+	switch {
+	case i >= len(manifest) && split.headerSize == 0:
+		return split.status(i, ErrPartExtra)
+	case i >= len(split.parts) || split.parts[i] == nil:
+		return split.missing(i)
+	case i >= len(manifest):
+		return split.status(i, nil)
+	}
+
+	size, want := uint64(split.sizes[i]), manifest[i].Size
+	last := i == len(manifest)-1
+	switch {
+	case size < want:
+		return split.status(i, ErrPartTruncated)
+	case size > want && !last:
+		return split.status(i, ErrPartSize)
+	}
+	return split.status(i, nil)
+}
+
+// VerifyParts checks every part of a split archive on its own against the
+// part manifest: whether it exists, its size and the checksum of its data.
+// Damaged parts do not stop the others from being checked, as long as the
+// metadata can be read. The archive is opened with creds, like NewReaderWithCredentials.
+func VerifyParts(filename string, creds Credentials) ([]PartStatus, error) {
+	r, err := openReader(filename, creds)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	if volume, ok := r.file.(*volumeFile); ok {
+		// A later independent volume is checked against its own index
+		status := PartStatus{Index: int(volume.volume.Index), Name: filename}
+		err := verifyDataChecksum(r.file, int64(r.dataStart), &r.header)
+		if err == ErrChecksumMismatch {
+			status.Problem = ErrPartCorrupted
+		} else if err != nil {
+			return nil, err
+		}
+		return []PartStatus{status}, nil
+	}
+
+	split, ok := r.file.(*SplitReader)
+	manifest := r.metadata.Parts
+	if !ok || len(manifest) == 0 {
+		return nil, ErrNoManifest
+	}
+
+	if !r.manifestFits(split) {
+		return r.verifyRecutParts(split)
+	}
+
+	var statuses []PartStatus
+	var start int64
+	for i := range max(len(manifest), len(split.parts)) {
+		status := r.partSizeStatus(split, i)
+		if status.Problem == nil && i < len(manifest) {
+			end := start + int64(manifest[i].Size)
+			dataStart := max(start, int64(r.dataStart))
+			hasher := utils.NewBlake3()
+			if dataStart < end {
+				section := io.NewSectionReader(split.parts[i], split.headerSize+dataStart-start, end-dataStart)
+				if _, err := io.CopyBuffer(hasher, section, make([]byte, 64*1024)); err != nil {
+					return nil, err
+				}
+			}
+			if !bytes.Equal(hasher.Sum(nil), manifest[i].Checksum[:]) {
+				status.Problem = ErrPartCorrupted
+			}
+		}
+		if i < len(manifest) {
+			start += int64(manifest[i].Size)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// manifestFits reports whether the part manifest describes the parts on disk.
+// After Recut it still describes the parts the archive was packed in, which
+// only tell what data each checksum covers.
+func (r *Reader) manifestFits(split *SplitReader) bool {
+	manifest := r.metadata.Parts
+	if len(manifest) == 0 || split.independent || split.partSize <= 0 {
+		return true
+	}
+	for _, part := range manifest[:len(manifest)-1] {
+		if part.Size != uint64(split.partSize) {
+			return false
+		}
+	}
+	return int64(len(manifest)) == int64(r.header.MetadataOffset)/split.partSize+1
+}
+
+// verifyRecutParts checks the data covered by each entry of a manifest that
+// does not describe the parts on disk, and reports the parts holding the data
+// that does not match.
+func (r *Reader) verifyRecutParts(split *SplitReader) ([]PartStatus, error) {
+	statuses := make([]PartStatus, len(split.parts))
+	for i := range statuses {
+		statuses[i] = split.status(i, nil)
+	}
+	for _, problem := range split.problems() {
+		statuses[problem.Index] = problem
+	}
+
+	buf := make([]byte, 64*1024)
+	var start int64
+	for _, part := range r.metadata.Parts {
+		from, end := max(start, int64(r.dataStart)), start+int64(part.Size)
+		start = end
+		if from >= end {
+			continue
+		}
+		first, _, _ := split.locate(from)
+		last, _, _ := split.locate(end - 1)
+		readable := true
+		for i := first; i <= last; i++ {
+			readable = readable && statuses[i].Problem == nil
+		}
+		if !readable {
+			continue
+		}
+		hasher := utils.NewBlake3()
+		if _, err := io.CopyBuffer(hasher, io.NewSectionReader(split, from, end-from), buf); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(hasher.Sum(nil), part.Checksum[:]) {
+			for i := first; i <= last; i++ {
+				statuses[i].Problem = ErrPartCorrupted
+			}
+		}
+	}
+	return statuses, nil
+}
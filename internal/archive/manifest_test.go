@@ -0,0 +1,92 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestPartManifest damages the parts of a split archive and checks that each problem is pinned to its part
+func TestPartManifest(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "data.bin")
+	data := bytes.Repeat([]byte("0123456789abcdef"), 12_000) // ~190KB, 4 parts
+	if err := os.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath := filepath.Join(tmpDir, "out.chin")
+	w, err := NewWriter(archivePath, nil, 50_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddFile(src, "data.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := VerifyParts(archivePath, Credentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 4 {
+		t.Fatalf("expected 4 parts, got %d", len(statuses))
+	}
+	for _, status := range statuses {
+		if status.Problem != nil {
+			t.Fatalf("%s: %v", status.Name, status.Problem)
+		}
+	}
+
+	// Corrupt c01: opening still works (sizes match), VerifyParts names it
+	part1 := partName(archivePath, 1)
+	original, _ := os.ReadFile(part1)
+	corrupted := append([]byte{}, original...)
+	corrupted[100] ^= 0xff
+	os.WriteFile(part1, corrupted, 0644)
+
+	statuses, err = VerifyParts(archivePath, Credentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, status := range statuses {
+		var want error
+		if i == 1 {
+			want = ErrPartCorrupted
+		}
+		if status.Problem != want {
+			t.Fatalf("%s: expected %v, got %v", status.Name, want, status.Problem)
+		}
+	}
+
+	// Truncate c01
+	os.WriteFile(part1, original[:1000], 0644)
+	if _, err := NewReader(archivePath, nil); !errors.Is(err, ErrPartTruncated) {
+		t.Fatalf("expected ErrPartTruncated, got %v", err)
+	}
+
+	// Lose c02: the metadata in c03 is still readable, so VerifyParts checks the rest
+	os.WriteFile(part1, original, 0644)
+	os.Remove(partName(archivePath, 2))
+	var partsErr *PartsError
+	if _, err := NewReader(archivePath, nil); !errors.As(err, &partsErr) || len(partsErr.Parts) != 1 || partsErr.Parts[0].Index != 2 || partsErr.Parts[0].Problem != ErrPartMissing {
+		t.Fatalf("expected c02 missing, got %v", err)
+	}
+	statuses, err = VerifyParts(archivePath, Credentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses[2].Problem != ErrPartMissing || statuses[1].Problem != nil || statuses[3].Problem != nil {
+		t.Fatalf("unexpected statuses %v", statuses)
+	}
+
+	// Lose the last part, which holds the metadata
+	os.Remove(partName(archivePath, 3))
+	if _, err := NewReader(archivePath, nil); !errors.Is(err, ErrPartMissing) {
+		t.Fatalf("expected ErrPartMissing, got %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package archive
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Selector decides which entries of an archive are extracted.
+// An empty Selector matches everything.
+type Selector struct {
+	Paths   []string // Exact entry paths; a directory path selects its whole subtree
+	Include []string // Glob patterns, an entry must match at least one (if any)
+	Exclude []string // Glob patterns, matching entries and everything below them are skipped
+}
+
+// IsEmpty reports whether the selector has no rules at all.
+func (s *Selector) IsEmpty() bool {
+	return s == nil || (len(s.Paths) == 0 && len(s.Include) == 0 && len(s.Exclude) == 0)
+}
+
+// Match reports whether an entry name is selected.
+func (s *Selector) Match(name string) bool {
+	if s.IsEmpty() {
+		return true
+	}
+	name = normalizeName(name)
+
+	if len(s.Paths) > 0 {
+		found := false
+		for _, p := range s.Paths {
+			if underPath(name, p) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(s.Include) > 0 && !matchAny(s.Include, name) {
+		return false
+	}
+
+	// An excluded directory takes its whole subtree with it
+	for dir := name; dir != "." && dir != ""; dir = path.Dir(dir) {
+		if matchAny(s.Exclude, dir) {
+			return false
+		}
+	}
+	return true
+}
+
+// underPath reports whether name is the entry path p or lies below it.
+func underPath(name, p string) bool {
+	p = strings.TrimSuffix(normalizeName(p), "/")
+	return name == p || strings.HasPrefix(name, p+"/")
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if MatchGlob(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchGlob matches a slash separated name against a glob pattern.
+// Besides the path.Match syntax, "**" matches any number of path segments.
+// A pattern without a slash is matched against the base name only.
+func MatchGlob(pattern, name string) bool {
+	pattern = strings.TrimSuffix(normalizeName(pattern), "/")
+	name = normalizeName(name)
+
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Collapse consecutive "**"
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		ok, err := path.Match(pattern[0], name[0])
+		if err != nil || !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return len(name) == 0
+}
+
+// normalizeName converts an entry name to the slash separated form used for matching.
+func normalizeName(name string) string {
+	name = filepath.ToSlash(name)
+	name = strings.ReplaceAll(name, "\\", "/")
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
@@ -0,0 +1,77 @@
+package archive
+
+import "testing"
+
+// TestMatchGlob covers plain globs, base name matching and "**"
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*.txt", "docs/readme.txt", true},
+		{"*.txt", "docs/readme.md", false},
+		{"docs/*.txt", "docs/readme.txt", true},
+		{"docs/*.txt", "docs/sub/readme.txt", false},
+		{"docs/**/*.txt", "docs/sub/deep/readme.txt", true},
+		{"docs/**/*.txt", "docs/readme.txt", true},
+		{"**/config.yml", "app/etc/config.yml", true},
+		{"docs/**", "docs/a/b", true},
+		{"docs/**", "other/a", false},
+	}
+
+	for _, c := range cases {
+		if got := MatchGlob(c.pattern, c.name); got != c.want {
+			t.Errorf("MatchGlob(%q, %q) = %v, want %v", c.pattern, c.name, got, c.want)
+		}
+	}
+}
+
+// TestSelectorParents ensures parents of a selected file are kept
+func TestSelectorParents(t *testing.T) {
+	r := &Reader{metadata: Metadata{Files: []FileEntry{
+		{Name: "root", IsDir: true},
+		{Name: "root/etc", IsDir: true},
+		{Name: "root/etc/app.conf"},
+		{Name: "root/data.bin"},
+	}}}
+
+	got := r.Select(&Selector{Paths: []string{"root/etc/app.conf"}})
+	if len(got) != 3 {
+		t.Fatalf("expected 3 entries, got %d: %v", len(got), got)
+	}
+	for _, e := range got {
+		if e.Name == "root/data.bin" {
+			t.Fatal("unselected file was returned")
+		}
+	}
+}
+
+// TestSelectorExcludeDir skips everything below an excluded directory
+func TestSelectorExcludeDir(t *testing.T) {
+	sel := &Selector{Exclude: []string{"cache"}}
+	for name, want := range map[string]bool{
+		"app/cache":            false,
+		"app/cache/a.bin":      false,
+		"app/cache/deep/b.bin": false,
+		"app/cached.txt":       true,
+		"app/src/main.go":      true,
+	} {
+		if got := sel.Match(name); got != want {
+			t.Errorf("Match(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// TestSelectorUnmatched reports paths that name no entry
+func TestSelectorUnmatched(t *testing.T) {
+	r := &Reader{metadata: Metadata{Files: []FileEntry{
+		{Name: "root", IsDir: true},
+		{Name: "root/etc/app.conf"},
+	}}}
+
+	got := r.Unmatched(&Selector{Paths: []string{"root/etc", "root/etc/app.conf", "root/missing.txt", "roo"}})
+	if len(got) != 2 || got[0] != "root/missing.txt" || got[1] != "roo" {
+		t.Fatalf("unexpected unmatched paths: %v", got)
+	}
+}
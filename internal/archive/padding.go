@@ -0,0 +1,101 @@
+package archive
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// SetHideMetadata hides the file count and pads the metadata and every file
+// stream to a Padmé length, so sizes leak at most ~12% precision. It only
+// applies to encrypted archives and must be called before any file is added.
+func (w *Writer) SetHideMetadata(hide bool) error {
+	if w.started {
+		return errors.New("hide metadata must be set before files are added")
+	}
+	w.hideMetadata = hide
+	return nil
+}
+
+func (w *Writer) hidden() bool {
+	return w.hideMetadata && w.encrypted()
+}
+
+// padme returns the Padmé padded length of n (Nikitin et al., PETS 2019):
+// the low bits are rounded up so that at most O(log log n) bits remain.
+func padme(n uint64) uint64 {
+	if n < 2 {
+		return n
+	}
+	e := uint(bits.Len64(n) - 1) // floor(log2 n)
+	s := uint(bits.Len(e))       // floor(log2 e) + 1
+	lastBits := e - s
+	mask := uint64(1)<<lastBits - 1
+	return (n + mask) &^ mask
+}
+
+// zeroReader yields n zero bytes.
+type zeroReader struct {
+	n uint64
+}
+
+func (z *zeroReader) Read(p []byte) (int, error) {
+	if z.n == 0 {
+		return 0, io.EOF
+	}
+	if uint64(len(p)) > z.n {
+		p = p[:z.n]
+	}
+	for i := range p {
+		p[i] = 0
+	}
+	z.n -= uint64(len(p))
+	return len(p), nil
+}
+
+// paddedSource appends zeros to src, a file of size bytes, up to the Padmé length
+// of size. size is the one the stored size was planned with (see storedSize).
+func paddedSource(size uint64, src io.Reader) io.Reader {
+	return io.MultiReader(src, &zeroReader{n: padme(size) - size})
+}
+
+// padMetadata prefixes the metadata with its length and pads it with zeros:
+// [Length 4][Metadata][Padding]
+func padMetadata(metadata []byte) []byte {
+	padded := make([]byte, padme(uint64(len(metadata)+4)))
+	binary.BigEndian.PutUint32(padded[:4], uint32(len(metadata)))
+	copy(padded[4:], metadata)
+	return padded
+}
+
+func unpadMetadata(padded []byte) ([]byte, error) {
+	if len(padded) < 4 {
+		return nil, ErrInvalidFormat
+	}
+	n := binary.BigEndian.Uint32(padded[:4])
+	if uint64(n) > uint64(len(padded)-4) {
+		return nil, ErrInvalidFormat
+	}
+	return padded[4 : 4+n], nil
+}
+
+// truncatingWriter passes the first n bytes to w and drops the padding after them.
+type truncatingWriter struct {
+	w io.Writer
+	n uint64
+}
+
+func (t *truncatingWriter) Write(p []byte) (int, error) {
+	keep := p
+	if uint64(len(keep)) > t.n {
+		keep = keep[:t.n]
+	}
+	if len(keep) > 0 {
+		if _, err := t.w.Write(keep); err != nil {
+			return 0, err
+		}
+		t.n -= uint64(len(keep))
+	}
+	return len(p), nil
+}
@@ -0,0 +1,105 @@
+package archive
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"chin/internal/crypto"
+)
+
+func TestPadme(t *testing.T) {
+	cases := map[uint64]uint64{0: 0, 1: 1, 2: 2, 9: 10, 100: 104, 1000: 1024, 1 << 20: 1 << 20, 1<<20 + 1: 1<<20 + 1<<15}
+	for n, want := range cases {
+		if got := padme(n); got != want {
+			t.Errorf("padme(%d) = %d, want %d", n, got, want)
+		}
+	}
+}
+
+// TestHiddenMetadata packs files of close sizes: the archive hides the count and
+// sizes but still extracts the exact content
+func TestHiddenMetadata(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcDir := filepath.Join(tmpDir, "src")
+	os.MkdirAll(srcDir, 0755)
+	os.WriteFile(filepath.Join(srcDir, "a.bin"), bytes.Repeat([]byte{1}, 1000), 0644)
+	os.WriteFile(filepath.Join(srcDir, "b.bin"), bytes.Repeat([]byte{2}, 1001), 0644)
+
+	pack := func(name string, hide bool) string {
+		archivePath := filepath.Join(tmpDir, name)
+		w, err := NewWriter(archivePath, []byte("pw"), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.SetKDF(crypto.KDFParams{Algorithm: crypto.KDFPBKDF2, Iterations: 1000})
+		if err := w.SetHideMetadata(hide); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.AddFile(srcDir, "src"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Finalize(); err != nil {
+			t.Fatal(err)
+		}
+		return archivePath
+	}
+	archivePath := pack("hidden.chin", true)
+
+	header, err := ReadHeader(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.FileCount != 0 || header.Flags&FlagHidden == 0 {
+		t.Fatalf("file count not hidden: %d", header.FileCount)
+	}
+
+	r, err := NewReader(archivePath, []byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if len(r.ListFiles()) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(r.ListFiles()))
+	}
+	// Both files were padded to the same length
+	files := r.ListFiles()
+	if files[2].Offset-files[1].Offset != uint64(r.header.MetadataOffset)-files[2].Offset {
+		t.Fatal("padded streams differ in length")
+	}
+
+	if err := r.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(tmpDir, "dest")
+	if err := r.ExtractAll(dest, true); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(filepath.Join(dest, "src", "b.bin"))
+	if !bytes.Equal(got, bytes.Repeat([]byte{2}, 1001)) {
+		t.Fatal("content mismatch")
+	}
+
+	// Without hiding, the count is stored and the streams keep their own length
+	plainPath := pack("plain.chin", false)
+	header, err = ReadHeader(plainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.FileCount == 0 || header.Flags&FlagHidden != 0 {
+		t.Fatalf("file count hidden: %d", header.FileCount)
+	}
+	plain, err := NewReader(plainPath, []byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	files = plain.ListFiles()
+	if files[2].Offset-files[1].Offset == uint64(plain.header.MetadataOffset)-files[2].Offset {
+		t.Fatal("streams padded without hiding")
+	}
+	if err := plain.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
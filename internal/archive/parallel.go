@@ -0,0 +1,259 @@
+package archive
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"chin/internal/utils"
+)
+
+// packBufferChunks bounds the encoded data a worker may hold before the
+// ordered writer drains it (packBufferChunks * 64KB per worker).
+const packBufferChunks = 16
+
+var errPackAborted = errors.New("pack aborted")
+
+// packJob is one walked entry travelling through the parallel pipeline.
+type packJob struct {
+	path   string
+	name   string
+	info   os.FileInfo
+	offset uint64      // Where the stream starts, precomputed from the stored sizes
+	chunks chan []byte // Encoded stream, closed when the worker is done
+	result chan packResult
+}
+
+type packResult struct {
+	checksum uint64
+	err      error
+}
+
+// chunkWriter hands encoded bytes to the ordered writer.
+type chunkWriter struct {
+	chunks chan<- []byte
+	done   <-chan struct{}
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	chunk := make([]byte, len(p))
+	copy(chunk, p)
+	select {
+	case c.chunks <- chunk:
+		return len(p), nil
+	case <-c.done:
+		return 0, errPackAborted
+	}
+}
+
+// addParallel packs path with w.Jobs workers. Files are read, hashed and
+// encrypted concurrently, while a single writer appends them in walk order,
+// so the archive layout is the same as with one job.
+func (w *Writer) addParallel(path, nameInArchive string) error {
+	jobs := make(chan *packJob)            // Walk -> workers
+	ordered := make(chan *packJob, w.Jobs) // Walk -> writer, in walk order
+	done := make(chan struct{})
+	walkErr := make(chan error, 1)
+	// The walker and workers still use the file and keys until they return
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	// Stored sizes only depend on the file size, so the walker can tell each
+	// worker where its stream will start
+	offset := w.dataOffset
+	wg.Add(1 + w.Jobs)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		defer close(ordered)
+		walkErr <- Walk(path, nameInArchive, w.Filter, func(path, name string, info os.FileInfo) error {
+			if w.skipResumed(name, info) {
+				return nil
+			}
+			job := &packJob{path: path, name: name, info: info, offset: offset}
+			if !info.IsDir() {
+				offset += uint64(w.storedSize(info.Size()))
+				job.chunks = make(chan []byte, packBufferChunks)
+				job.result = make(chan packResult, 1)
+			}
+			// Queue for the writer first, so at most w.Jobs files are in flight
+			select {
+			case ordered <- job:
+			case <-done:
+				return errPackAborted
+			}
+			if job.info.IsDir() {
+				return nil
+			}
+			select {
+			case jobs <- job:
+			case <-done:
+				return errPackAborted
+			}
+			return nil
+		})
+	}()
+
+	for i := 0; i < w.Jobs; i++ {
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				w.encodeJob(job, done)
+			}
+		}()
+	}
+
+	err := w.writeOrdered(ordered)
+	close(done)
+	if err != nil {
+		// Drain so the walker and workers can exit
+		for range ordered {
+		}
+		return err
+	}
+	if err := <-walkErr; err != nil {
+		return err
+	}
+	return nil
+}
+
+// encodeJob runs in a worker goroutine.
+func (w *Writer) encodeJob(job *packJob, done <-chan struct{}) {
+	defer close(job.chunks)
+
+	file, err := os.Open(job.path)
+	if err != nil {
+		job.result <- packResult{err: err}
+		return
+	}
+	defer file.Close()
+
+	out := bufio.NewWriterSize(&chunkWriter{chunks: job.chunks, done: done}, 64*1024)
+	checksum, err := w.encodeFile(file, job.name, job.info.Size(), job.offset, out)
+	if err == nil {
+		err = out.Flush()
+	}
+	job.result <- packResult{checksum: checksum, err: err}
+}
+
+// writeOrdered appends finished entries to the archive in walk order.
+func (w *Writer) writeOrdered(ordered <-chan *packJob) error {
+	for job := range ordered {
+		if job.info.IsDir() {
+			if err := w.addDirectory(job.path, job.name, job.info); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if w.OnFileStart != nil {
+			w.OnFileStart(job.name)
+		}
+
+		if err := w.placeEntry(job.name, w.storedSize(job.info.Size())); err != nil {
+			return err
+		}
+		offset := w.dataOffset
+		if offset != job.offset {
+			// A file before it did not have the size it was walked with
+			return fmt.Errorf("%s: data offset %d, expected %d", job.name, offset, job.offset)
+		}
+		countingWriter := &utils.CountingWriter{Writer: w.file, Callback: w.OnProgress}
+		out := io.MultiWriter(countingWriter, w.dataSink())
+		for chunk := range job.chunks {
+			if _, err := out.Write(chunk); err != nil {
+				return err
+			}
+		}
+
+		res := <-job.result
+		if res.err != nil {
+			return res.err
+		}
+		if err := w.recordFile(job.path, job.name, job.info, offset, countingWriter.Count, res.checksum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractParallel creates directories first, then extracts files with r.Jobs
+// workers. Each worker reads its entry through its own section of r.file.
+func (r *Reader) extractParallel(entries []FileEntry, outputPath string, verify bool) error {
+	for _, entry := range entries {
+		if entry.IsDir {
+			if err := r.ExtractFile(entry, outputPath, verify); err != nil {
+				return err
+			}
+		}
+	}
+
+	work := make(chan FileEntry)
+	errs := make(chan error, r.Jobs)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := 0; i < r.Jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range work {
+				if err := r.ExtractFile(entry, outputPath, verify); err != nil {
+					errs <- fmt.Errorf("%s: %w", entry.Name, err)
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	var firstErr error
+dispatch:
+	for _, entry := range entries {
+		if entry.IsDir {
+			continue
+		}
+		select {
+		case work <- entry:
+		case firstErr = <-errs:
+			break dispatch
+		}
+	}
+	close(work)
+	<-done
+
+	if firstErr == nil {
+		select {
+		case firstErr = <-errs:
+		default:
+		}
+	}
+	return firstErr
+}
+
+// progress forwards to OnProgress; it is safe to call from several goroutines.
+func (r *Reader) progress(n int) {
+	if r.OnProgress == nil {
+		return
+	}
+	r.callbackMu.Lock()
+	defer r.callbackMu.Unlock()
+	r.OnProgress(n)
+}
+
+// fileStart forwards to OnFileStart; it is safe to call from several goroutines.
+func (r *Reader) fileStart(name string) {
+	if r.OnFileStart == nil {
+		return
+	}
+	r.callbackMu.Lock()
+	defer r.callbackMu.Unlock()
+	r.OnFileStart(name)
+}
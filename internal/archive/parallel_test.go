@@ -0,0 +1,110 @@
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestParallelPackMatchesSequential checks that --jobs does not change the archive layout
+func TestParallelPackMatchesSequential(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	for i := 0; i < 20; i++ {
+		dir := filepath.Join(src, fmt.Sprintf("d%d", i%3))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		data := bytes.Repeat([]byte{byte(i)}, i*40_000)
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%02d", i)), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pack := func(out string, jobs int, password string) []byte {
+		w, err := NewWriter(out, []byte(password), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Jobs = jobs
+		w.SetReproducible(ReproducibleOptions{SourceDate: time.Unix(1700000000, 0), SaltSeed: []byte("seed")})
+		if err := w.AddFile(src, "src"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Finalize(); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	for _, password := range []string{"", "password"} {
+		sequential := pack(filepath.Join(tmpDir, "seq.chin"), 1, password)
+		parallel := pack(filepath.Join(tmpDir, "par.chin"), 4, password)
+		if !bytes.Equal(sequential, parallel) {
+			t.Fatalf("parallel archive differs from sequential (password %q)", password)
+		}
+	}
+}
+
+// TestParallelExtractSplit extracts an encrypted split archive with several workers
+func TestParallelExtractSplit(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		data := bytes.Repeat([]byte{byte('a' + i)}, 30_000+i*7_000)
+		if err := os.WriteFile(filepath.Join(src, fmt.Sprintf("f%d", i)), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archivePath := filepath.Join(tmpDir, "out.chin")
+	w, err := NewWriter(archivePath, []byte("password"), 50_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddFile(src, "src"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(archivePath, []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.Jobs = 4
+
+	var progress int
+	r.OnProgress = func(n int) { progress += n }
+
+	dest := filepath.Join(tmpDir, "dest")
+	if err := r.ExtractAll(dest, true); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 8; i++ {
+		want, _ := os.ReadFile(filepath.Join(src, fmt.Sprintf("f%d", i)))
+		got, err := os.ReadFile(filepath.Join(dest, "src", fmt.Sprintf("f%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(want, got) {
+			t.Fatalf("f%d differs after parallel extraction", i)
+		}
+	}
+	if progress == 0 {
+		t.Fatal("progress callback was not called")
+	}
+}
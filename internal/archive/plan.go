@@ -0,0 +1,230 @@
+package archive
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// PackPlan is what a writer from NewDryRunWriter would have packed.
+type PackPlan struct {
+	Entries     []FileEntry // As they would be stored, without offsets and checksums
+	Files       int
+	Dirs        int
+	Size        int64 // Original size of the files
+	ArchiveSize int64 // Expected size of the archive, all parts together
+	Parts       int   // Expected number of parts, 1 unless split
+}
+
+// NewDryRunWriter returns a writer that walks and filters its inputs like one
+// from NewWriter, with the same options, but reads no file contents and writes
+// nothing. Plan then reports what the archive would hold.
+func NewDryRunWriter(password []byte, splitSize int64) (*Writer, error) {
+	var file SplitFile = &dryFile{}
+	if splitSize > 0 {
+		split, err := newDrySplitWriter(splitSize)
+		if err != nil {
+			return nil, err
+		}
+		file = split
+	}
+
+	w, err := newWriter(file, password)
+	if err != nil {
+		return nil, err
+	}
+	w.dryRun = true
+	// Placeholder header
+	if err := writeZeros(file, HeaderSize); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// planFile records a file as addSingleFile would, counting its stream instead of writing it.
+func (w *Writer) planFile(path, name string, info os.FileInfo) error {
+	stored := w.storedSize(info.Size())
+	if err := w.placeEntry(name, stored); err != nil {
+		return err
+	}
+	offset := w.dataOffset
+	if err := writeZeros(w.file, stored); err != nil {
+		return err
+	}
+	return w.recordFile(path, name, info, offset, uint64(stored), 0)
+}
+
+// Plan reports the entries added to a dry run writer and the expected size of
+// the archive. The size of the key slot area is taken as KeySlotAreaSize, since
+// the password slot is only known once the KDF has run.
+func (w *Writer) Plan() (*PackPlan, error) {
+	if !w.dryRun {
+		return nil, errors.New("plan needs a writer from NewDryRunWriter")
+	}
+	if err := w.begin(); err != nil {
+		return nil, err
+	}
+
+	if w.parts != nil {
+		w.metadata.Parts = w.parts.manifest(int64(w.dataOffset))
+	}
+	metadataBytes, err := w.metadata.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	// As written by Finalize
+	metadataSize := int64(len(metadataBytes))
+	if w.hidden() {
+		metadataSize = int64(padme(uint64(metadataSize + 4)))
+	}
+	if w.encrypted() {
+		metadataSize += int64(w.cipher.NonceSize() + w.cipher.Overhead())
+	}
+	if w.signingKey != nil {
+		metadataSize += SignatureSize
+	}
+	if err := writeZeros(w.file, metadataSize); err != nil {
+		return nil, err
+	}
+
+	plan := &PackPlan{
+		Entries:     w.metadata.Files,
+		ArchiveSize: int64(w.dataOffset) + metadataSize,
+		Parts:       1,
+	}
+	for _, entry := range plan.Entries {
+		if entry.IsDir {
+			plan.Dirs++
+		} else {
+			plan.Files++
+			plan.Size += int64(entry.Size)
+		}
+	}
+	if split, ok := w.file.(*SplitWriter); ok {
+		plan.Parts = len(split.starts)
+		plan.ArchiveSize += int64(plan.Parts) * VolumeHeaderSize
+		if w.volumes != nil {
+			// As written by appendIndexes
+			for i := range split.starts {
+				end := split.getVirtualEnd()
+				if i < len(split.starts)-1 {
+					end = split.starts[i+1]
+				}
+				size := int64(emptyMetadataSize)
+				for _, entry := range w.volumeFiles(i, end) {
+					size += entrySize(entry.Name)
+				}
+				plan.ArchiveSize += w.volumeIndexSize(size)
+			}
+		}
+	}
+	return plan, nil
+}
+
+// writeZeros writes n bytes to a dry run file, which only counts them.
+func writeZeros(w io.Writer, n int64) error {
+	buf := make([]byte, min(n, 1024*1024))
+	for n > 0 {
+		written, err := w.Write(buf[:min(n, int64(len(buf)))])
+		if err != nil {
+			return err
+		}
+		n -= int64(written)
+	}
+	return nil
+}
+
+// dryFile is the file of a dry run writer without split: it keeps the size
+// and position of what would have been written.
+type dryFile struct {
+	size int64
+	pos  int64
+}
+
+func (f *dryFile) Write(p []byte) (int, error) {
+	f.pos += int64(len(p))
+	f.size = max(f.size, f.pos)
+	return len(p), nil
+}
+
+func (f *dryFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *dryFile) Read(p []byte) (int, error)              { return 0, io.EOF }
+func (f *dryFile) ReadAt(p []byte, off int64) (int, error) { return 0, io.EOF }
+func (f *dryFile) Truncate(size int64) error               { f.size = size; return nil }
+func (f *dryFile) Sync() error                             { return nil }
+func (f *dryFile) Close() error                            { return nil }
+
+// ExtractStep is what ExtractEntries would do with one entry, see PlanExtract.
+type ExtractStep struct {
+	Entry     FileEntry
+	Path      string         // Destination, the free name with ConflictRename
+	Exists    bool           // Something is already at the destination
+	Unchanged bool           // SkipExisting found the entry already extracted
+	Action    ConflictPolicy // What Conflict does with an existing file; Ask is not called
+	Err       error          // Why the entry would be rejected, such as a path outside outputPath
+}
+
+// PlanExtract reports what ExtractEntries would do with the file entries among
+// entries: the same destination paths, checks and SkipExisting and Conflict
+// decisions, without writing anything. Directories are only reported when rejected.
+func (r *Reader) PlanExtract(entries []FileEntry, outputPath string) []ExtractStep {
+	var steps []ExtractStep
+	r.conflictMu.Lock()
+	targets := r.entryTargets(outputPath)
+	r.conflictMu.Unlock()
+	renamed := make(map[string]bool) // Names picked for earlier entries
+	taken := func(path string) bool { return targets[path] || renamed[path] }
+	for _, entry := range entries {
+		step := ExtractStep{Entry: entry}
+		step.Path, step.Err = entryPath(entry, outputPath)
+		if step.Err == nil && entry.IsDir {
+			continue
+		}
+		if step.Err == nil {
+			r.planConflict(&step, taken)
+			if step.Action == ConflictRename {
+				renamed[step.Path] = true
+			}
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// planConflict fills in what would happen to an existing destination. A
+// renamed entry gets a name that is not taken.
+func (r *Reader) planConflict(step *ExtractStep, taken func(string) bool) {
+	info, err := os.Lstat(step.Path)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		step.Err = err
+		return
+	}
+	step.Exists = true
+
+	if r.SkipExisting {
+		step.Unchanged, step.Err = matchesEntry(step.Path, step.Entry)
+		if step.Unchanged || step.Err != nil {
+			step.Action = ConflictSkip
+			return
+		}
+	}
+	step.Action = r.Conflict
+	switch step.Action {
+	case ConflictKeepNewer:
+		step.Action = keepNewer(step.Entry, info)
+	case ConflictRename:
+		step.Path = freeName(step.Path, taken)
+	}
+}
@@ -0,0 +1,149 @@
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDryRunPack compares the plan of a dry run with the archive actually written
+func TestDryRunPack(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	os.MkdirAll(filepath.Join(src, "sub"), 0755)
+	for i := 0; i < 6; i++ {
+		os.WriteFile(filepath.Join(src, "sub", fmt.Sprintf("f%d.bin", i)), bytes.Repeat([]byte{byte(i)}, 7_000*(i+1)), 0644)
+	}
+	os.WriteFile(filepath.Join(src, "skip.log"), []byte("filtered"), 0644)
+
+	cases := []struct {
+		password    []byte
+		split       int64
+		independent bool
+		hide        bool
+	}{
+		{},
+		{split: 20_000},
+		{password: []byte("pw"), split: 30_000},
+		{password: []byte("pw"), split: 40_000, independent: true, hide: true},
+	}
+	for i, tc := range cases {
+		configure := func(w *Writer) {
+			w.Filter = &Filter{Exclude: []string{"*.log"}}
+			w.Jobs = 2
+			if err := w.SetHideMetadata(tc.hide); err != nil {
+				t.Fatal(err)
+			}
+			if tc.independent {
+				if err := w.SetIndependentVolumes(true); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		before, _ := os.ReadDir(tmpDir)
+		dry, err := NewDryRunWriter(tc.password, tc.split)
+		if err != nil {
+			t.Fatal(err)
+		}
+		configure(dry)
+		if err := dry.AddFile(src, "src"); err != nil {
+			t.Fatal(err)
+		}
+		plan, err := dry.Plan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dry.Close()
+		if after, _ := os.ReadDir(tmpDir); len(after) != len(before) {
+			t.Fatalf("case %d: the dry run wrote files", i)
+		}
+
+		archivePath := filepath.Join(tmpDir, fmt.Sprintf("out%d.chin", i))
+		w, err := NewWriter(archivePath, tc.password, tc.split)
+		if err != nil {
+			t.Fatal(err)
+		}
+		configure(w)
+		if err := w.AddFile(src, "src"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Finalize(); err != nil {
+			t.Fatal(err)
+		}
+
+		parts, _ := filepath.Glob(archivePath + "*")
+		var size int64
+		for _, part := range parts {
+			info, _ := os.Stat(part)
+			size += info.Size()
+		}
+		if plan.Files != 6 || plan.Dirs != 2 || plan.Size != 7_000*21 {
+			t.Fatalf("case %d: planned %d files, %d folders, %d bytes", i, plan.Files, plan.Dirs, plan.Size)
+		}
+		if plan.Parts != len(parts) {
+			t.Fatalf("case %d: planned %d parts, packed %d", i, plan.Parts, len(parts))
+		}
+		if plan.ArchiveSize != size {
+			t.Fatalf("case %d: planned %d bytes, packed %d", i, plan.ArchiveSize, size)
+		}
+	}
+}
+
+// TestPlanExtract checks the destinations and actions reported without extracting
+func TestPlanExtract(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	os.MkdirAll(src, 0755)
+	os.WriteFile(filepath.Join(src, "new.txt"), []byte("new"), 0644)
+	os.WriteFile(filepath.Join(src, "old.txt"), []byte("old"), 0644)
+
+	archivePath := filepath.Join(tmpDir, "out.chin")
+	w, err := NewWriter(archivePath, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddFile(src, "src"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(tmpDir, "out")
+	os.MkdirAll(filepath.Join(out, "src"), 0755)
+	os.WriteFile(filepath.Join(out, "src", "old.txt"), []byte("local"), 0644)
+
+	r, err := NewReader(archivePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.Conflict = ConflictRename
+	entries := append(r.ListFiles(), FileEntry{Name: "../escape.txt"})
+	steps := r.PlanExtract(entries, out)
+	if len(steps) != 3 {
+		t.Fatalf("got %d steps, want 3", len(steps))
+	}
+	for _, step := range steps {
+		switch step.Entry.Name {
+		case "src/new.txt":
+			if step.Exists || step.Err != nil || step.Path != filepath.Join(out, "src", "new.txt") {
+				t.Fatalf("new.txt: %+v", step)
+			}
+		case "src/old.txt":
+			if !step.Exists || step.Action != ConflictRename || step.Path != filepath.Join(out, "src", "old (1).txt") {
+				t.Fatalf("old.txt: %+v", step)
+			}
+		case "../escape.txt":
+			if step.Err == nil {
+				t.Fatal("a path outside the destination was accepted")
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, "src", "new.txt")); !os.IsNotExist(err) {
+		t.Fatal("the plan extracted files")
+	}
+}
@@ -0,0 +1,145 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"chin/internal/utils"
+)
+
+// Recut writes the archive at filename again as output, in parts of splitSize
+// bytes or as a single file if splitSize is 0. The archive is copied byte for
+// byte, so no credentials are needed and its signature still holds; its part
+// manifest keeps describing the parts it was packed in (see manifestFits). The
+// data is checked against its DataChecksum on the way. With signingKey, the
+// archive is signed again instead.
+//
+// Independent volumes are laid out around the files, so they can only be cut
+// again by copying the entries, see NewWriterFrom.
+func Recut(filename, output string, splitSize int64, signingKey ed25519.PrivateKey) error {
+	src, header, err := openArchive(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	switch f := src.(type) {
+	case *volumeFile:
+		return fmt.Errorf("only part %d of the archive could be read, all parts are needed to copy it", f.volume.Index)
+	case *SplitReader:
+		if f.independent {
+			return errors.New("an archive with independent volumes can only be cut again with its credentials")
+		}
+		if problems := f.problems(); len(problems) > 0 {
+			return &PartsError{Parts: problems}
+		}
+	}
+
+	size, err := src.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	dataStart := int64(header.Size())
+	if header.Flags&FlagKeySlots != 0 {
+		_, areaSize, err := readKeySlots(src, dataStart)
+		if err != nil {
+			return err
+		}
+		dataStart += int64(areaSize)
+	}
+	metadataOffset := int64(header.MetadataOffset)
+	if metadataOffset < dataStart || metadataOffset > size {
+		return ErrInvalidFormat
+	}
+
+	// A new signature replaces the old one and covers the signed header
+	end := size
+	var metadata []byte
+	if signingKey != nil {
+		if header.Version < 7 {
+			return fmt.Errorf("archives before v7 cannot be signed, repack v%d archives first", header.Version)
+		}
+		if header.Flags&FlagSigned != 0 {
+			end -= SignatureSize
+		}
+		if end < metadataOffset {
+			return fmt.Errorf("%w: signature block truncated", ErrBadSignature)
+		}
+		metadata = make([]byte, end-metadataOffset)
+		if _, err := src.ReadAt(metadata, metadataOffset); err != nil {
+			return err
+		}
+		header.Flags |= FlagSigned
+	}
+
+	temp, err := createTemp(output)
+	if err != nil {
+		return err
+	}
+	var out SplitFile = temp
+	if splitSize > 0 {
+		temp.Close()
+		split, err := NewSplitWriter(temp.Name(), splitSize)
+		if err != nil {
+			os.Remove(temp.Name())
+			return err
+		}
+		// The parts still belong to the same archive
+		if volume, err := ReadVolumeHeader(filename); err == nil {
+			split.SetArchiveID(volume.ArchiveID)
+		}
+		out = split
+	}
+	published := false
+	defer func() {
+		if !published {
+			out.Close()
+			removeParts(temp.Name())
+		}
+	}()
+
+	buf := make([]byte, 256*1024)
+	prefix := io.NewSectionReader(src, 0, dataStart)
+	if signingKey != nil {
+		if _, err := out.Write(header.Serialize()); err != nil {
+			return err
+		}
+		prefix = io.NewSectionReader(src, int64(header.Size()), dataStart-int64(header.Size()))
+	}
+	if _, err := io.CopyBuffer(out, prefix, buf); err != nil {
+		return err
+	}
+
+	hasher := utils.NewBlake3()
+	data := io.NewSectionReader(src, dataStart, metadataOffset-dataStart)
+	if _, err := io.CopyBuffer(io.MultiWriter(out, hasher), data, buf); err != nil {
+		return err
+	}
+	if !bytes.Equal(hasher.Sum(nil), header.DataChecksum[:]) {
+		return ErrChecksumMismatch
+	}
+
+	if _, err := io.CopyBuffer(out, io.NewSectionReader(src, metadataOffset, end-metadataOffset), buf); err != nil {
+		return err
+	}
+	if signingKey != nil {
+		if _, err := out.Write(signatureBlock(signingKey, header.Serialize(), metadata)); err != nil {
+			return err
+		}
+	}
+
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := publishParts(out, temp.Name(), output); err != nil {
+		return err
+	}
+	published = true
+	return nil
+}
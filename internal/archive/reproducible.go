@@ -0,0 +1,143 @@
+package archive
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"chin/internal/crypto"
+	"chin/internal/utils"
+)
+
+// ReproducibleOptions make the archive bytes depend only on the packed tree.
+type ReproducibleOptions struct {
+	// SourceDate is stored as CreatedAt and clamps newer mtimes (SOURCE_DATE_EPOCH).
+	// A zero value stores the Unix epoch and keeps mtimes unchanged.
+	SourceDate time.Time
+	// NormalizeModes stores 0644/0755 instead of the on-disk permissions.
+	NormalizeModes bool
+	// SaltSeed replaces random salts and nonces for encrypted archives.
+	// File salts also depend on the file content, so a seed never reuses a key for different data.
+	SaltSeed []byte
+}
+
+// SetReproducible switches the writer to reproducible mode. It must be called before any file is added.
+func (w *Writer) SetReproducible(opts ReproducibleOptions) {
+	w.reproducible = &opts
+
+	if opts.SourceDate.IsZero() {
+		w.metadata.CreatedAt = time.Unix(0, 0)
+	} else {
+		w.metadata.CreatedAt = opts.SourceDate
+	}
+
+	// Only used when a password or keyfile is set, derived unconditionally so
+	// keyfiles may still be added afterwards
+	if len(opts.SaltSeed) > 0 {
+		w.salt = opts.derive("master-salt")[:crypto.SaltSize]
+	}
+}
+
+// derive returns a 32-byte value bound to the seed, a label and the given parts.
+func (o *ReproducibleOptions) derive(label string, parts ...[]byte) []byte {
+	h := utils.NewBlake3()
+	writeField(h, o.SaltSeed)
+	writeField(h, []byte(label))
+	for _, p := range parts {
+		writeField(h, p)
+	}
+	return h.Sum(nil)
+}
+
+// writeField writes a length-prefixed field so concatenations stay unambiguous.
+func writeField(w io.Writer, p []byte) {
+	binary.Write(w, binary.BigEndian, uint64(len(p)))
+	w.Write(p)
+}
+
+// archiveID identifies the parts of a reproducible split archive. It is derived
+// from the data instead of being random, so it stays the same on every run.
+func (w *Writer) archiveID(dataChecksum []byte) [16]byte {
+	var id [16]byte
+	h := utils.NewBlake3()
+	writeField(h, []byte("chin-archive-id"))
+	writeField(h, dataChecksum)
+	copy(id[:], h.Sum(nil))
+	return id
+}
+
+// entryName returns the name as stored in the archive.
+func (w *Writer) entryName(name string) string {
+	if w.reproducible == nil {
+		return name
+	}
+	return filepath.ToSlash(name)
+}
+
+// entryMode returns the mode as stored in the archive.
+func (w *Writer) entryMode(mode os.FileMode) uint32 {
+	if w.reproducible == nil || !w.reproducible.NormalizeModes {
+		return uint32(mode)
+	}
+	if mode.IsDir() {
+		return uint32(os.ModeDir | 0755)
+	}
+	if mode&0111 != 0 {
+		return 0755
+	}
+	return 0644
+}
+
+// entryTime returns the modification time as stored in the archive.
+func (w *Writer) entryTime(t time.Time) time.Time {
+	if w.reproducible == nil {
+		return t
+	}
+	if !w.reproducible.SourceDate.IsZero() && t.After(w.reproducible.SourceDate) {
+		return w.reproducible.SourceDate
+	}
+	return t
+}
+
+// fileSalt returns the stream salt for a file, or nil to let SealStream pick a
+// random one, with the BLAKE3 digest of the contents the salt is bound to.
+//
+// The file is read twice: the salt leads the stream, so the contents must be
+// hashed before they are encrypted. encodeFile hashes them again while
+// encrypting and fails if they changed in between, so a salt is never used for
+// other contents than its own.
+func (w *Writer) fileSalt(file *os.File, name string) (salt, digest []byte, err error) {
+	if w.reproducible == nil || len(w.reproducible.SaltSeed) == 0 {
+		return nil, nil, nil
+	}
+
+	h := utils.NewBlake3()
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	digest = h.Sum(nil)
+	return w.reproducible.derive("file-salt", []byte(name), digest)[:crypto.SaltSize], digest, nil
+}
+
+// metadataNonce returns the nonce for the metadata, or nil for a random one.
+func (w *Writer) metadataNonce(metadata []byte) []byte {
+	if w.reproducible == nil || len(w.reproducible.SaltSeed) == 0 {
+		return nil
+	}
+	return w.reproducible.derive("metadata-nonce", utils.Blake3(metadata))[:w.cipher.NonceSize()]
+}
+
+// volumeNonce returns the nonce for the metadata of a volume index, or nil for a
+// random one. Indexes may list the same entries, so the nonce also depends on
+// their header, which differs for every part.
+func (w *Writer) volumeNonce(header, metadata []byte) []byte {
+	if w.reproducible == nil || len(w.reproducible.SaltSeed) == 0 {
+		return nil
+	}
+	return w.reproducible.derive("volume-nonce", header, utils.Blake3(metadata))[:w.cipher.NonceSize()]
+}
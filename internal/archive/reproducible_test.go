@@ -0,0 +1,64 @@
+package archive
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestReproducibleArchive packs the same tree twice and expects identical bytes
+func TestReproducibleArchive(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(src, "a.txt"), []byte("alpha"), 0644)
+	os.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("beta"), 0600)
+
+	pack := func(out, password string, split int64) []byte {
+		w, err := NewWriter(out, []byte(password), split)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.SetReproducible(ReproducibleOptions{
+			SourceDate:     time.Unix(1700000000, 0),
+			NormalizeModes: true,
+			SaltSeed:       []byte("seed"),
+		})
+		if err := w.AddFile(src, "src"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Finalize(); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if split > 0 {
+			// The archive ID in the volume headers must not be random either
+			last, err := os.ReadFile(partName(out, 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data = append(data, last...)
+		}
+		return data
+	}
+
+	for _, password := range []string{"", "password"} {
+		for _, split := range []int64{0, 400} {
+			first := pack(filepath.Join(tmpDir, "1.chin"), password, split)
+			// Times after SourceDate are clamped
+			now := time.Now()
+			os.Chtimes(filepath.Join(src, "a.txt"), now, now)
+			second := pack(filepath.Join(tmpDir, "2.chin"), password, split)
+			if !bytes.Equal(first, second) {
+				t.Fatalf("archives differ (password %q, split %d)", password, split)
+			}
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+
+	"chin/internal/crypto"
+	"chin/internal/utils"
+)
+
+// ErrDuplicateEntry is returned when a copied entry has the name of a file already in the archive.
+var ErrDuplicateEntry = errors.New("entry is already in the archive")
+
+// NewWriterFrom creates an archive that takes over the encryption of src: its
+// key, key slots, cipher and hidden metadata. The passwords, recipients and
+// keyfiles of src open the new archive, and CopyArchive can copy the streams
+// of src without decrypting them. A signature is not taken over, see SetSigningKey.
+func NewWriterFrom(filename string, src *Reader, splitSize int64) (*Writer, error) {
+	if volume := src.Volume(); volume != nil {
+		return nil, fmt.Errorf("only part %d of the archive could be read, all parts are needed to copy it", volume.Index)
+	}
+
+	// The key slot area is copied as is, it wraps the same archive key
+	var area []byte
+	if src.slotArea > 0 {
+		area = make([]byte, src.slotArea)
+		if _, err := src.file.ReadAt(area, int64(src.header.Size())); err != nil {
+			return nil, err
+		}
+	}
+
+	w, err := NewWriter(filename, nil, splitSize)
+	if err != nil {
+		return nil, err
+	}
+	header := src.header
+	w.inherited = &header
+	w.slotArea = area
+	if header.Flags&FlagEncrypted != 0 {
+		w.cipher = header.Cipher
+	}
+	w.hideMetadata = header.Flags&FlagHidden != 0
+	w.metadata.CreatedAt = src.metadata.CreatedAt
+	w.keyOnce.Do(func() {
+		w.masterKey = crypto.CloneSecret(src.masterKey)
+		crypto.Protect(w.masterKey)
+	})
+	return w, nil
+}
+
+// CopyArchive appends every entry of src. A stream is copied byte for byte when
+// src stores it the way this archive would: same key, cipher and padding, v8 or
+// later. Otherwise it is decrypted and sealed again with the key of this
+// archive, without the plaintext reaching the disk. The data of src is checked
+// against its DataChecksum on the way, and re-sealed streams against their
+// plaintext checksums.
+//
+// Directories already in the archive are skipped; a file that is already in
+// the archive fails with ErrDuplicateEntry.
+func (w *Writer) CopyArchive(src *Reader) error {
+	if volume := src.Volume(); volume != nil {
+		return fmt.Errorf("only part %d of the archive could be read, all parts are needed to copy it", volume.Index)
+	}
+	if src.header.Flags&FlagEncrypted != 0 && !w.encrypted() {
+		return errors.New("an encrypted archive cannot be copied into an unencrypted one")
+	}
+	ends, err := src.streamEnds()
+	if err != nil {
+		return err
+	}
+	if err := w.begin(); err != nil {
+		return err
+	}
+
+	isDir := make(map[string]bool)
+	for _, entry := range w.metadata.Files {
+		isDir[normalizeName(entry.Name)] = entry.IsDir
+	}
+
+	raw, err := w.storesLike(src)
+	if err != nil {
+		return err
+	}
+	dataHasher := utils.NewBlake3()
+	for i, entry := range src.metadata.Files {
+		name := normalizeName(entry.Name)
+		if dir, ok := isDir[name]; ok {
+			if dir && entry.IsDir {
+				continue
+			}
+			return fmt.Errorf("%w: %s", ErrDuplicateEntry, entry.Name)
+		}
+		isDir[name] = entry.IsDir
+
+		if entry.IsDir {
+			if err := w.appendDirectory(entry, fileSource{}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		length := ends[i] - int64(entry.Offset)
+		stored := io.TeeReader(io.NewSectionReader(src.file, int64(entry.Offset), length), dataHasher)
+		if err := w.copyStream(src, entry, stored, length, raw); err != nil {
+			return fmt.Errorf("%s: %w", entry.Name, err)
+		}
+	}
+
+	if !bytes.Equal(dataHasher.Sum(nil), src.header.DataChecksum[:]) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// streamEnds returns where the stored stream of each file entry ends. Streams
+// follow each other from the key slot area to the metadata in entry order, so
+// each one ends where the next begins.
+func (r *Reader) streamEnds() ([]int64, error) {
+	ends := make([]int64, len(r.metadata.Files))
+	last := -1
+	for i, entry := range r.metadata.Files {
+		if entry.IsDir {
+			continue
+		}
+		if last >= 0 {
+			if entry.Offset < r.metadata.Files[last].Offset {
+				return nil, fmt.Errorf("%w: streams are not stored in entry order", ErrInvalidFormat)
+			}
+			ends[last] = int64(entry.Offset)
+		} else if entry.Offset < r.dataStart {
+			return nil, fmt.Errorf("%w: stream of %s starts before the data", ErrInvalidFormat, entry.Name)
+		}
+		last = i
+	}
+	if last >= 0 {
+		if r.header.MetadataOffset < r.metadata.Files[last].Offset {
+			return nil, fmt.Errorf("%w: stream of %s starts after the data", ErrInvalidFormat, r.metadata.Files[last].Name)
+		}
+		ends[last] = int64(r.header.MetadataOffset)
+	}
+	return ends, nil
+}
+
+// storesLike reports whether the streams of src can be copied into w as they are.
+func (w *Writer) storesLike(src *Reader) (bool, error) {
+	if src.header.Flags&FlagEncrypted == 0 {
+		return !w.encrypted(), nil
+	}
+	key, err := w.key()
+	if err != nil {
+		return false, err
+	}
+	hidden := src.header.Flags&FlagHidden != 0
+	return src.header.Version >= 8 && src.header.Cipher == w.cipher && hidden == w.hidden() &&
+		subtle.ConstantTimeCompare(key, src.masterKey) == 1, nil
+}
+
+// copyStream appends a file entry of src whose stream of length bytes is read from stored.
+func (w *Writer) copyStream(src *Reader, entry FileEntry, stored io.Reader, length int64, raw bool) error {
+	if w.OnFileStart != nil {
+		w.OnFileStart(entry.Name)
+	}
+
+	// Encrypted streams are bound to their offset, so only copy them to the same one
+	offset := w.dataOffset
+	raw = raw && (!w.encrypted() || offset == entry.Offset)
+	size := length
+	if !raw {
+		size = w.storedSize(int64(entry.Size))
+	}
+	if err := w.placeEntry(entry.Name, size); err != nil {
+		return err
+	}
+
+	countingWriter := &utils.CountingWriter{Writer: w.file, Callback: w.OnProgress}
+	out := io.MultiWriter(countingWriter, w.dataSink())
+	if raw {
+		if _, err := io.CopyBuffer(out, stored, make([]byte, 256*1024)); err != nil {
+			return err
+		}
+	} else if err := w.resealStream(src, entry, offset, stored, out); err != nil {
+		return err
+	}
+
+	entry.Offset = offset
+	return w.appendFile(entry, countingWriter.Count, fileSource{})
+}
+
+// resealStream seals the plaintext of a stream of src with the key of w, to start
+// at offset. The stream is decrypted with the key of src, or read as is when src
+// is not encrypted.
+func (w *Writer) resealStream(src *Reader, entry FileEntry, offset uint64, stored io.Reader, out io.Writer) error {
+	hasher := utils.NewXXHash64()
+
+	var plaintext io.Reader
+	if src.header.Flags&FlagEncrypted == 0 {
+		plaintext = io.LimitReader(stored, int64(entry.Size))
+	} else {
+		pr, pw := io.Pipe()
+		defer pr.Close()
+		go func() {
+			pw.CloseWithError(src.decryptStream(entry, bufio.NewReaderSize(stored, 128*1024), pw))
+		}()
+		plaintext = pr
+	}
+	plaintext = io.TeeReader(plaintext, hasher)
+	if w.hidden() {
+		plaintext = io.MultiReader(plaintext, &zeroReader{n: padme(entry.Size) - entry.Size})
+	}
+
+	key, err := w.key()
+	if err != nil {
+		return err
+	}
+	if err := crypto.SealStream(plaintext, out, w.cipher, key, nil, entryAAD(entry.Name, offset)); err != nil {
+		return err
+	}
+
+	// The rest of the stored stream still counts for DataChecksum
+	if _, err := io.Copy(io.Discard, stored); err != nil {
+		return err
+	}
+	if hasher.Sum64() != entry.Checksum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// rewrite copies the archives at inputs into out, taking over the encryption of the first one
+func rewrite(t *testing.T, out string, split int64, inputs []string, passwords []string) error {
+	t.Helper()
+	var w *Writer
+	for i, input := range inputs {
+		r, err := NewReader(input, []byte(passwords[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		if w == nil {
+			if w, err = NewWriterFrom(out, r, split); err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+		}
+		if err := w.CopyArchive(r); err != nil {
+			return err
+		}
+	}
+	return w.Finalize()
+}
+
+// TestResplitAndJoin changes the part size of an archive and joins it, without touching the streams
+func TestResplitAndJoin(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	os.MkdirAll(filepath.Join(src, "sub"), 0755)
+	os.WriteFile(filepath.Join(src, "a.bin"), bytes.Repeat([]byte("resplit "), 10_000), 0644)
+	os.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("beta"), 0600)
+
+	for _, password := range []string{"", "pw"} {
+		archivePath := filepath.Join(tmpDir, "in.chin")
+		w, err := NewWriter(archivePath, []byte(password), 30_000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.SetHideMetadata(true)
+		if err := w.AddFile(src, "src"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Finalize(); err != nil {
+			t.Fatal(err)
+		}
+		original, _ := ReadHeader(archivePath)
+
+		resplit := filepath.Join(tmpDir, "resplit.chin")
+		joined := filepath.Join(tmpDir, "joined.chin")
+		if err := rewrite(t, resplit, 20_000, []string{archivePath}, []string{password}); err != nil {
+			t.Fatal(err)
+		}
+		if err := rewrite(t, joined, 0, []string{resplit}, []string{password}); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, out := range []string{resplit, joined} {
+			header, err := ReadHeader(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Streams copied as they are keep the data checksum
+			if header.DataChecksum != original.DataChecksum || header.Flags&^FlagSplit != original.Flags&^FlagSplit {
+				t.Fatalf("%s (password %q): data or flags changed", filepath.Base(out), password)
+			}
+			r, err := NewReader(out, []byte(password))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Verify(); err != nil {
+				t.Fatalf("%s (password %q): %v", filepath.Base(out), password, err)
+			}
+			if len(r.ListFiles()) != 4 {
+				t.Fatalf("%s: expected 4 entries, got %d", filepath.Base(out), len(r.ListFiles()))
+			}
+			r.Close()
+		}
+		if info, err := os.Stat(partName(resplit, 3)); err != nil || info.Size() > 20_000 {
+			t.Fatalf("expected 20KB parts (password %q)", password)
+		}
+		if _, err := os.Stat(partName(joined, 1)); !os.IsNotExist(err) {
+			t.Fatal("joined archive still has parts")
+		}
+	}
+}
+
+// TestRecut cuts a signed archive again without its password and keeps the signature
+func TestRecut(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	os.MkdirAll(src, 0755)
+	os.WriteFile(filepath.Join(src, "a.bin"), bytes.Repeat([]byte("recut "), 20_000), 0644)
+	os.WriteFile(filepath.Join(src, "b.txt"), []byte("beta"), 0644)
+
+	signer := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	archivePath := filepath.Join(tmpDir, "in.chin")
+	w, err := NewWriter(archivePath, []byte("pw"), 30_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetSigningKey(signer)
+	if err := w.AddFile(src, "src"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	resplit := filepath.Join(tmpDir, "resplit.chin")
+	joined := filepath.Join(tmpDir, "joined.chin")
+	if err := Recut(archivePath, resplit, 20_000, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := Recut(resplit, joined, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, out := range []string{resplit, joined} {
+		if _, err := VerifySignature(out, []ed25519.PublicKey{signer.Public().(ed25519.PublicKey)}); err != nil {
+			t.Fatalf("%s: %v", filepath.Base(out), err)
+		}
+		r, err := NewReader(out, []byte("pw"))
+		if err != nil {
+			t.Fatalf("%s: %v", filepath.Base(out), err)
+		}
+		if err := r.Verify(); err != nil {
+			t.Fatalf("%s: %v", filepath.Base(out), err)
+		}
+		r.Close()
+	}
+	if info, err := os.Stat(partName(resplit, 3)); err != nil || info.Size() > 20_000 {
+		t.Fatal("expected 20KB parts")
+	}
+	if _, err := os.Stat(partName(joined, 1)); !os.IsNotExist(err) {
+		t.Fatal("joined archive still has parts")
+	}
+
+	// The manifest of the original parts still finds damaged data
+	statuses, err := VerifyParts(resplit, Credentials{Password: []byte("pw")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.Problem != nil {
+			t.Fatalf("%s: %v", status.Name, status.Problem)
+		}
+	}
+	data, _ := os.ReadFile(partName(resplit, 2))
+	data[VolumeHeaderSize+100] ^= 1
+	os.WriteFile(partName(resplit, 2), data, 0644)
+	statuses, err = VerifyParts(resplit, Credentials{Password: []byte("pw")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(statuses[2].Problem, ErrPartCorrupted) || statuses[4].Problem != nil {
+		t.Fatalf("damaged part 2 not found: %v", statuses)
+	}
+	if err := Recut(resplit, filepath.Join(tmpDir, "damaged.chin"), 0, nil); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("recut damaged data: %v", err)
+	}
+
+	// Signing again replaces the signature
+	other := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{2}, ed25519.SeedSize))
+	resigned := filepath.Join(tmpDir, "resigned.chin")
+	if err := Recut(joined, resigned, 0, other); err != nil {
+		t.Fatal(err)
+	}
+	if signerKey, err := VerifySignature(resigned, nil); err != nil || !signerKey.Equal(other.Public()) {
+		t.Fatalf("resigned archive: %v", err)
+	}
+}
+
+// TestMergeArchives merges archives with different keys into the key of the first one
+func TestMergeArchives(t *testing.T) {
+	tmpDir := t.TempDir()
+	pack := func(name, password string, hide bool, files map[string]string) string {
+		src := filepath.Join(tmpDir, name)
+		for file, content := range files {
+			os.MkdirAll(filepath.Join(src, "shared", filepath.Dir(file)), 0755)
+			os.WriteFile(filepath.Join(src, "shared", file), []byte(content), 0644)
+		}
+		archivePath := filepath.Join(tmpDir, name+".chin")
+		w, err := NewWriter(archivePath, []byte(password), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.SetHideMetadata(hide)
+		if err := w.AddFile(filepath.Join(src, "shared"), "shared"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Finalize(); err != nil {
+			t.Fatal(err)
+		}
+		return archivePath
+	}
+	a := pack("a", "first", false, map[string]string{"a.txt": "alpha"})
+	b := pack("b", "second", true, map[string]string{"b.txt": "beta"})
+	c := pack("c", "", false, map[string]string{"c.txt": "gamma"})
+
+	merged := filepath.Join(tmpDir, "merged.chin")
+	if err := rewrite(t, merged, 0, []string{a, b, c}, []string{"first", "second", ""}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(merged, []byte("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err := r.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	// The shared directory is only listed once
+	if len(r.ListFiles()) != 4 {
+		t.Fatalf("expected 4 entries, got %v", r.ListFiles())
+	}
+	out := filepath.Join(tmpDir, "out")
+	if err := r.ExtractAll(out, true); err != nil {
+		t.Fatal(err)
+	}
+	for file, content := range map[string]string{"a.txt": "alpha", "b.txt": "beta", "c.txt": "gamma"} {
+		if data, _ := os.ReadFile(filepath.Join(out, "shared", file)); string(data) != content {
+			t.Fatalf("%s: got %q", file, data)
+		}
+	}
+
+	if err := rewrite(t, filepath.Join(tmpDir, "dup.chin"), 0, []string{a, a}, []string{"first", "first"}); !errors.Is(err, ErrDuplicateEntry) {
+		t.Fatalf("expected a duplicate entry, got %v", err)
+	}
+	if err := rewrite(t, filepath.Join(tmpDir, "plain.chin"), 0, []string{c, a}, []string{"", "first"}); err == nil {
+		t.Fatal("copied an encrypted archive into an unencrypted one")
+	}
+}
@@ -0,0 +1,149 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+
+	"chin/internal/crypto"
+	"chin/internal/utils"
+)
+
+// Signature block appended after the metadata of a signed archive:
+// [Magic 4][Public key 32][Signature 64]
+const (
+	SignatureMagic = "CSIG"
+	SignatureSize  = 4 + ed25519.PublicKeySize + ed25519.SignatureSize
+)
+
+var (
+	ErrNotSigned       = errors.New("archive is not signed")
+	ErrBadSignature    = errors.New("signature verification failed")
+	ErrUntrustedSigner = errors.New("archive is signed by an untrusted key")
+)
+
+// SetSigningKey signs the archive with an Ed25519 key when it is finalized.
+// The key is copied and the copy is wiped with the writer's other secrets.
+func (w *Writer) SetSigningKey(key ed25519.PrivateKey) {
+	w.signingKey = ed25519.PrivateKey(crypto.CloneSecret(key))
+}
+
+// signatureDigest is what the signature covers: the header (which holds the
+// DataChecksum) and the stored metadata, encrypted or not. Key slots are left
+// out so credentials can change without invalidating the signature.
+func signatureDigest(header, metadata []byte) []byte {
+	h := utils.NewBlake3()
+	writeField(h, []byte("chin-signature-v7"))
+	writeField(h, header)
+	writeField(h, metadata)
+	return h.Sum(nil)
+}
+
+func signatureBlock(key ed25519.PrivateKey, header, metadata []byte) []byte {
+	block := make([]byte, 0, SignatureSize)
+	block = append(block, SignatureMagic...)
+	block = append(block, key.Public().(ed25519.PublicKey)...)
+	return append(block, ed25519.Sign(key, signatureDigest(header, metadata))...)
+}
+
+// VerifySignature checks the signature of an archive and recomputes its
+// DataChecksum, without needing a password. If trusted is not empty, the
+// signer must be one of the trusted keys. It returns the signer's public key.
+func VerifySignature(filename string, trusted []ed25519.PublicKey) (ed25519.PublicKey, error) {
+	file, header, err := openArchive(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if header.Flags&FlagSigned == 0 {
+		return nil, ErrNotSigned
+	}
+
+	end, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	metadataEnd := end - SignatureSize
+	if metadataEnd < int64(header.MetadataOffset) {
+		return nil, fmt.Errorf("%w: signature block truncated", ErrBadSignature)
+	}
+
+	metadata := make([]byte, metadataEnd-int64(header.MetadataOffset))
+	if _, err := file.ReadAt(metadata, int64(header.MetadataOffset)); err != nil {
+		return nil, err
+	}
+	block := make([]byte, SignatureSize)
+	if _, err := file.ReadAt(block, metadataEnd); err != nil {
+		return nil, err
+	}
+	if string(block[:4]) != SignatureMagic {
+		return nil, fmt.Errorf("%w: no signature block", ErrBadSignature)
+	}
+	signer := ed25519.PublicKey(block[4 : 4+ed25519.PublicKeySize])
+	signature := block[4+ed25519.PublicKeySize:]
+
+	if len(trusted) > 0 {
+		found := false
+		for _, key := range trusted {
+			if key.Equal(signer) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return signer, fmt.Errorf("%w (%s)", ErrUntrustedSigner, crypto.EncodeSigningPublicKey(signer))
+		}
+	}
+
+	if !ed25519.Verify(signer, signatureDigest(header.bytes(), metadata), signature) {
+		return signer, ErrBadSignature
+	}
+
+	// The signature covers DataChecksum, so checking it covers the data
+	if err := VerifyChecksum(filename); err != nil {
+		return signer, err
+	}
+	return signer, nil
+}
+
+// VerifyChecksum recomputes the DataChecksum of an archive without needing a password.
+// Unlike VerifySignature it proves integrity, not origin.
+func VerifyChecksum(filename string) error {
+	file, header, err := openArchive(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	dataStart := int64(header.Size())
+	if header.Flags&FlagKeySlots != 0 {
+		_, areaSize, err := readKeySlots(file, dataStart)
+		if err != nil {
+			return err
+		}
+		dataStart += int64(areaSize)
+	}
+	if volume, ok := file.(*volumeFile); ok {
+		dataStart = volume.dataFrom
+	}
+	return verifyDataChecksum(file, dataStart, header)
+}
+
+// verifyDataChecksum streams the stored data region through BLAKE3.
+func verifyDataChecksum(file io.ReaderAt, dataStart int64, header *Header) error {
+	if int64(header.MetadataOffset) < dataStart {
+		return ErrInvalidFormat
+	}
+	h := utils.NewBlake3()
+	section := io.NewSectionReader(file, dataStart, int64(header.MetadataOffset)-dataStart)
+	if _, err := io.CopyBuffer(h, section, make([]byte, 256*1024)); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), header.DataChecksum[:]) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
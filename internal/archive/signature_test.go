@@ -0,0 +1,74 @@
+package archive
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"chin/internal/crypto"
+)
+
+// TestSignedArchive signs an encrypted archive and checks trust, tampering and normal reading
+func TestSignedArchive(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "release.bin")
+	if err := os.WriteFile(src, []byte("release build"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	seed, public, _ := crypto.GenerateEd25519()
+	_, other, _ := crypto.GenerateEd25519()
+
+	archivePath := filepath.Join(tmpDir, "out.chin")
+	w, err := NewWriter(archivePath, []byte("pw"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetKDF(crypto.KDFParams{Algorithm: crypto.KDFPBKDF2, Iterations: 1000})
+	w.SetSigningKey(ed25519.NewKeyFromSeed(seed))
+	if err := w.AddFile(src, "release.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := VerifySignature(archivePath, []ed25519.PublicKey{public})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !signer.Equal(ed25519.PublicKey(public)) {
+		t.Fatal("unexpected signer")
+	}
+	if _, err := VerifySignature(archivePath, []ed25519.PublicKey{other}); !errors.Is(err, ErrUntrustedSigner) {
+		t.Fatalf("expected ErrUntrustedSigner, got %v", err)
+	}
+
+	r, err := NewReader(archivePath, []byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	metadataOffset := int64(r.header.MetadataOffset)
+	dataStart := int64(r.dataStart)
+	r.Close()
+
+	// Flip one byte of the metadata, then one of the data
+	data, _ := os.ReadFile(archivePath)
+	data[metadataOffset] ^= 1
+	os.WriteFile(archivePath, data, 0644)
+	if _, err := VerifySignature(archivePath, nil); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected ErrBadSignature, got %v", err)
+	}
+
+	data[metadataOffset] ^= 1
+	data[dataStart] ^= 1
+	os.WriteFile(archivePath, data, 0644)
+	if _, err := VerifySignature(archivePath, nil); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+}
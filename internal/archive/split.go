@@ -1,9 +1,16 @@
 package archive
 
 import (
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 // SplitFile interface covers both *os.File and our custom split logic
@@ -15,7 +22,11 @@ type SplitFile interface {
 	Sync() error
 }
 
-// SplitWriter handles writing across multiple files
+// SplitWriter handles writing across multiple files. Every part starts with a
+// volume header, outside of the virtual stream; maxSize is what is left of
+// the split size after it.
+// With independent volumes, each part also ends with a volume index, so a part
+// is cut before maxSize to leave room for it, and may be cut early with cut.
 type SplitWriter struct {
 	basePath    string
 	maxSize     int64
@@ -24,24 +35,196 @@ type SplitWriter struct {
 	currentSize int64
 	totalSize   int64 // Virtual position
 	openedFiles map[int]*os.File
+	starts      []int64 // Virtual offset of the first byte of each part
+	archiveID   [16]byte
+	independent bool
+	reserve     func(part int) int64 // Room kept at the end of a part for its volume index
+	dry         bool // Only count the parts and bytes, see NewDryRunWriter
+	closed      bool
 }
 
+var ErrVolumeTooSmall = errors.New("split size is too small for the volume index")
+
 func NewSplitWriter(basePath string, maxSize int64) (*SplitWriter, error) {
+	if maxSize <= VolumeHeaderSize {
+		return nil, fmt.Errorf("split size must be larger than %d bytes", VolumeHeaderSize)
+	}
+	s := &SplitWriter{
+		basePath:    basePath,
+		maxSize:     maxSize - VolumeHeaderSize,
+		openedFiles: map[int]*os.File{},
+	}
+	if _, err := rand.Read(s.archiveID[:]); err != nil {
+		return nil, err
+	}
+
 	// First file is just .chin
-	f, err := os.Create(basePath)
-	if err != nil {
+	if err := s.create(0); err != nil {
 		return nil, err
 	}
+	return s, nil
+}
 
-	return &SplitWriter{
+// reopenSplitWriter opens the parts of an interrupted split archive to go on
+// writing at virtual offset off. Data after off is cut and later parts are removed.
+func reopenSplitWriter(basePath string, maxSize int64, off int64) (*SplitWriter, error) {
+	first, err := ReadVolumeHeader(basePath)
+	if err != nil {
+		return nil, err
+	}
+	if int64(first.PartSize) != maxSize-VolumeHeaderSize {
+		return nil, fmt.Errorf("%w: parts of %d bytes, not %d", ErrResumeMismatch, first.PartSize+VolumeHeaderSize, maxSize)
+	}
+	s := &SplitWriter{
 		basePath:    basePath,
-		maxSize:     maxSize,
-		currentFile: f,
-		partIndex:   0,
-		currentSize: 0,
-		totalSize:   0,
-		openedFiles: map[int]*os.File{0: f},
-	}, nil
+		maxSize:     maxSize - VolumeHeaderSize,
+		openedFiles: map[int]*os.File{},
+		archiveID:   first.ArchiveID,
+	}
+	// The volume headers are left as they are until the parts are complete
+	abort := func(err error) (*SplitWriter, error) {
+		for _, f := range s.openedFiles {
+			f.Close()
+		}
+		return nil, err
+	}
+	ended := fmt.Errorf("%w: the archive ends before its journal", ErrJournalMismatch)
+
+	// Parts up to the one holding off, whose size gives the start of the next
+	for i := 0; i == 0 || s.totalSize < off; i++ {
+		f, err := os.OpenFile(partName(basePath, i), os.O_RDWR, 0)
+		if os.IsNotExist(err) {
+			return abort(ended)
+		} else if err != nil {
+			return abort(err)
+		}
+		s.openedFiles[i] = f
+		info, err := f.Stat()
+		if err != nil {
+			return abort(err)
+		}
+		s.currentFile = f
+		s.partIndex = i
+		s.starts = append(s.starts, s.totalSize)
+		s.currentSize = max(info.Size()-VolumeHeaderSize, 0)
+		s.totalSize += s.currentSize
+	}
+	if s.totalSize < off {
+		return abort(ended)
+	}
+
+	for i := s.partIndex + 1; ; i++ {
+		if err := os.Remove(partName(basePath, i)); err != nil {
+			break
+		}
+	}
+	s.currentSize = off - s.starts[s.partIndex]
+	s.totalSize = off
+	if err := s.currentFile.Truncate(VolumeHeaderSize + s.currentSize); err != nil {
+		return abort(err)
+	}
+	if _, err := s.currentFile.Seek(VolumeHeaderSize+s.currentSize, io.SeekStart); err != nil {
+		return abort(err)
+	}
+	return s, nil
+}
+
+// newDrySplitWriter returns a split writer that creates no files.
+func newDrySplitWriter(maxSize int64) (*SplitWriter, error) {
+	if maxSize <= VolumeHeaderSize {
+		return nil, fmt.Errorf("split size must be larger than %d bytes", VolumeHeaderSize)
+	}
+	s := &SplitWriter{
+		maxSize:     maxSize - VolumeHeaderSize,
+		openedFiles: map[int]*os.File{},
+		dry:         true,
+	}
+	return s, s.create(0)
+}
+
+// create starts part i with a volume header. The count is filled in by Sync.
+func (s *SplitWriter) create(i int) error {
+	var f *os.File
+	if !s.dry {
+		var err error
+		f, err = os.Create(partName(s.basePath, i))
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(s.volumeHeader(i).Serialize()); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	s.currentFile = f
+	s.partIndex = i
+	s.openedFiles[i] = f
+	s.starts = append(s.starts, s.totalSize)
+	s.currentSize = 0
+	return nil
+}
+
+func (s *SplitWriter) volumeHeader(i int) VolumeHeader {
+	v := VolumeHeader{
+		ArchiveID: s.archiveID,
+		Index:     uint32(i),
+		Count:     uint32(len(s.openedFiles)),
+		PartSize:  uint64(s.maxSize),
+	}
+	if s.independent {
+		v.Flags |= VolumeIndependent
+	}
+	return v
+}
+
+// limit returns the number of bytes of the stream that part i may hold.
+func (s *SplitWriter) limit(i int) int64 {
+	if s.reserve == nil {
+		return s.maxSize
+	}
+	return s.maxSize - s.reserve(i)
+}
+
+// partAt returns the part holding virtual offset off and the offset where that
+// part ends. Past the end of a full last part, it is the next part, which is
+// only created by the next write.
+func (s *SplitWriter) partAt(off int64) (int, int64) {
+	i := max(sort.Search(len(s.starts), func(j int) bool { return s.starts[j] > off })-1, 0)
+	if i < len(s.starts)-1 {
+		return i, s.starts[i+1]
+	}
+	end := s.starts[i] + s.limit(i)
+	if off >= end {
+		return i + 1, math.MaxInt64
+	}
+	return i, end
+}
+
+// cut starts a new part, unless the current one is still empty.
+func (s *SplitWriter) cut() error {
+	if s.currentSize == 0 {
+		return nil
+	}
+	return s.rotate()
+}
+
+// SetArchiveID replaces the random archive ID, for reproducible archives.
+// It takes effect on all parts at the next Sync.
+func (s *SplitWriter) SetArchiveID(id [16]byte) {
+	s.archiveID = id
+}
+
+// writeVolumeHeaders rewrites the volume header of every part with the final count.
+func (s *SplitWriter) writeVolumeHeaders() error {
+	if s.dry {
+		return nil
+	}
+	for i, f := range s.openedFiles {
+		if _, err := f.WriteAt(s.volumeHeader(i).Serialize(), 0); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (s *SplitWriter) Write(p []byte) (n int, err error) {
@@ -55,12 +238,15 @@ func (s *SplitWriter) Write(p []byte) (n int, err error) {
 
 	totalWritten := 0
 	for len(p) > 0 {
-		remainingSpace := s.maxSize - s.currentSize
+		remainingSpace := s.limit(s.partIndex) - s.currentSize
 		if remainingSpace <= 0 {
 			if err := s.rotate(); err != nil {
 				return totalWritten, err
 			}
-			remainingSpace = s.maxSize
+			remainingSpace = s.limit(s.partIndex)
+			if remainingSpace <= 0 {
+				return totalWritten, ErrVolumeTooSmall
+			}
 		}
 
 		toWrite := int64(len(p))
@@ -68,7 +254,11 @@ func (s *SplitWriter) Write(p []byte) (n int, err error) {
 			toWrite = remainingSpace
 		}
 
-		n, err := s.currentFile.Write(p[:toWrite])
+		n := int(toWrite)
+		var err error
+		if !s.dry {
+			n, err = s.currentFile.Write(p[:toWrite])
+		}
 		totalWritten += n
 		s.currentSize += int64(n)
 		s.totalSize += int64(n)
@@ -83,18 +273,7 @@ func (s *SplitWriter) Write(p []byte) (n int, err error) {
 }
 
 func (s *SplitWriter) rotate() error {
-	s.partIndex++
-	filename := fmt.Sprintf("%s.c%02d", s.basePath, s.partIndex) // .chin.c01
-	
-	f, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	
-	s.currentFile = f
-	s.openedFiles[s.partIndex] = f
-	s.currentSize = 0
-	return nil
+	return s.create(s.partIndex + 1) // .chin.c01
 }
 
 // Seek handles virtual seeking across files
@@ -125,13 +304,11 @@ func (s *SplitWriter) Seek(offset int64, whence int) (int64, error) {
 
 	s.totalSize = absOffset
 	
-	// Determine which part contains this offset
-	targetPart := int(absOffset / s.maxSize)
-	offsetInPart := absOffset % s.maxSize
-	
-	// If seeking to exactly the end of a part, it technically belongs to start of next?
-	// But logically bytes [0..max-1] in part 0.
-	
+	// Determine which part contains this offset. The end of the last part
+	// stays in it, the next part is not created yet.
+	targetPart := max(sort.Search(len(s.starts), func(j int) bool { return s.starts[j] > absOffset })-1, 0)
+	offsetInPart := absOffset - s.starts[targetPart]
+
 	if targetPart != s.partIndex {
 		// Switch file
 		f, ok := s.openedFiles[targetPart]
@@ -150,7 +327,7 @@ func (s *SplitWriter) Seek(offset int64, whence int) (int64, error) {
 		s.partIndex = targetPart
 	}
 	
-	_, err := s.currentFile.Seek(offsetInPart, io.SeekStart)
+	_, err := s.currentFile.Seek(VolumeHeaderSize+offsetInPart, io.SeekStart)
 	if err != nil {
 		return 0, err
 	}
@@ -162,15 +339,23 @@ func (s *SplitWriter) Seek(offset int64, whence int) (int64, error) {
 func (s *SplitWriter) getVirtualEnd() int64 {
 	// Calculate total size based on parts
 	// Simple approximation if we fill parts:
-	// (partIndex * maxSize) + currentSize
-	return (int64(s.partIndex) * s.maxSize) + s.currentSize
+	// start of the current part + currentSize
+	return s.starts[s.partIndex] + s.currentSize
 }
 
 func (s *SplitWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	err := s.writeVolumeHeaders()
+	if s.dry {
+		return err
+	}
 	for _, f := range s.openedFiles {
 		f.Close()
 	}
-	return nil
+	return err
 }
 
 func (s *SplitWriter) Truncate(size int64) error {
@@ -181,12 +366,37 @@ func (s *SplitWriter) Truncate(size int64) error {
 	}
 	// For split, we might need to delete later parts?
 	// Assuming we only truncate a few bytes at end of last part.
-	return s.currentFile.Truncate(s.currentSize)
+	return s.currentFile.Truncate(VolumeHeaderSize + s.currentSize)
 }
 
+// appendIndexes writes the volume index of every part after its data, once the
+// stream is complete. index returns it for part i, which holds [start, end) of the stream.
+func (s *SplitWriter) appendIndexes(index func(i int, start, end int64) ([]byte, error)) error {
+	for i, start := range s.starts {
+		end := s.getVirtualEnd()
+		if i < len(s.starts)-1 {
+			end = s.starts[i+1]
+		}
+		data, err := index(i, start, end)
+		if err != nil {
+			return err
+		}
+		if _, err := s.openedFiles[i].WriteAt(data, VolumeHeaderSize+end-start); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sync brings the volume headers up to date and syncs every part.
 func (s *SplitWriter) Sync() error {
+	if err := s.writeVolumeHeaders(); err != nil {
+		return err
+	}
 	for _, f := range s.openedFiles {
-		f.Sync()
+		if err := f.Sync(); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -196,19 +406,86 @@ func (s *SplitWriter) Read(p []byte) (n int, err error) {
 	return s.currentFile.Read(p)
 }
 
+// ReadAt reads back the stream written so far, for example to resume a pack.
 func (s *SplitWriter) ReadAt(p []byte, off int64) (n int, err error) {
-	// Not implemented for Writer
-	return 0, fmt.Errorf("ReadAt not supported in SplitWriter")
+	for len(p) > 0 {
+		i, end := s.partAt(off)
+		f, ok := s.openedFiles[i]
+		if !ok {
+			return n, io.EOF
+		}
+		m, err := f.ReadAt(p[:min(int64(len(p)), end-off)], VolumeHeaderSize+off-s.starts[i])
+		n += m
+		off += int64(m)
+		p = p[m:]
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
 }
 
 
-// SplitReader handles reading split archives
+// partName returns the file name of part i: the base path for part 0, then .c01, .c02, ...
+func partName(basePath string, i int) string {
+	if i == 0 {
+		return basePath
+	}
+	return fmt.Sprintf("%s.c%02d", basePath, i)
+}
+
+// findParts returns the indexes of the parts of basePath that exist, part 0 included.
+func findParts(basePath string) (map[int]string, int, error) {
+	entries, err := os.ReadDir(filepath.Dir(basePath))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	found := map[int]string{0: basePath}
+	last := 0
+	for _, entry := range entries {
+		i, ok := partIndex(basePath, entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		found[i] = partName(basePath, i)
+		if i > last {
+			last = i
+		}
+	}
+	return found, last + 1, nil
+}
+
+// partIndex returns i if name is the file name of part i > 0 of basePath.
+func partIndex(basePath, name string) (int, bool) {
+	prefix := filepath.Base(basePath) + ".c"
+	if !strings.HasPrefix(name, prefix) {
+		return 0, false
+	}
+	i, err := strconv.Atoi(name[len(prefix):])
+	if err != nil || i < 1 || name != filepath.Base(partName(basePath, i)) {
+		return 0, false
+	}
+	return i, true
+}
+
+// SplitReader handles reading split archives. Every part but the last has the
+// same size, so a virtual offset maps to a part without reading the others:
+// a missing or truncated part only fails the reads that touch it.
+// Since v9 parts are found by their volume header; older archives by name.
+// Independent volumes have different sizes, their index gives their place.
 type SplitReader struct {
-	basePath string
-	parts    []*os.File
-	sizes    []int64
-	totalSize int64
-	currentPart int
+	basePath      string
+	parts         []*os.File // nil for a missing part
+	names         []string
+	sizes         []int64 // Size of each part without its volume header, 0 if missing
+	starts        []int64 // Virtual offset of the first byte of each part
+	foreign       map[int]string // Files named like a missing part, from another archive
+	damaged       map[int]error  // Independent volumes whose index cannot be read
+	headerSize    int64          // Volume header size, 0 before v9
+	independent   bool
+	partSize      int64
+	totalSize     int64
 	currentOffset int64 // global virtual offset
 }
 
@@ -218,78 +495,209 @@ func NewSplitReader(basePath string) (*SplitReader, error) {
 	if err != nil {
 		return nil, err
 	}
-	
-	parts := []*os.File{f0}
-	
-	// Detect other parts
-	i := 1
-	for {
-		name := fmt.Sprintf("%s.c%02d", basePath, i)
-		f, err := os.Open(name)
-		if os.IsNotExist(err) {
-			break
+
+	// Detect other parts, including those after a gap
+	var names map[int]string
+	var foreign map[int]string
+	var count int
+	volume, err := readVolumeHeader(f0)
+	switch {
+	case err == ErrInvalidFormat:
+		names, count, err = findParts(basePath)
+	case err != nil:
+	case volume.Index != 0:
+		err = fmt.Errorf("%s is part %d of a split archive, open the first part instead", basePath, volume.Index)
+	default:
+		names, foreign, err = scanVolumes(basePath, volume.ArchiveID)
+		count = int(volume.Count)
+		if count == 0 {
+			// Not finalized: take the parts that are there
+			for i := range names {
+				count = max(count, i+1)
+			}
+			count = max(count, 1)
 		}
+	}
+	if err != nil {
+		f0.Close()
+		return nil, err
+	}
+
+	r := &SplitReader{
+		basePath: basePath,
+		parts:    make([]*os.File, count),
+		names:    make([]string, count),
+		sizes:    make([]int64, count),
+		starts:   make([]int64, count),
+		foreign:  foreign,
+		damaged:  map[int]error{},
+	}
+	r.parts[0] = f0
+	r.names[0] = basePath
+	for i := 1; i < count; i++ {
+		if _, ok := names[i]; !ok {
+			continue
+		}
+		f, err := os.Open(names[i])
 		if err != nil {
+			r.Close()
 			return nil, err
 		}
-		parts = append(parts, f)
-		i++
+		r.parts[i] = f
+		r.names[i] = names[i]
 	}
-	
+
+	if volume != nil {
+		r.headerSize = VolumeHeaderSize
+		r.partSize = int64(volume.PartSize)
+		r.independent = volume.Independent()
+	}
+
 	// Calculate sizes
-	sizes := make([]int64, len(parts))
-	var total int64
-	for i, f := range parts {
-		info, _ := f.Stat()
-		sizes[i] = info.Size()
-		total += sizes[i]
+	for i, f := range r.parts {
+		if f == nil {
+			continue
+		}
+		info, err := f.Stat()
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.sizes[i] = max(info.Size()-r.headerSize, 0)
+		if r.independent {
+			r.placeVolume(i, info.Size())
+		}
 	}
 
-	return &SplitReader{
-		basePath: basePath,
-		parts:    parts,
-		sizes:    sizes,
-		totalSize: total,
-	}, nil
+	if volume == nil {
+		// The largest of the parts before the last is the split size; a smaller
+		// one (part 0 included) is truncated
+		r.partSize = r.sizes[0]
+		for i := 0; i < count-1; i++ {
+			if r.sizes[i] > r.partSize {
+				r.partSize = r.sizes[i]
+			}
+		}
+	}
+	for i := range r.starts {
+		switch {
+		case !r.independent:
+			r.starts[i] = int64(i) * r.partSize
+		case i > 0 && r.parts[i] == nil:
+			// Unknown, a missing part runs until the next one found
+			r.starts[i] = r.starts[i-1] + r.sizes[i-1]
+		case i > 0 && r.starts[i] < r.starts[i-1]+r.sizes[i-1]:
+			r.drop(i, ErrPartCorrupted)
+			r.starts[i] = r.starts[i-1] + r.sizes[i-1]
+		}
+	}
+	r.totalSize = r.starts[count-1] + r.sizes[count-1]
+
+	return r, nil
 }
 
-func (r *SplitReader) Read(p []byte) (n int, err error) {
-	// Read from current part
-	if r.currentPart >= len(r.parts) {
-		return 0, io.EOF
+// placeVolume reads the place of independent volume i in the stream from its index.
+func (r *SplitReader) placeVolume(i int, fileSize int64) {
+	tail, err := readVolumeTail(r.parts[i], fileSize)
+	if err != nil {
+		r.drop(i, err)
+		return
 	}
-	
-	// Check if current part exhausted (should track offset within part?)
-	// Let's use Seek to manage global position.
-	
-	// Easier: Just forward read to current OS file pointer?
-	// But we need to switch file.
-	
-	// Let's rely on Seek state.
-	// We need to know where we are in current file.
-	// But `os.File` tracks its own offset.
-	// When we switch to Next part, we read from 0.
-	
-	f := r.parts[r.currentPart]
-	n, err = f.Read(p)
-	
-	if n > 0 {
-		r.currentOffset += int64(n)
+	r.starts[i] = tail.start
+	r.sizes[i] = tail.length
+}
+
+// drop closes part i, which is then read as missing and reported with problem.
+func (r *SplitReader) drop(i int, problem error) {
+	r.parts[i].Close()
+	r.parts[i] = nil
+	r.sizes[i] = 0
+	r.damaged[i] = problem
+}
+
+// problems reports the parts that are missing or do not have the split size.
+func (r *SplitReader) problems() []PartStatus {
+	var problems []PartStatus
+	last := len(r.parts) - 1
+	for i := range r.parts {
+		switch {
+		case r.parts[i] == nil:
+			problems = append(problems, r.missing(i))
+		case r.independent:
+			// The index gave the size of the part
+		case i < last && r.sizes[i] < r.partSize:
+			problems = append(problems, r.status(i, ErrPartTruncated))
+		case i == last && r.sizes[i] > r.partSize && last > 0:
+			problems = append(problems, r.status(i, ErrPartSize))
+		}
 	}
-	
-	if err == io.EOF {
-		// Try next part
-		if r.currentPart < len(r.parts)-1 {
-			r.currentPart++
-			// Seek next part to 0 just in case
-			r.parts[r.currentPart].Seek(0, io.SeekStart)
-			
-			// Recurse to read remaining bytes
-			n2, err2 := r.Read(p[n:])
-			return n + n2, err2
+	return problems
+}
+
+func (r *SplitReader) status(i int, problem error) PartStatus {
+	name := partName(r.basePath, i)
+	if i < len(r.names) && r.names[i] != "" {
+		name = r.names[i]
+	}
+	return PartStatus{Index: i, Name: name, Problem: problem}
+}
+
+// missing reports part i as missing, or as foreign if a file of another archive
+// took its name. A damaged independent volume is reported with its problem.
+func (r *SplitReader) missing(i int) PartStatus {
+	if problem, ok := r.damaged[i]; ok {
+		return r.status(i, problem)
+	}
+	if name, ok := r.foreign[i]; ok {
+		return PartStatus{Index: i, Name: name, Problem: ErrPartForeign}
+	}
+	return r.status(i, ErrPartMissing)
+}
+
+// locate maps a virtual offset to part i, the offset in its file and the
+// number of bytes of the part from there.
+func (r *SplitReader) locate(off int64) (i int, fileOffset int64, n int64) {
+	last := len(r.parts) - 1
+	i = max(sort.Search(len(r.starts), func(j int) bool { return r.starts[j] > off })-1, 0)
+	partStart := r.starts[i]
+	partEnd := r.totalSize
+	if i < last {
+		partEnd = r.starts[i+1]
+	}
+	return i, r.headerSize + off - partStart, partEnd - off
+}
+
+// missingAfter reports the parts that must follow the last one found for a
+// virtual offset to exist, for example the part holding the metadata.
+func (r *SplitReader) missingAfter(off int64) error {
+	if off < r.totalSize || r.partSize == 0 {
+		return nil
+	}
+	err := &PartsError{}
+	for i, f := range r.parts {
+		if f == nil {
+			err.Parts = append(err.Parts, r.missing(i))
 		}
 	}
-	
+	for i := len(r.parts); int64(i)*r.partSize <= off; i++ {
+		err.Parts = append(err.Parts, r.missing(i))
+	}
+	if len(err.Parts) == 0 {
+		// The offset falls in the last part found, which was cut short
+		err.Parts = append(err.Parts, r.status(len(r.parts)-1, ErrPartTruncated))
+	}
+	return err
+}
+
+func (r *SplitReader) Read(p []byte) (n int, err error) {
+	if r.currentOffset >= r.totalSize {
+		return 0, io.EOF
+	}
+	n, err = r.ReadAt(p, r.currentOffset)
+	r.currentOffset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
 	return n, err
 }
 
@@ -302,63 +710,65 @@ func (r *SplitReader) Seek(offset int64, whence int) (int64, error) {
 		abs = r.currentOffset + offset
 	case io.SeekEnd:
 		abs = r.totalSize + offset
+	default:
+		return 0, fmt.Errorf("invalid whence")
 	}
-	
+
 	if abs < 0 {
 		return 0, fmt.Errorf("negative position")
 	}
-	
-	// Find part
-	var currentTotal int64
-	found := false
-	for i, size := range r.sizes {
-		if abs < currentTotal + size {
-			// In this part
-			partOffset := abs - currentTotal
-			r.currentPart = i
-			r.parts[i].Seek(partOffset, io.SeekStart)
-			found = true
-			break
+	if abs > r.totalSize {
+		if err := r.missingAfter(abs); err != nil {
+			return 0, err
 		}
-		currentTotal += size
-	}
-	
-	if !found && abs == r.totalSize {
-		// EOF
-		r.currentPart = len(r.parts) - 1
-		r.parts[r.currentPart].Seek(r.sizes[r.currentPart], io.SeekStart)
-	} else if !found {
 		return 0, fmt.Errorf("seek past end")
 	}
-	
+
 	r.currentOffset = abs
 	return abs, nil
 }
 
 func (r *SplitReader) Close() error {
 	for _, f := range r.parts {
-		f.Close()
+		if f != nil {
+			f.Close()
+		}
 	}
 	return nil
 }
 
+// ReadAt reads from the virtual offset without touching the Seek position,
+// so it is safe for concurrent use like (*os.File).ReadAt.
 func (r *SplitReader) ReadAt(p []byte, off int64) (n int, err error) {
-	// Helper for direct reading, verifying
-	// Not critical for ExtractFile if we use Reader interface, 
-	// but EncryptStream/DecryptStream might need basic Read.
-	
-	// Implement simple Seek+Read safe restoration?
-	// ReadAt shouldn't change state.
-	
-	oldPos := r.currentOffset
-	_, err = r.Seek(off, io.SeekStart)
-	if err != nil {
-		return 0, err
+	if off < 0 {
+		return 0, fmt.Errorf("negative position")
 	}
-	n, err = r.Read(p)
-	
-	r.Seek(oldPos, io.SeekStart)
-	return n, err
+
+	for len(p) > 0 && off < r.totalSize {
+		i, fileOffset, toRead := r.locate(off)
+		if r.parts[i] == nil {
+			return n, &PartsError{Parts: []PartStatus{r.missing(i)}}
+		}
+		if toRead > int64(len(p)) {
+			toRead = int64(len(p))
+		}
+		m, err := r.parts[i].ReadAt(p[:toRead], fileOffset)
+		n += m
+		if int64(m) < toRead {
+			if err == io.EOF {
+				return n, &PartsError{Parts: []PartStatus{r.status(i, ErrPartTruncated)}}
+			}
+			return n, err
+		}
+
+		p = p[m:]
+		off += int64(m)
+	}
+
+	if len(p) > 0 {
+		return n, io.EOF
+	}
+	return n, nil
 }
 
 func (r *SplitReader) Sync() error { return nil }
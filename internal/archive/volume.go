@@ -0,0 +1,131 @@
+package archive
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	VolumeMagic      = "CHNV"
+	VolumeVersion    = 1
+	VolumeHeaderSize = 40
+)
+
+// Volume flags.
+const (
+	VolumeIndependent = 1 << iota // The part ends with a volume index and can be read on its own
+)
+
+// VolumeHeader starts every part of a split archive (since v9), so parts are
+// recognised by content rather than by file name:
+// [Magic 4][Version 2][Flags 2][ArchiveID 16][Index 4][Count 4][PartSize 8]
+// PartSize is the size of every part but the last, without its volume header.
+// For independent volumes it is the most a part may hold, index included.
+type VolumeHeader struct {
+	Flags     uint16
+	ArchiveID [16]byte
+	Index     uint32
+	Count     uint32 // 0 until the archive is finalized
+	PartSize  uint64
+}
+
+// Independent reports whether the part carries a volume index.
+func (v VolumeHeader) Independent() bool {
+	return v.Flags&VolumeIndependent != 0
+}
+
+func (v VolumeHeader) Serialize() []byte {
+	buf := make([]byte, VolumeHeaderSize)
+	copy(buf, VolumeMagic)
+	binary.BigEndian.PutUint16(buf[4:6], VolumeVersion)
+	binary.BigEndian.PutUint16(buf[6:8], v.Flags)
+	copy(buf[8:24], v.ArchiveID[:])
+	binary.BigEndian.PutUint32(buf[24:28], v.Index)
+	binary.BigEndian.PutUint32(buf[28:32], v.Count)
+	binary.BigEndian.PutUint64(buf[32:40], v.PartSize)
+	return buf
+}
+
+// DeserializeVolumeHeader decodes a volume header. It fails with ErrInvalidFormat
+// for anything else, such as the header of an archive packed before v9.
+func DeserializeVolumeHeader(data []byte) (*VolumeHeader, error) {
+	if len(data) < VolumeHeaderSize || string(data[:4]) != VolumeMagic {
+		return nil, ErrInvalidFormat
+	}
+	if binary.BigEndian.Uint16(data[4:6]) != VolumeVersion {
+		return nil, ErrInvalidVersion
+	}
+	v := &VolumeHeader{
+		Flags:    binary.BigEndian.Uint16(data[6:8]),
+		Index:    binary.BigEndian.Uint32(data[24:28]),
+		Count:    binary.BigEndian.Uint32(data[28:32]),
+		PartSize: binary.BigEndian.Uint64(data[32:40]),
+	}
+	copy(v.ArchiveID[:], data[8:24])
+	if v.PartSize == 0 {
+		return nil, ErrInvalidFormat
+	}
+	return v, nil
+}
+
+// readVolumeHeader reads the volume header at the start of f.
+func readVolumeHeader(f io.ReaderAt) (*VolumeHeader, error) {
+	buf := make([]byte, VolumeHeaderSize)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		if err == io.EOF {
+			return nil, ErrInvalidFormat
+		}
+		return nil, err
+	}
+	return DeserializeVolumeHeader(buf)
+}
+
+// scanVolumes looks through the directory of basePath for the parts of the
+// archive with the given ID, whatever their names. A file carrying the usual
+// name of a part (.cNN) that is not one of them is returned in foreign.
+func scanVolumes(basePath string, id [16]byte) (found map[int]string, foreign map[int]string, err error) {
+	dir := filepath.Dir(basePath)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	found = map[int]string{}
+	foreign = map[int]string{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if path == filepath.Join(dir, filepath.Base(basePath)) {
+			continue
+		}
+
+		volume, err := ReadVolumeHeader(path)
+		if err == nil && volume.ArchiveID == id && volume.Index > 0 {
+			i := int(volume.Index)
+			// Prefer the usual name when a part was copied
+			if _, ok := found[i]; !ok || path == filepath.Join(dir, filepath.Base(partName(basePath, i))) {
+				found[i] = path
+			}
+			continue
+		}
+		if i, ok := partIndex(basePath, entry.Name()); ok {
+			foreign[i] = path
+		}
+	}
+	return found, foreign, nil
+}
+
+// ReadVolumeHeader reads the volume header of a part of a split archive.
+// It fails with ErrInvalidFormat for an archive packed before v9 or not split.
+func ReadVolumeHeader(path string) (*VolumeHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readVolumeHeader(f)
+}
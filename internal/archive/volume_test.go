@@ -0,0 +1,83 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestVolumeHeaders checks that parts are assembled by identity: renamed parts still work, parts of another archive are rejected
+func TestVolumeHeaders(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "data.bin")
+	data := bytes.Repeat([]byte("volume headers "), 10_000) // 150KB, 4 parts
+	if err := os.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pack := func(dir string) string {
+		os.MkdirAll(dir, 0755)
+		archivePath := filepath.Join(dir, "out.chin")
+		w, err := NewWriter(archivePath, []byte("pw"), 40_000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.AddFile(src, "data.bin"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Finalize(); err != nil {
+			t.Fatal(err)
+		}
+		return archivePath
+	}
+	archivePath := pack(filepath.Join(tmpDir, "a"))
+	other := pack(filepath.Join(tmpDir, "b"))
+
+	first, err := ReadVolumeHeader(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		volume, err := ReadVolumeHeader(partName(archivePath, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if volume.ArchiveID != first.ArchiveID || volume.Index != uint32(i) || volume.Count != 4 {
+			t.Fatalf("part %d: unexpected volume header %+v", i, volume)
+		}
+		if info, _ := os.Stat(partName(archivePath, i)); info.Size() > 40_000 {
+			t.Fatalf("part %d is larger than the split size", i)
+		}
+	}
+
+	// Swap the names of c01 and c02
+	c01, c02 := partName(archivePath, 1), partName(archivePath, 2)
+	os.Rename(c01, c01+".tmp")
+	os.Rename(c02, c01)
+	os.Rename(c01+".tmp", c02)
+
+	r, err := NewReader(archivePath, []byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Verify(); err != nil {
+		t.Fatalf("renamed parts: %v", err)
+	}
+	r.Close()
+
+	// A part of another archive with the same name
+	foreign, _ := os.ReadFile(partName(other, 3))
+	os.WriteFile(partName(archivePath, 3), foreign, 0644)
+	_, err = NewReader(archivePath, []byte("pw"))
+	var partsErr *PartsError
+	if !errors.As(err, &partsErr) || partsErr.Parts[0].Index != 3 || partsErr.Parts[0].Problem != ErrPartForeign {
+		t.Fatalf("expected c03 to be rejected as foreign, got %v", err)
+	}
+
+	// Only the first part can be opened
+	if _, err := NewReader(c01, []byte("pw")); err == nil {
+		t.Fatal("opened an archive from its second part")
+	}
+}
@@ -0,0 +1,74 @@
+package archive
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"chin/internal/crypto"
+)
+
+func isZero(b []byte) bool {
+	return len(b) > 0 && bytes.Equal(b, make([]byte, len(b)))
+}
+
+// TestSecretsWiped checks that writers, readers and the key slot editor zero their keys and passwords when closed
+func TestSecretsWiped(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "a.txt")
+	if err := os.WriteFile(src, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keyfile, _ := crypto.HashKeyfile(bytes.NewReader([]byte("keyfile content")))
+
+	password := []byte("pw")
+	archivePath := filepath.Join(tmpDir, "out.chin")
+	w, err := NewWriter(archivePath, password, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddKeyfile(keyfile); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddFile(src, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	if !isZero(w.masterKey) || !isZero(w.password) || !isZero(w.keyfiles[0]) {
+		t.Fatal("writer secrets not wiped after Finalize")
+	}
+	// The writer works on copies
+	if string(password) != "pw" || isZero(keyfile) {
+		t.Fatal("writer wiped the caller's buffers")
+	}
+
+	creds := Credentials{Password: password, Keyfiles: [][]byte{keyfile}}
+	r, err := NewReaderWithCredentials(archivePath, creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if !isZero(r.masterKey) || !isZero(r.password) || !isZero(r.keyfiles[0]) {
+		t.Fatal("reader secrets not wiped after Close")
+	}
+
+	editor, err := OpenKeySlots(archivePath, creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	editor.Close()
+	if !isZero(editor.archiveKey) {
+		t.Fatal("editor key not wiped after Close")
+	}
+
+	creds.Wipe()
+	if !isZero(password) || !isZero(keyfile) {
+		t.Fatal("credentials not wiped")
+	}
+}
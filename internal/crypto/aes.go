@@ -1,8 +1,6 @@
 package crypto
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
 	"errors"
@@ -49,45 +47,65 @@ func GenerateSalt() ([]byte, error) {
 	return salt, nil
 }
 
+// GenerateKey returns a random 32-byte key (archive keys).
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
 // Encrypt encrypts data in-memory (for Metadata).
 // It uses the standard DeriveKey (PBKDF2) directly.
 func Encrypt(data []byte, password []byte, salt []byte) ([]byte, []byte, error) {
 	key := DeriveKey(password, salt)
+	defer Wipe(key)
+	return EncryptWithKey(data, CipherAESGCM, key, nil, nil)
+}
 
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, nil, err
-	}
+func Decrypt(ciphertext []byte, nonce []byte, password []byte, salt []byte) ([]byte, error) {
+	key := DeriveKey(password, salt)
+	defer Wipe(key)
+	return DecryptWithKey(ciphertext, nonce, CipherAESGCM, key, nil)
+}
 
-	gcm, err := cipher.NewGCM(block)
+// EncryptWithKey encrypts data in-memory with an already derived Master Key.
+// A nil nonce selects a random one; a caller-supplied nonce (reproducible archives)
+// must never be reused for different data under the same key.
+// aad is authenticated but not encrypted (nil for none).
+func EncryptWithKey(data []byte, c Cipher, key []byte, nonce []byte, aad []byte) ([]byte, []byte, error) {
+	aead, err := c.NewAEAD(key)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	nonce := make([]byte, NonceSize)
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, nil, err
+	if nonce == nil {
+		nonce = make([]byte, aead.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, nil, errors.New("invalid nonce size")
 	}
 
-	ciphertext := gcm.Seal(nil, nonce, data, nil)
+	ciphertext := aead.Seal(nil, nonce, data, aad)
 
 	return ciphertext, nonce, nil
 }
 
-func Decrypt(ciphertext []byte, nonce []byte, password []byte, salt []byte) ([]byte, error) {
-	key := DeriveKey(password, salt)
-
-	block, err := aes.NewCipher(key)
+func DecryptWithKey(ciphertext []byte, nonce []byte, c Cipher, key []byte, aad []byte) ([]byte, error) {
+	aead, err := c.NewAEAD(key)
 	if err != nil {
 		return nil, err
 	}
 
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce size")
 	}
 
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := aead.Open(nil, nonce, ciphertext, aad)
 	if err != nil {
 		return nil, ErrInvalidPassword
 	}
@@ -0,0 +1,79 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Cipher identifies the AEAD used for metadata and file streams.
+type Cipher uint8
+
+const (
+	CipherAESGCM    Cipher = 1 // AES-256-GCM, 12-byte nonce
+	CipherXChaCha20 Cipher = 2 // XChaCha20-Poly1305, 24-byte nonce (fast without AES hardware)
+)
+
+// ParseCipher accepts the names used on the command line.
+func ParseCipher(name string) (Cipher, error) {
+	switch strings.ToLower(name) {
+	case "aes-gcm", "aes", "aes-256-gcm":
+		return CipherAESGCM, nil
+	case "xchacha20", "xchacha20-poly1305":
+		return CipherXChaCha20, nil
+	default:
+		return 0, fmt.Errorf("unknown cipher %q (use aes-gcm or xchacha20)", name)
+	}
+}
+
+func (c Cipher) String() string {
+	switch c {
+	case CipherAESGCM:
+		return "aes-256-gcm"
+	case CipherXChaCha20:
+		return "xchacha20-poly1305"
+	default:
+		return fmt.Sprintf("unknown (%d)", uint8(c))
+	}
+}
+
+// Validate rejects unknown cipher ids.
+func (c Cipher) Validate() error {
+	if c != CipherAESGCM && c != CipherXChaCha20 {
+		return fmt.Errorf("unsupported cipher: %d", uint8(c))
+	}
+	return nil
+}
+
+// NonceSize returns the nonce length of the AEAD.
+func (c Cipher) NonceSize() int {
+	if c == CipherXChaCha20 {
+		return chacha20poly1305.NonceSizeX
+	}
+	return NonceSize
+}
+
+// Overhead returns the length of the authentication tag added to each message.
+// Both ciphers use a 16-byte tag.
+func (c Cipher) Overhead() int {
+	return chacha20poly1305.Overhead
+}
+
+// NewAEAD creates the AEAD for a 32-byte key.
+func (c Cipher) NewAEAD(key []byte) (cipher.AEAD, error) {
+	switch c {
+	case CipherAESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case CipherXChaCha20:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, c.Validate()
+	}
+}
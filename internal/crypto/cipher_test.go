@@ -0,0 +1,102 @@
+package crypto
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// TestStreamRoundTrip encrypts and decrypts a multi-chunk stream with every cipher
+func TestStreamRoundTrip(t *testing.T) {
+	key := make([]byte, KeySize)
+	data := bytes.Repeat([]byte("chin"), ChunkSize) // 4 chunks
+
+	for _, c := range []Cipher{CipherAESGCM, CipherXChaCha20} {
+		encrypted := new(bytes.Buffer)
+		if err := EncryptStreamWithKey(bytes.NewReader(data), encrypted, c, key, nil); err != nil {
+			t.Fatalf("%v: %v", c, err)
+		}
+
+		decrypted := new(bytes.Buffer)
+		if err := DecryptStreamWithKey(encrypted, decrypted, c, key); err != nil {
+			t.Fatalf("%v: %v", c, err)
+		}
+		if !bytes.Equal(data, decrypted.Bytes()) {
+			t.Fatalf("%v: round trip mismatch", c)
+		}
+	}
+}
+
+// TestSealStreamTampering checks that truncation, reordering and a different aad are all rejected
+func TestSealStreamTampering(t *testing.T) {
+	key := make([]byte, KeySize)
+	data := bytes.Repeat([]byte("chin"), ChunkSize/2) // 2 full chunks, then an empty final chunk
+	aad := []byte("entry-a")
+
+	sealed := new(bytes.Buffer)
+	if err := SealStream(bytes.NewReader(data), sealed, CipherAESGCM, key, nil, aad); err != nil {
+		t.Fatal(err)
+	}
+	stream := sealed.Bytes()
+
+	decrypted := new(bytes.Buffer)
+	if err := OpenStream(bytes.NewReader(stream), decrypted, CipherAESGCM, key, aad); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, decrypted.Bytes()) {
+		t.Fatal("round trip mismatch")
+	}
+
+	if err := OpenStream(bytes.NewReader(stream), io.Discard, CipherAESGCM, key, []byte("entry-b")); err == nil {
+		t.Fatal("stream accepted with another aad")
+	}
+
+	// Cut after the first chunk: every chunk boundary used to be a valid end
+	chunk := 4 + ChunkSize + 16
+	if err := OpenStream(bytes.NewReader(stream[:SaltSize+chunk]), io.Discard, CipherAESGCM, key, aad); err != ErrTruncated {
+		t.Fatalf("expected ErrTruncated, got %v", err)
+	}
+
+	// Swap the two full chunks
+	swapped := append([]byte{}, stream[:SaltSize]...)
+	swapped = append(swapped, stream[SaltSize+chunk:SaltSize+2*chunk]...)
+	swapped = append(swapped, stream[SaltSize:SaltSize+chunk]...)
+	swapped = append(swapped, stream[SaltSize+2*chunk:]...)
+	if err := OpenStream(bytes.NewReader(swapped), io.Discard, CipherAESGCM, key, aad); err == nil {
+		t.Fatal("reordered chunks accepted")
+	}
+}
+
+// TestSealedSize compares SealedSize with what SealStream writes, around chunk boundaries
+func TestSealedSize(t *testing.T) {
+	key := make([]byte, KeySize)
+	for _, c := range []Cipher{CipherAESGCM, CipherXChaCha20} {
+		for _, n := range []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3 * ChunkSize} {
+			sealed := new(bytes.Buffer)
+			if err := SealStream(bytes.NewReader(make([]byte, n)), sealed, c, key, nil, nil); err != nil {
+				t.Fatal(err)
+			}
+			if got := SealedSize(c, int64(n)); got != int64(sealed.Len()) {
+				t.Fatalf("%v, %d bytes: SealedSize %d, SealStream wrote %d", c, n, got, sealed.Len())
+			}
+		}
+	}
+}
+
+func benchmarkStream(b *testing.B, c Cipher) {
+	key := make([]byte, KeySize)
+	data := make([]byte, 16*1024*1024)
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := SealStream(bytes.NewReader(data), io.Discard, c, key, nil, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Compare with: go test -bench Stream ./internal/crypto
+// On CPUs without AES instructions XChaCha20-Poly1305 is usually several times faster.
+func BenchmarkStreamAESGCM(b *testing.B)    { benchmarkStream(b, CipherAESGCM) }
+func BenchmarkStreamXChaCha20(b *testing.B) { benchmarkStream(b, CipherXChaCha20) }
@@ -0,0 +1,115 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// KDF algorithm identifiers stored in the archive header.
+const (
+	KDFPBKDF2   uint8 = 1 // PBKDF2-HMAC-SHA256
+	KDFArgon2id uint8 = 2
+)
+
+// Upper bounds accepted from archive headers, so a crafted header
+// cannot make the reader allocate or spin without limit.
+const (
+	MaxPBKDF2Iterations = 50_000_000
+	MaxArgon2Iterations = 1_000
+	MaxArgon2Memory     = 4 * 1024 * 1024 // KiB (4 GiB)
+)
+
+// Argon2id defaults (RFC 9106, second recommended option).
+const (
+	Argon2Iterations  = 3
+	Argon2Memory      = 64 * 1024 // KiB
+	Argon2Parallelism = 4
+)
+
+var ErrUnsupportedKDF = errors.New("unsupported key derivation function")
+
+// KDFParams describes how the master key is derived from the password.
+type KDFParams struct {
+	Algorithm   uint8
+	Iterations  uint32
+	Memory      uint32 // KiB, Argon2id only
+	Parallelism uint8  // Argon2id only
+}
+
+// DefaultKDF returns the PBKDF2 parameters used by DeriveKey.
+func DefaultKDF() KDFParams {
+	return KDFParams{Algorithm: KDFPBKDF2, Iterations: Iter}
+}
+
+// DefaultArgon2id returns the default Argon2id parameters.
+func DefaultArgon2id() KDFParams {
+	return KDFParams{
+		Algorithm:   KDFArgon2id,
+		Iterations:  Argon2Iterations,
+		Memory:      Argon2Memory,
+		Parallelism: Argon2Parallelism,
+	}
+}
+
+// Validate rejects unknown algorithms and unreasonable costs.
+func (p KDFParams) Validate() error {
+	switch p.Algorithm {
+	case KDFPBKDF2:
+		if p.Iterations == 0 || p.Iterations > MaxPBKDF2Iterations {
+			return fmt.Errorf("invalid PBKDF2 iterations: %d", p.Iterations)
+		}
+	case KDFArgon2id:
+		if p.Iterations == 0 || p.Iterations > MaxArgon2Iterations {
+			return fmt.Errorf("invalid Argon2id iterations: %d", p.Iterations)
+		}
+		if p.Memory < 8*uint32(p.Parallelism) || p.Memory > MaxArgon2Memory {
+			return fmt.Errorf("invalid Argon2id memory: %d KiB", p.Memory)
+		}
+		if p.Parallelism == 0 {
+			return errors.New("invalid Argon2id parallelism: 0")
+		}
+	default:
+		return ErrUnsupportedKDF
+	}
+	return nil
+}
+
+// String returns a short human readable description.
+func (p KDFParams) String() string {
+	switch p.Algorithm {
+	case KDFPBKDF2:
+		return fmt.Sprintf("pbkdf2-sha256 (iterations=%d)", p.Iterations)
+	case KDFArgon2id:
+		return fmt.Sprintf("argon2id (t=%d, m=%dKiB, p=%d)", p.Iterations, p.Memory, p.Parallelism)
+	default:
+		return fmt.Sprintf("unknown (%d)", p.Algorithm)
+	}
+}
+
+// DeriveWithKeyfiles mixes keyfile digests into the password (see MixKeyfiles)
+// before deriving, and wipes the mixed input afterwards.
+func (p KDFParams) DeriveWithKeyfiles(password []byte, keyfiles [][]byte, salt []byte) ([]byte, error) {
+	if len(keyfiles) == 0 {
+		return p.Derive(password, salt)
+	}
+	mixed := MixKeyfiles(password, keyfiles)
+	defer Wipe(mixed)
+	return p.Derive(mixed, salt)
+}
+
+// Derive derives a 32-byte Master Key from password and salt.
+func (p KDFParams) Derive(password []byte, salt []byte) ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	switch p.Algorithm {
+	case KDFArgon2id:
+		return argon2.IDKey(password, salt, p.Iterations, p.Memory, p.Parallelism, KeySize), nil
+	default:
+		return pbkdf2.Key(password, salt, int(p.Iterations), KeySize, sha256.New), nil
+	}
+}
@@ -0,0 +1,49 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+)
+
+var ErrKeyfileRequired = errors.New("archive requires a keyfile (--keyfile)")
+
+// HashKeyfile reads a keyfile and returns the digest that is mixed into the KDF input.
+// Any file can be a keyfile; only its content matters, not its name.
+func HashKeyfile(r io.Reader) ([]byte, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errors.New("keyfile is empty")
+	}
+	return h.Sum(nil), nil
+}
+
+// MixKeyfiles combines a password with keyfile digests into the KDF input.
+// The result does not depend on the order of the keyfiles. Without keyfiles
+// the password is returned unchanged, so existing archives keep working.
+func MixKeyfiles(password []byte, keyfiles [][]byte) []byte {
+	if len(keyfiles) == 0 {
+		return password
+	}
+
+	sorted := make([][]byte, len(keyfiles))
+	copy(sorted, keyfiles)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+
+	h := sha256.New()
+	h.Write([]byte("chin-keyfiles-v7"))
+	binary.Write(h, binary.BigEndian, uint64(len(password)))
+	h.Write(password)
+	for _, digest := range sorted {
+		binary.Write(h, binary.BigEndian, uint64(len(digest)))
+		h.Write(digest)
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,38 @@
+package crypto
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// WrappedKeySize is the size of an archive key sealed by WrapKey.
+const WrappedKeySize = KeySize + chacha20poly1305.Overhead
+
+var ErrUnwrapFailed = errors.New("key unwrap failed")
+
+// WrapKey seals an archive key with ChaCha20-Poly1305 under wrapKey.
+// Every wrap key is derived from a fresh salt or ephemeral key and used
+// exactly once, so the nonce is fixed to zero.
+func WrapKey(wrapKey []byte, archiveKey []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(wrapKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	return aead.Seal(nil, nonce, archiveKey, nil), nil
+}
+
+// UnwrapKey opens a key sealed by WrapKey.
+func UnwrapKey(wrapKey []byte, wrapped []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(wrapKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	key, err := aead.Open(nil, nonce, wrapped, nil)
+	if err != nil {
+		return nil, ErrUnwrapFailed
+	}
+	return key, nil
+}
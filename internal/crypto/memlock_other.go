@@ -0,0 +1,5 @@
+//go:build !unix
+
+package crypto
+
+func lockMemory(b []byte) {}
@@ -0,0 +1,9 @@
+//go:build unix
+
+package crypto
+
+import "golang.org/x/sys/unix"
+
+func lockMemory(b []byte) {
+	_ = unix.Mlock(b)
+}
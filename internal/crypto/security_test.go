@@ -52,3 +52,30 @@ func TestSaltUniqueness(t *testing.T) {
 		t.Fatal("Ciphertext should be different with different salts")
 	}
 }
+
+// TestWipe checks that secrets are zeroed and that clones are independent copies
+func TestWipe(t *testing.T) {
+	secret := []byte("correct horse battery staple")
+	clone := CloneSecret(secret)
+
+	Wipe(secret)
+	if !bytes.Equal(secret, make([]byte, len(secret))) {
+		t.Fatal("secret not wiped")
+	}
+	if !bytes.Equal(clone, []byte("correct horse battery staple")) {
+		t.Fatal("wiping the original changed the clone")
+	}
+
+	// The mixed keyfile input is wiped, the caller's password is not
+	params := KDFParams{Algorithm: KDFPBKDF2, Iterations: 1000}
+	keyfile, _ := HashKeyfile(strings.NewReader("keyfile"))
+	salt := make([]byte, SaltSize)
+	key, err := params.DeriveWithKeyfiles(clone, [][]byte{keyfile}, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := params.Derive(MixKeyfiles(clone, [][]byte{keyfile}), salt)
+	if !bytes.Equal(key, want) {
+		t.Fatal("DeriveWithKeyfiles differs from Derive(MixKeyfiles)")
+	}
+}
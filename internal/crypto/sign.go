@@ -0,0 +1,47 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+)
+
+// Text encodings of Ed25519 signing keys, as written by "chin keygen --sign".
+// The secret key is the 32-byte seed.
+const (
+	SigningPublicKeyPrefix = "chin-ed25519-pub:"
+	SigningSecretKeyPrefix = "CHIN-ED25519-SECRET:"
+)
+
+// GenerateEd25519 creates a new signing key pair and returns (seed, public).
+func GenerateEd25519() ([]byte, []byte, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return private.Seed(), public, nil
+}
+
+// EncodeSigningPublicKey returns the text form of a signing public key.
+func EncodeSigningPublicKey(public []byte) string {
+	return SigningPublicKeyPrefix + encodeKey(public)
+}
+
+// EncodeSigningSecretKey returns the text form of a signing key seed.
+func EncodeSigningSecretKey(seed []byte) string {
+	return SigningSecretKeyPrefix + encodeKey(seed)
+}
+
+// ParseSigningPublicKey decodes the text form of a signing public key.
+func ParseSigningPublicKey(s string) (ed25519.PublicKey, error) {
+	return parseKey(strings.TrimSpace(s), SigningPublicKeyPrefix)
+}
+
+// ParseSigningSecretKey decodes the text form of a signing key.
+func ParseSigningSecretKey(s string) (ed25519.PrivateKey, error) {
+	seed, err := parseKey(strings.TrimSpace(s), SigningSecretKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
@@ -1,8 +1,6 @@
 package crypto
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"encoding/binary"
 	"errors"
 	"io"
@@ -10,8 +8,15 @@ import (
 
 const (
 	ChunkSize = 64 * 1024 // 64KB chunks
+
+	// finalChunk marks the last chunk in the length field of a sealed stream.
+	finalChunk = 1 << 31
+	// maxChunkSize bounds the chunk length accepted from an archive.
+	maxChunkSize = ChunkSize + 256
 )
 
+var ErrTruncated = errors.New("encrypted stream is truncated")
+
 // EncryptStream encrypts data from r to w using the given password and master salt.
 // Format:
 // [FileSalt 16 bytes]
@@ -20,40 +25,50 @@ const (
 // [Chunk N: Length (4 bytes) + Ciphertext + Tag]
 // [Terminator: Length 0 (4 bytes)]
 func EncryptStream(r io.Reader, w io.Writer, password []byte, masterSalt []byte) error {
-	// 1. Derive Master Key from Password + MasterSalt (Slow, done once per archive, but here we do it per file context effectively if not cached. 
-	// Optimization: Caller could pass MasterKey, but for now we follow existing API signature which passes password/salt)
 	masterKey := DeriveKey(password, masterSalt)
+	defer Wipe(masterKey)
+	return EncryptStreamWithKey(r, w, CipherAESGCM, masterKey, nil)
+}
 
-	// 2. Generate File Salt (Random 16 bytes)
-	fileSalt, err := GenerateSalt()
-	if err != nil {
-		return err
+// EncryptStreamWithKey is EncryptStream with an already derived Master Key, so the
+// slow KDF runs once per archive instead of once per file.
+// A nil fileSalt selects a random one; a caller-supplied salt (reproducible archives)
+// selects the file key, so it must never repeat for different plaintext.
+func EncryptStreamWithKey(r io.Reader, w io.Writer, c Cipher, masterKey []byte, fileSalt []byte) error {
+	// 1. Generate File Salt (Random 16 bytes)
+	if fileSalt == nil {
+		var err error
+		fileSalt, err = GenerateSalt()
+		if err != nil {
+			return err
+		}
+	}
+	if len(fileSalt) != SaltSize {
+		return errors.New("invalid file salt size")
 	}
 
-	// 3. Write File Salt to stream header
+	// 2. Write File Salt to stream header
 	if _, err := w.Write(fileSalt); err != nil {
 		return err
 	}
 
-	// 4. Derive File Key (Fast)
+	// 3. Derive File Key (Fast)
 	fileKey, err := DeriveStreamKey(masterKey, fileSalt)
 	if err != nil {
 		return err
 	}
+	defer Wipe(fileKey)
 
-	// 5. Setup GCM
-	block, err := aes.NewCipher(fileKey)
-	if err != nil {
-		return err
-	}
-	gcm, err := cipher.NewGCM(block)
+	// 4. Setup AEAD
+	aead, err := c.NewAEAD(fileKey)
 	if err != nil {
 		return err
 	}
 
 	buf := make([]byte, ChunkSize)
 	chunkIndex := uint64(0)
-	nonce := make([]byte, NonceSize) // 12 bytes, initialized to 0
+	nonce := make([]byte, aead.NonceSize()) // 12 (GCM) or 24 (XChaCha20) bytes, initialized to 0
+	counter := nonce[len(nonce)-8:]
 
 	for {
 		n, err := r.Read(buf)
@@ -61,10 +76,10 @@ func EncryptStream(r io.Reader, w io.Writer, password []byte, masterSalt []byte)
 			// update nonce for this chunk (Big Endian Counter)
 			// We can use the first 8 bytes or last 8 bytes. Standard GCM uses last 4 bytes as counter, but here we control the whole nonce.
 			// Let's use the last 8 bytes for counter to support massive streams.
-			binary.BigEndian.PutUint64(nonce[4:], chunkIndex)
+			binary.BigEndian.PutUint64(counter, chunkIndex)
 
 			// Encrypt
-			ciphertext := gcm.Seal(nil, nonce, buf[:n], nil)
+			ciphertext := aead.Seal(nil, nonce, buf[:n], nil)
 
 			// Write ciphertext length
 			err := binary.Write(w, binary.BigEndian, uint32(len(ciphertext)))
@@ -98,7 +113,12 @@ func EncryptStream(r io.Reader, w io.Writer, password []byte, masterSalt []byte)
 // DecryptStream decrypts data from r to w using the given password and master salt.
 func DecryptStream(r io.Reader, w io.Writer, password []byte, masterSalt []byte) error {
 	masterKey := DeriveKey(password, masterSalt)
+	defer Wipe(masterKey)
+	return DecryptStreamWithKey(r, w, CipherAESGCM, masterKey)
+}
 
+// DecryptStreamWithKey is DecryptStream with an already derived Master Key.
+func DecryptStreamWithKey(r io.Reader, w io.Writer, c Cipher, masterKey []byte) error {
 	// 1. Read File Salt
 	fileSalt := make([]byte, SaltSize)
 	if _, err := io.ReadFull(r, fileSalt); err != nil {
@@ -110,19 +130,16 @@ func DecryptStream(r io.Reader, w io.Writer, password []byte, masterSalt []byte)
 	if err != nil {
 		return err
 	}
+	defer Wipe(fileKey)
 
-	block, err := aes.NewCipher(fileKey)
-	if err != nil {
-		return err
-	}
-
-	gcm, err := cipher.NewGCM(block)
+	aead, err := c.NewAEAD(fileKey)
 	if err != nil {
 		return err
 	}
 
 	chunkIndex := uint64(0)
-	nonce := make([]byte, NonceSize) // Init 0
+	nonce := make([]byte, aead.NonceSize()) // Init 0
+	counter := nonce[len(nonce)-8:]
 	
 	// Max allowed chunk size check
 	const MaxChunkSize = ChunkSize + 256 
@@ -132,7 +149,8 @@ func DecryptStream(r io.Reader, w io.Writer, password []byte, masterSalt []byte)
 		var length uint32
 		err := binary.Read(r, binary.BigEndian, &length)
 		if err == io.EOF {
-			break
+			// The writer always ends with a terminator
+			return ErrTruncated
 		}
 		if err != nil {
 			return err
@@ -149,14 +167,17 @@ func DecryptStream(r io.Reader, w io.Writer, password []byte, masterSalt []byte)
 		// Read ciphertext
 		ciphertext := make([]byte, length)
 		if _, err := io.ReadFull(r, ciphertext); err != nil {
+			if err == io.EOF {
+				err = ErrTruncated
+			}
 			return err
 		}
 
 		// Update nonce
-		binary.BigEndian.PutUint64(nonce[4:], chunkIndex)
+		binary.BigEndian.PutUint64(counter, chunkIndex)
 
 		// Decrypt
-		plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+		plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
 		if err != nil {
 			return errors.New("decryption failed or invalid password")
 		}
@@ -170,3 +191,152 @@ func DecryptStream(r io.Reader, w io.Writer, password []byte, masterSalt []byte)
 
 	return nil
 }
+
+// SealStream encrypts r to w like EncryptStreamWithKey, following the STREAM
+// construction: every chunk is authenticated with aad, its position (counter
+// in the nonce) and whether it is the last one, so reordering, truncation and
+// splicing chunks from another stream all fail to decrypt.
+// Format:
+// [FileSalt 16 bytes]
+// [Chunk: Length (4 bytes, high bit set on the final chunk) + Ciphertext + Tag]
+// ...
+// The final chunk may be empty (empty input).
+func SealStream(r io.Reader, w io.Writer, c Cipher, masterKey []byte, fileSalt []byte, aad []byte) error {
+	if fileSalt == nil {
+		var err error
+		fileSalt, err = GenerateSalt()
+		if err != nil {
+			return err
+		}
+	}
+	if len(fileSalt) != SaltSize {
+		return errors.New("invalid file salt size")
+	}
+	if _, err := w.Write(fileSalt); err != nil {
+		return err
+	}
+
+	fileKey, err := DeriveStreamKey(masterKey, fileSalt)
+	if err != nil {
+		return err
+	}
+	defer Wipe(fileKey)
+	aead, err := c.NewAEAD(fileKey)
+	if err != nil {
+		return err
+	}
+
+	// Read one chunk ahead to know which chunk is the last
+	current := make([]byte, ChunkSize)
+	next := make([]byte, ChunkSize)
+	n, readErr := io.ReadFull(r, current)
+	var sealed []byte
+	for chunkIndex := uint64(0); ; chunkIndex++ {
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return readErr
+		}
+		final := readErr != nil
+		var m int
+		var nextErr error
+		if !final {
+			m, nextErr = io.ReadFull(r, next)
+			if nextErr == io.EOF {
+				final = true
+			} else if nextErr != nil && nextErr != io.ErrUnexpectedEOF {
+				return nextErr
+			}
+		}
+
+		sealed = aead.Seal(sealed[:0], streamNonce(aead.NonceSize(), chunkIndex, final), current[:n], aad)
+		length := uint32(len(sealed))
+		if final {
+			length |= finalChunk
+		}
+		if err := binary.Write(w, binary.BigEndian, length); err != nil {
+			return err
+		}
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+
+		current, next = next, current
+		n, readErr = m, nextErr
+	}
+}
+
+// SealedSize returns the length of what SealStream writes for n bytes of input.
+func SealedSize(c Cipher, n int64) int64 {
+	chunks := max((n+ChunkSize-1)/ChunkSize, 1)
+	return SaltSize + n + chunks*int64(4+c.Overhead())
+}
+
+// OpenStream decrypts a stream written by SealStream with the same aad.
+// It stops after the final chunk and fails if the stream ends before it.
+func OpenStream(r io.Reader, w io.Writer, c Cipher, masterKey []byte, aad []byte) error {
+	fileSalt := make([]byte, SaltSize)
+	if _, err := io.ReadFull(r, fileSalt); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncated
+		}
+		return err
+	}
+
+	fileKey, err := DeriveStreamKey(masterKey, fileSalt)
+	if err != nil {
+		return err
+	}
+	defer Wipe(fileKey)
+	aead, err := c.NewAEAD(fileKey)
+	if err != nil {
+		return err
+	}
+
+	ciphertext := make([]byte, maxChunkSize)
+	var plaintext []byte
+	for chunkIndex := uint64(0); ; chunkIndex++ {
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return ErrTruncated
+			}
+			return err
+		}
+
+		final := length&finalChunk != 0
+		length &^= finalChunk
+		if length > maxChunkSize {
+			return errors.New("chunk size too large: potential DoS attack")
+		}
+
+		if _, err := io.ReadFull(r, ciphertext[:length]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return ErrTruncated
+			}
+			return err
+		}
+
+		plaintext, err = aead.Open(plaintext[:0], streamNonce(aead.NonceSize(), chunkIndex, final), ciphertext[:length], aad)
+		if err != nil {
+			return errors.New("decryption failed or invalid password")
+		}
+		if _, err := w.Write(plaintext); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
+
+// streamNonce returns [zeros][counter 8][final flag 1].
+func streamNonce(size int, chunkIndex uint64, final bool) []byte {
+	nonce := make([]byte, size)
+	binary.BigEndian.PutUint64(nonce[size-9:size-1], chunkIndex)
+	if final {
+		nonce[size-1] = 1
+	}
+	return nonce
+}
@@ -0,0 +1,39 @@
+package crypto
+
+import "runtime"
+
+// Wipe zeroes secret material (keys, passwords) once it is no longer needed.
+// Go may still hold copies made by the runtime or inside cipher states, so
+// this shortens how long secrets stay in memory rather than guaranteeing it.
+func Wipe(b []byte) {
+	clear(b)
+	// Keep the zeroing from being treated as a dead store
+	runtime.KeepAlive(b)
+}
+
+// WipeAll wipes every slice.
+func WipeAll(bs [][]byte) {
+	for _, b := range bs {
+		Wipe(b)
+	}
+}
+
+// Protect asks the OS to keep b out of swap where available (mlock). It is best
+// effort: failures (for example RLIMIT_MEMLOCK) are ignored. Pages are not
+// unlocked again, since other secrets may share them.
+func Protect(b []byte) {
+	if len(b) > 0 {
+		lockMemory(b)
+	}
+}
+
+// CloneSecret returns a protected copy of b, for callers that wipe their copy independently.
+func CloneSecret(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	c := make([]byte, len(b))
+	copy(c, b)
+	Protect(c)
+	return c
+}
@@ -0,0 +1,151 @@
+package crypto
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Text encodings of X25519 keys, as written by "chin keygen".
+const (
+	PublicKeyPrefix = "chin-x25519-pub:"
+	SecretKeyPrefix = "CHIN-X25519-SECRET:"
+)
+
+var ErrNoMatchingKey = errors.New("no identity matches this archive")
+
+// GenerateX25519 creates a new key pair and returns (private, public).
+func GenerateX25519() ([]byte, []byte, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return priv.Bytes(), priv.PublicKey().Bytes(), nil
+}
+
+// X25519PublicKey returns the public key of a private key.
+func X25519PublicKey(private []byte) ([]byte, error) {
+	priv, err := ecdh.X25519().NewPrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	return priv.PublicKey().Bytes(), nil
+}
+
+// EncodePublicKey returns the text form of a public key.
+func EncodePublicKey(public []byte) string {
+	return PublicKeyPrefix + encodeKey(public)
+}
+
+// EncodeSecretKey returns the text form of a private key.
+func EncodeSecretKey(private []byte) string {
+	return SecretKeyPrefix + encodeKey(private)
+}
+
+// ParsePublicKey decodes the text form of a public key.
+func ParsePublicKey(s string) ([]byte, error) {
+	return parseKey(strings.TrimSpace(s), PublicKeyPrefix)
+}
+
+// ParseSecretKey decodes the text form of a private key.
+func ParseSecretKey(s string) ([]byte, error) {
+	return parseKey(strings.TrimSpace(s), SecretKeyPrefix)
+}
+
+func encodeKey(key []byte) string {
+	return base64.RawURLEncoding.EncodeToString(key)
+}
+
+func parseKey(s, prefix string) ([]byte, error) {
+	if !strings.HasPrefix(s, prefix) {
+		return nil, fmt.Errorf("key must start with %q", prefix)
+	}
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, prefix))
+	if err != nil {
+		return nil, fmt.Errorf("malformed key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("malformed key: expected 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// WrapKeyX25519 seals an archive key for a recipient public key.
+// It returns the ephemeral public key and the wrapped key.
+func WrapKeyX25519(archiveKey []byte, recipient []byte) ([]byte, []byte, error) {
+	pub, err := ecdh.X25519().NewPublicKey(recipient)
+	if err != nil {
+		return nil, nil, err
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	shared, err := ephemeral.ECDH(pub)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer Wipe(shared)
+
+	ephemeralPub := ephemeral.PublicKey().Bytes()
+	wrapKey, err := x25519WrapKey(shared, ephemeralPub, recipient)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer Wipe(wrapKey)
+
+	// The wrap key is unique per ephemeral key
+	wrapped, err := WrapKey(wrapKey, archiveKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ephemeralPub, wrapped, nil
+}
+
+// UnwrapKeyX25519 opens a wrapped archive key with a private key.
+// It returns ErrNoMatchingKey if the key was sealed for someone else.
+func UnwrapKeyX25519(private []byte, ephemeralPub []byte, wrapped []byte) ([]byte, error) {
+	priv, err := ecdh.X25519().NewPrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := ecdh.X25519().NewPublicKey(ephemeralPub)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, ErrNoMatchingKey
+	}
+	defer Wipe(shared)
+
+	wrapKey, err := x25519WrapKey(shared, ephemeralPub, priv.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(wrapKey)
+	archiveKey, err := UnwrapKey(wrapKey, wrapped)
+	if err != nil {
+		return nil, ErrNoMatchingKey
+	}
+	return archiveKey, nil
+}
+
+func x25519WrapKey(shared, ephemeralPub, recipient []byte) ([]byte, error) {
+	salt := make([]byte, 0, len(ephemeralPub)+len(recipient))
+	salt = append(salt, ephemeralPub...)
+	salt = append(salt, recipient...)
+
+	wrapKey := make([]byte, KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte("chin-x25519-v7")), wrapKey); err != nil {
+		return nil, err
+	}
+	return wrapKey, nil
+}